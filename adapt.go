@@ -0,0 +1,57 @@
+package type_walk
+
+import (
+	"reflect"
+	"slices"
+
+	g_reflect "github.com/goccy/go-reflect"
+)
+
+// Adapt returns a new register with the functions in inner adapted to walk with a context of type Outer. Each
+// function is called with the result of calling project on the Outer context.
+//
+// This lets a library of handlers be written once against a narrow context type, then plugged into walks with
+// larger contexts, e.g. using Layer or Merge.
+//
+// Only leaf functions can be adapted - functions registered with RegisterTypeFn, and compile functions for kinds
+// without children. Compile functions for Struct, Array, Slice, Ptr, Map and Interface kinds are not included in the
+// returned register, because the children they walk must be walked with the Outer context. The returned register is
+// strict if inner is, and keeps the conflicts found by inner.
+func Adapt[Outer any, Inner any](inner *Register[Inner], project func(Outer) Inner) *Register[Outer] {
+	outer := &Register[Outer]{strict: inner.strict, errs: slices.Clone(inner.errs)}
+	for _, e := range inner.typeFns {
+		outer.appendTypeFn(typeFnEntry[Outer]{
+			t:      e.t,
+			fn:     adaptWalkFn(e.fn, project),
+			convFn: e.convFn,
+			site:   e.site,
+		})
+	}
+	for k, fnPtr := range inner.compileFns {
+		if fnPtr == nil || hasChildren(reflect.Kind(k)) {
+			continue
+		}
+		innerFn := castTo[compileFn[Inner]](fnPtr)
+		outerFn := compileFn[Outer](func(t reflect.Type) walkFn[Outer] {
+			return adaptWalkFn(innerFn(t), project)
+		})
+		_, outer.compileFns[k] = g_reflect.TypeAndPtrOf(outerFn)
+		outer.compileSites[k] = inner.compileSites[k]
+	}
+	return outer
+}
+
+func adaptWalkFn[Outer any, Inner any](fn walkFn[Inner], project func(Outer) Inner) walkFn[Outer] {
+	return func(ctx Outer, a arg) error {
+		return fn(project(ctx), a)
+	}
+}
+
+func hasChildren(k reflect.Kind) bool {
+	switch k {
+	case reflect.Struct, reflect.Array, reflect.Slice, reflect.Ptr, reflect.Map, reflect.Interface:
+		return true
+	default:
+		return false
+	}
+}
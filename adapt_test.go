@@ -0,0 +1,95 @@
+package type_walk_test
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tw "github.com/zolstein/type-walk"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdapt(t *testing.T) {
+	// A reusable library of leaf handlers, which only need an io.Writer.
+	lib := tw.NewRegister[io.Writer]()
+	tw.RegisterTypeFn(lib, func(w io.Writer, v tw.Arg[time.Duration]) error {
+		_, err := fmt.Fprintf(w, "%v", v.Get())
+		return err
+	})
+	tw.RegisterTypeFn(lib, func(w io.Writer, v tw.Arg[fmt.Stringer]) error {
+		_, err := fmt.Fprintf(w, "<%v>", v.Get())
+		return err
+	})
+	tw.RegisterCompileIntFn(lib, func(typ reflect.Type) tw.WalkFn[io.Writer, int] {
+		return func(w io.Writer, v tw.Int) error {
+			_, err := fmt.Fprintf(w, "%d", v.Get())
+			return err
+		}
+	})
+	tw.RegisterCompileSliceFn(lib, func(typ reflect.Type) tw.WalkSliceFn[io.Writer] {
+		return func(w io.Writer, s tw.Slice[io.Writer]) error {
+			_, err := fmt.Fprint(w, "lib slice")
+			return err
+		}
+	})
+
+	type Ctx struct {
+		W     *strings.Builder
+		Depth int
+	}
+	register := tw.Adapt(lib, func(ctx Ctx) io.Writer { return ctx.W })
+	_, ok := register.Lookup(reflect.TypeOf([]int{}))
+	assert.False(t, ok, "compile functions for kinds with children are not adapted")
+
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[Ctx] {
+		for i := 0; i < typ.NumField(); i++ {
+			sfr.RegisterField(i)
+		}
+		return func(ctx Ctx, s tw.Struct[Ctx]) error {
+			fmt.Fprintf(ctx.W, "%d{", ctx.Depth)
+			ctx.Depth++
+			for i := 0; i < s.NumFields(); i++ {
+				if i > 0 {
+					ctx.W.WriteString(",")
+				}
+				if err := s.Field(i).Walk(ctx); err != nil {
+					return err
+				}
+			}
+			ctx.W.WriteString("}")
+			return nil
+		}
+	})
+	tw.RegisterCompileMapFn(register, func(typ reflect.Type) tw.WalkMapFn[Ctx] {
+		return func(ctx Ctx, m tw.Map[Ctx]) error {
+			iter := m.Iter()
+			for iter.Next() {
+				if err := iter.Entry().Value().Walk(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+
+	type Inner struct {
+		N int
+	}
+	type Outer struct {
+		D time.Duration
+		I Inner
+		M map[int]fmt.Stringer
+	}
+
+	var sb strings.Builder
+	err := tw.NewWalker(register).Walk(Ctx{W: &sb}, Outer{
+		D: time.Second,
+		I: Inner{N: 5},
+		M: map[int]fmt.Stringer{1: StringWrapper("abc")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "0{1s,1{5},<abc>}", sb.String())
+}
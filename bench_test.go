@@ -2,6 +2,7 @@ package type_walk_test
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/require"
 	tw "github.com/zolstein/type-walk"
 	"math/rand"
@@ -9,6 +10,7 @@ import (
 	"runtime"
 	"strconv"
 	"testing"
+	"unsafe"
 )
 
 func BenchmarkSimpleJsonSerialize(b *testing.B) {
@@ -227,3 +229,223 @@ func randString(length int) string {
 	}
 	return string(bs)
 }
+
+func BenchmarkWalkEntry(b *testing.B) {
+	type Point struct {
+		X, Y int
+	}
+
+	register := tw.NewRegister[*int]()
+	tw.RegisterTypeFn(register, func(ctx *int, i tw.Int) error {
+		*ctx += i.Get()
+		return nil
+	})
+	tw.RegisterCompileStructFn(register, func(r reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*int] {
+		for i := 0; i < r.NumField(); i++ {
+			sfr.RegisterField(i)
+		}
+		return func(ctx *int, s tw.Struct[*int]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	walker := tw.NewWalker(register)
+	p := Point{X: 1, Y: 2}
+	typ := reflect.TypeOf(p)
+
+	var sum int
+	b.Run("Walk", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = walker.Walk(&sum, p)
+		}
+	})
+	b.Run("WalkValue", func(b *testing.B) {
+		v := reflect.ValueOf(&p).Elem()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = walker.WalkValue(&sum, v)
+		}
+	})
+	b.Run("WalkUnsafe", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = walker.WalkUnsafe(&sum, typ, unsafe.Pointer(&p), false)
+		}
+	})
+}
+
+func BenchmarkInterfaceDispatch(b *testing.B) {
+	type A struct{ X int }
+	type B struct{ X, Y int }
+	type C struct{ X string }
+	type D struct{ X []int }
+	type E struct{ X, Y string }
+
+	register := tw.NewRegister[*int]()
+	tw.RegisterTypeFn(register, func(ctx *int, i tw.Int) error {
+		*ctx += i.Get()
+		return nil
+	})
+	tw.RegisterTypeFn(register, func(ctx *int, s tw.String) error {
+		*ctx += len(s.Get())
+		return nil
+	})
+	tw.RegisterCompileSliceFn(register, func(r reflect.Type) tw.WalkSliceFn[*int] {
+		return func(ctx *int, s tw.Slice[*int]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompileStructFn(register, func(r reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*int] {
+		for i := 0; i < r.NumField(); i++ {
+			sfr.RegisterField(i)
+		}
+		return func(ctx *int, s tw.Struct[*int]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompileInterfaceFn(register, func(r reflect.Type) tw.WalkInterfaceFn[*int] {
+		return func(ctx *int, i tw.Interface[*int]) error {
+			return i.Walk(ctx)
+		}
+	})
+
+	candidates := []any{A{1}, B{1, 2}, C{"abc"}, D{[]int{1}}, 1, "abc", []int{1}, E{"a", "b"}}
+	for _, numTypes := range []int{1, 4, len(candidates)} {
+		values := make([]any, 1000)
+		for i := range values {
+			values[i] = candidates[rand.Intn(numTypes)]
+		}
+		for _, threadSafe := range []bool{false, true} {
+			var opts []tw.WalkerOpt
+			name := fmt.Sprintf("types=%d", numTypes)
+			if threadSafe {
+				opts = append(opts, tw.WithThreadSafe)
+				name += "/thread-safe"
+			}
+			walker := tw.NewWalker(register, opts...)
+			typeFn, err := tw.TypeFnFor[[]any](walker)
+			require.NoError(b, err)
+			b.Run(name, func(b *testing.B) {
+				var sum int
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					require.NoError(b, typeFn(&sum, &values))
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkFlattenedStructs(b *testing.B) {
+	type Point struct {
+		X, Y, Z int
+	}
+	type Segment struct {
+		From, To Point
+		Label    string
+	}
+	type Path struct {
+		A, B, C Segment
+	}
+
+	register := tw.NewRegister[*int]()
+	tw.RegisterTypeFn(register, func(ctx *int, i tw.Int) error {
+		*ctx += i.Get()
+		return nil
+	})
+	tw.RegisterTypeFn(register, func(ctx *int, s tw.String) error {
+		*ctx += len(s.Get())
+		return nil
+	})
+	tw.RegisterCompileSliceFn(register, func(r reflect.Type) tw.WalkSliceFn[*int] {
+		return func(ctx *int, s tw.Slice[*int]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompileStructFn(register, func(r reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*int] {
+		for i := 0; i < r.NumField(); i++ {
+			sfr.RegisterField(i)
+		}
+		return func(ctx *int, s tw.Struct[*int]) error {
+			for i := 0; i < s.NumFields(); i++ {
+				if err := s.Field(i).Walk(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+
+	paths := make([]Path, 100)
+	for i := range paths {
+		paths[i].B.Label = randString(10)
+		paths[i].C.To.Z = rand.Int()
+	}
+
+	for _, flatten := range []bool{false, true} {
+		var opts []tw.WalkerOpt
+		name := "nested"
+		if flatten {
+			opts = append(opts, tw.WithFlattenedStructs)
+			name = "flattened"
+		}
+		walker := tw.NewWalker(register, opts...)
+		typeFn, err := tw.TypeFnFor[[]Path](walker)
+		require.NoError(b, err)
+		b.Run(name, func(b *testing.B) {
+			var sum int
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, typeFn(&sum, &paths))
+			}
+		})
+	}
+}
+
+func BenchmarkWideStruct(b *testing.B) {
+	type Embedded struct {
+		E int
+	}
+
+	register := tw.NewRegister[*int]()
+	tw.RegisterTypeFn(register, func(ctx *int, i tw.Int) error {
+		*ctx += i.Get()
+		return nil
+	})
+	tw.RegisterCompileStructFn(register, func(r reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*int] {
+		for _, f := range reflect.VisibleFields(r) {
+			if f.Type.Kind() == reflect.Int {
+				sfr.RegisterFieldByIndex(f.Index)
+			}
+		}
+		return func(ctx *int, s tw.Struct[*int]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	walker := tw.NewWalker(register)
+
+	for _, embedded := range []bool{false, true} {
+		fields := make([]reflect.StructField, 64)
+		for i := range fields {
+			fields[i] = reflect.StructField{Name: fmt.Sprintf("F%d", i), Type: reflect.TypeOf(0)}
+		}
+		name := "direct"
+		if embedded {
+			// Promoted through an embedded pointer, so each lookup follows a pointer.
+			fields = append(fields, reflect.StructField{Name: "Embedded", Type: reflect.TypeOf(&Embedded{}), Anonymous: true})
+			name = "embedded-ptr"
+		}
+		typ := reflect.StructOf(fields)
+		v := reflect.New(typ)
+		if embedded {
+			v.Elem().Field(64).Set(reflect.ValueOf(&Embedded{E: 1}))
+		}
+		b.Run(name, func(b *testing.B) {
+			var sum int
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, walker.WalkUnsafe(&sum, typ, v.UnsafePointer(), true))
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package type_walk_test
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tw "github.com/zolstein/type-walk"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newCacheTestRegister() *tw.Register[*strings.Builder] {
+	register := tw.NewRegister[*strings.Builder]()
+	tw.RegisterTypeFn(register, func(ctx *strings.Builder, i tw.Int) error {
+		_, err := fmt.Fprint(ctx, i.Get())
+		return err
+	})
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfw tw.StructFieldRegister) tw.WalkStructFn[*strings.Builder] {
+		fields := make([]reflect.StructField, typ.NumField())
+		for i := range fields {
+			fields[i] = typ.Field(i)
+			sfw.RegisterField(i)
+		}
+		return printStruct(fields)
+	})
+	tw.RegisterCompilePtrFn(register, func(typ reflect.Type) tw.WalkPtrFn[*strings.Builder] {
+		return func(ctx *strings.Builder, p tw.Ptr[*strings.Builder]) error {
+			if p.IsNil() {
+				ctx.WriteString("nil")
+				return nil
+			}
+			return p.Walk(ctx)
+		}
+	})
+	return register
+}
+
+// generatedValue returns a value of a distinct struct type for each n, as a service generating types at runtime would.
+func generatedValue(n int) any {
+	typ := reflect.StructOf([]reflect.StructField{
+		{Name: fmt.Sprintf("F%d", n), Type: reflect.TypeOf(0)},
+	})
+	v := reflect.New(typ).Elem()
+	v.Field(0).SetInt(int64(n))
+	return v.Interface()
+}
+
+func TestCacheBounded(t *testing.T) {
+	for _, opts := range [][]tw.WalkerOpt{{}, {tw.WithThreadSafe}} {
+		walker := tw.NewWalker(newCacheTestRegister(), append(opts, tw.WithMaxCacheEntries(4))...)
+		for i := 0; i < 20; i++ {
+			var sb strings.Builder
+			err := walker.Walk(&sb, generatedValue(i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("{F%d:%d}", i, i), sb.String())
+
+			stats := walker.CacheStats()
+			assert.LessOrEqual(t, stats.Entries, 4)
+		}
+		stats := walker.CacheStats()
+		assert.Equal(t, uint64(20), stats.Misses)
+		assert.Equal(t, uint64(16), stats.Evictions)
+		assert.Positive(t, stats.CompileTime)
+	}
+}
+
+func TestCacheBoundedRecency(t *testing.T) {
+	for _, opts := range [][]tw.WalkerOpt{{}, {tw.WithThreadSafe}} {
+		walker := tw.NewWalker(newCacheTestRegister(), append(opts, tw.WithMaxCacheEntries(2))...)
+		a, b, c := generatedValue(0), generatedValue(1), generatedValue(2)
+		require.NoError(t, walker.Walk(&strings.Builder{}, a))
+		require.NoError(t, walker.Walk(&strings.Builder{}, b))
+		// Looking up a makes b the entry to evict when c is compiled.
+		require.NoError(t, walker.Walk(&strings.Builder{}, a))
+		require.NoError(t, walker.Walk(&strings.Builder{}, c))
+		assert.Equal(t, uint64(1), walker.CacheStats().Evictions)
+
+		require.NoError(t, walker.Walk(&strings.Builder{}, a))
+		assert.Equal(t, uint64(3), walker.CacheStats().Misses)
+		require.NoError(t, walker.Walk(&strings.Builder{}, b))
+		assert.Equal(t, uint64(4), walker.CacheStats().Misses)
+	}
+}
+
+func TestCacheBoundedRecursive(t *testing.T) {
+	type Node struct {
+		Val  int
+		Next *Node
+	}
+	for _, opts := range [][]tw.WalkerOpt{{}, {tw.WithThreadSafe}} {
+		walker := tw.NewWalker(newCacheTestRegister(), append(opts, tw.WithMaxCacheEntries(1))...)
+		typeFn, err := tw.TypeFnFor[Node](walker)
+		require.NoError(t, err)
+
+		// Compiling other types evicts Node and *Node, but typeFn must keep working.
+		for i := 0; i < 5; i++ {
+			require.NoError(t, walker.Walk(&strings.Builder{}, generatedValue(i)))
+		}
+
+		for i := 0; i < 2; i++ {
+			var sb strings.Builder
+			err = typeFn(&sb, &Node{Val: 1, Next: &Node{Val: 2}})
+			require.NoError(t, err)
+			assert.Equal(t, `{Val:1,Next:{Val:2,Next:nil}}`, sb.String())
+
+			sb.Reset()
+			err = walker.Walk(&sb, Node{Val: 3, Next: &Node{Val: 4}})
+			require.NoError(t, err)
+			assert.Equal(t, `{Val:3,Next:{Val:4,Next:nil}}`, sb.String())
+		}
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	type S struct {
+		A int
+		B *int
+	}
+	for _, opts := range [][]tw.WalkerOpt{{}, {tw.WithThreadSafe}} {
+		walker := tw.NewWalker(newCacheTestRegister(), opts...)
+
+		var sb strings.Builder
+		require.NoError(t, walker.Walk(&sb, S{A: 1}))
+		require.NoError(t, walker.Walk(&sb, S{A: 2}))
+		stats := walker.CacheStats()
+		// S and *int are compiled. int is registered, and is looked up while compiling both of them.
+		assert.Equal(t, 2, stats.Entries)
+		assert.Equal(t, uint64(2), stats.Misses)
+		assert.Equal(t, uint64(3), stats.Hits)
+
+		walker.Purge()
+		assert.Equal(t, 0, walker.CacheStats().Entries)
+
+		require.NoError(t, walker.Walk(&sb, S{A: 3, B: ptr(4)}))
+		require.NoError(t, walker.Walk(&sb, 5))
+		assert.Equal(t, `{A:1,B:nil}{A:2,B:nil}{A:3,B:4}5`, sb.String())
+		stats = walker.CacheStats()
+		assert.Equal(t, 2, stats.Entries)
+		assert.Equal(t, uint64(4), stats.Misses)
+	}
+}
+
+func TestCacheCompileError(t *testing.T) {
+	type Bad struct {
+		A  int
+		P  *Bad
+		Ch chan int
+	}
+	for _, opts := range [][]tw.WalkerOpt{{}, {tw.WithThreadSafe}} {
+		walker := tw.NewWalker(newCacheTestRegister(), opts...)
+		for i := 0; i < 2; i++ {
+			err := walker.Walk(&strings.Builder{}, Bad{})
+			require.Error(t, err)
+			err = walker.Walk(&strings.Builder{}, &Bad{})
+			require.Error(t, err)
+		}
+		assert.Equal(t, 0, walker.CacheStats().Entries)
+	}
+}
+
+func TestCacheInterfaceDispatch(t *testing.T) {
+	register := newCacheTestRegister()
+	tw.RegisterCompileSliceFn(register, func(typ reflect.Type) tw.WalkSliceFn[*strings.Builder] {
+		return func(ctx *strings.Builder, s tw.Slice[*strings.Builder]) error {
+			for i := 0; i < s.Len(); i++ {
+				if i > 0 {
+					ctx.WriteString(",")
+				}
+				if err := s.Elem(i).Walk(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+	tw.RegisterCompileInterfaceFn(register, func(typ reflect.Type) tw.WalkInterfaceFn[*strings.Builder] {
+		return func(ctx *strings.Builder, i tw.Interface[*strings.Builder]) error {
+			return i.Walk(ctx)
+		}
+	})
+
+	// More concrete types than an interface's inline cache holds.
+	var values []any
+	var expected []string
+	for i := 0; i < 6; i++ {
+		values = append(values, generatedValue(i), i)
+		expected = append(expected, fmt.Sprintf("{F%d:%d}", i, i), fmt.Sprint(i))
+	}
+
+	for _, opts := range [][]tw.WalkerOpt{{}, {tw.WithThreadSafe}} {
+		walker := tw.NewWalker(register, opts...)
+		for i := 0; i < 3; i++ {
+			var sb strings.Builder
+			require.NoError(t, walker.Walk(&sb, values))
+			assert.Equal(t, strings.Join(expected, ","), sb.String())
+		}
+		// []any, any and each struct type.
+		assert.Equal(t, uint64(8), walker.CacheStats().Misses)
+
+		// Purging invalidates the inline cache, so the concrete types are compiled again.
+		walker.Purge()
+		require.NoError(t, walker.Walk(&strings.Builder{}, values))
+		assert.Equal(t, uint64(16), walker.CacheStats().Misses)
+	}
+
+	// Evicting a concrete type invalidates the inline cache too, so it's compiled again rather than walked with a
+	// stale function.
+	for _, opts := range [][]tw.WalkerOpt{{}, {tw.WithThreadSafe}} {
+		walker := tw.NewWalker(register, append(opts, tw.WithMaxCacheEntries(4))...)
+		for i := 0; i < 3; i++ {
+			var sb strings.Builder
+			require.NoError(t, walker.Walk(&sb, values))
+			assert.Equal(t, strings.Join(expected, ","), sb.String())
+		}
+		stats := walker.CacheStats()
+		assert.LessOrEqual(t, stats.Entries, 4)
+		assert.Positive(t, stats.Evictions)
+	}
+
+	walker := tw.NewWalker(register, tw.WithThreadSafe)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				var sb strings.Builder
+				assert.NoError(t, walker.Walk(&sb, values))
+				assert.Equal(t, strings.Join(expected, ","), sb.String())
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -6,50 +6,254 @@ import (
 	"github.com/zolstein/sync-map"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
 type simpleCompiler[Ctx any] struct {
-	typeFns         map[g_reflect.Type]*walkFn[Ctx]
+	typeFns         map[g_reflect.Type]*typeEntry[Ctx]
 	compileFns      [numKind]unsafe.Pointer
 	ifaceConvertFns map[g_reflect.Type]ifaceConvertFn
+
+	// maxEntries bounds the number of compiled (not registered) entries kept in typeFns. Zero means unbounded.
+	maxEntries int
+	// hand is the next entry the eviction clock visits. Compiled entries of a bounded cache are linked into a ring,
+	// which hand walks around, evicting entries that haven't been looked up since it last passed them.
+	hand *typeEntry[Ctx]
+	// depth is the number of nested compiles in progress.
+	depth int
+	// pending lists the types compiled during the current top-level compile, so they can be discarded on failure.
+	pending      []g_reflect.Type
+	pendingPurge bool
+	// onEvict is called whenever an entry is removed from typeFns.
+	onEvict func(g_reflect.Type)
+	// onCompile is called for each entry added to typeFns by a top-level compile, once all of them have compiled.
+	onCompile func(g_reflect.Type, *typeEntry[Ctx])
+	// dispatch looks up the functions for the concrete types of interface values while walking. It is the getFn of
+	// the outermost compiler, which may be called concurrently if the Walker is thread-safe.
+	dispatch fnSrc[Ctx]
+	// epoch is incremented whenever compiled functions are purged or evicted, invalidating the inline caches of
+	// interfaces.
+	epoch atomic.Uint64
+	// middleware wraps every walkFn.
+	middleware []Middleware[Ctx]
+	// flattenable caches the result of flatten for each struct type. It is nil unless structs are flattened.
+	flattenable map[g_reflect.Type][][]int
+
+	compiled    int
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	compileTime time.Duration
+}
+
+// typeEntry holds the walkFn for a single type. Compiled walkFns hold pointers to the fn field of the entries for
+// their children, so an entry's fn must never be modified once compiled, even after it has been evicted from the cache.
+type typeEntry[Ctx any] struct {
+	fn walkFn[Ctx]
+	// registered entries come from the Register, and are never evicted.
+	registered bool
+	// referenced is set when the entry is looked up, and cleared when the eviction clock passes it. It is only
+	// maintained for bounded caches.
+	referenced atomic.Bool
+	// t, prev and next link the entry into the eviction clock of a bounded cache.
+	t          g_reflect.Type
+	prev, next *typeEntry[Ctx]
+	// meta is the metadata built while compiling the entry, if its kind has any. It is only used by Walker.Explain.
+	meta any
 }
 
-func newSimpleCompiler[Ctx any](register *Register[Ctx]) *simpleCompiler[Ctx] {
-	typeFns := make(map[g_reflect.Type]*walkFn[Ctx], len(register.typeFns))
-	for i := range register.typeFns {
-		e := register.typeFns[i]
-		typeFns[e.t] = &e.fn
+// entryOf returns the typeEntry containing fn. Every *walkFn handed out by a compiler points to the fn field of a
+// typeEntry, which is its first field.
+func entryOf[Ctx any](fn *walkFn[Ctx]) *typeEntry[Ctx] {
+	return (*typeEntry[Ctx])(unsafe.Pointer(fn))
+}
+
+func newSimpleCompiler[Ctx any](register *Register[Ctx], cfg *walkerConfig) *simpleCompiler[Ctx] {
+	middleware := middlewareFor[Ctx](cfg)
+	typeFns := make(map[g_reflect.Type]*typeEntry[Ctx], len(register.typeFns))
+	for _, e := range register.typeFns {
+		typeFns[e.t] = &typeEntry[Ctx]{fn: applyMiddleware(middleware, e.t, e.fn), registered: true}
 	}
-	ifaceConvertFns := make(map[g_reflect.Type]ifaceConvertFn, len(register.ifaceConvertFns)+1)
-	for _, e := range register.ifaceConvertFns {
-		ifaceConvertFns[e.t] = e.fn
+	ifaceConvertFns := make(map[g_reflect.Type]ifaceConvertFn)
+	for _, e := range register.typeFns {
+		if e.convFn != nil {
+			ifaceConvertFns[e.t] = e.convFn
+		}
 	}
 	ifaceConvertFns[reflectType[any]()] = func(a any) unsafe.Pointer { return unsafe.Pointer(&a) }
-	return &simpleCompiler[Ctx]{
+	c := &simpleCompiler[Ctx]{
 		typeFns:         typeFns,
 		compileFns:      register.compileFns,
 		ifaceConvertFns: ifaceConvertFns,
+		maxEntries:      cfg.maxCacheEntries,
+		middleware:      middleware,
 	}
+	c.dispatch = c.getFn
+	if cfg.flattenStructs {
+		c.flattenable = map[g_reflect.Type][][]int{}
+	}
+	return c
 }
 
 func (c *simpleCompiler[Ctx]) getFn(t g_reflect.Type) (fn *walkFn[Ctx], err error) {
-	fn, ok := c.typeFns[t]
-	if !ok {
-		if t == nil {
-			// This panics, rather than returning an error, because it's an easily preventable user error.
-			// Check for nil before calling Walk!
-			// Maybe we should have a way to specify a handler for a nil interface?
-			panic("cannot compile function for nil type")
+	e, ok := c.typeFns[t]
+	if ok {
+		c.hits++
+		c.touch(e)
+		return &e.fn, nil
+	}
+	if t == nil {
+		// This panics, rather than returning an error, because it's an easily preventable user error.
+		// Check for nil before calling Walk!
+		// Maybe we should have a way to specify a handler for a nil interface?
+		panic("cannot compile function for nil type")
+	}
+	return c.compileEntry(t)
+}
+
+// compileEntry adds an entry for t before compiling it, so recursive types can refer to it while it is compiled.
+func (c *simpleCompiler[Ctx]) compileEntry(t g_reflect.Type) (*walkFn[Ctx], error) {
+	c.misses++
+	e := &typeEntry[Ctx]{t: t}
+	c.typeFns[t] = e
+	c.link(e)
+	c.compiled++
+	c.pending = append(c.pending, t)
+
+	if c.depth > 0 {
+		c.depth++
+		var err error
+		e.fn, err = c.compileFn(t, e)
+		c.depth--
+		return &e.fn, err
+	}
+
+	// Only the top-level compile is timed, since it includes the time spent on any nested compiles.
+	start := time.Now()
+	c.depth++
+	var err error
+	e.fn, err = c.compileFn(t, e)
+	c.depth--
+	c.compileTime += time.Since(start)
+
+	if err != nil {
+		// Compiled walkFns may refer to any entry added during this compile, including the ones that failed, so
+		// discard all of them. Otherwise, later lookups would return incomplete walkFns.
+		for _, pt := range c.pending {
+			c.remove(pt)
 		}
-		fn = new(walkFn[Ctx])
-		c.typeFns[t] = fn
-		*fn, err = c.compileFn(t)
+	} else if c.onCompile != nil {
+		for _, pt := range c.pending {
+			c.onCompile(pt, c.typeFns[pt])
+		}
+	}
+	c.pending = c.pending[:0]
+	if c.pendingPurge {
+		c.pendingPurge = false
+		c.purge()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.evict(e)
+	return &e.fn, nil
+}
+
+func (c *simpleCompiler[Ctx]) touch(e *typeEntry[Ctx]) {
+	if c.maxEntries > 0 && !e.referenced.Load() {
+		e.referenced.Store(true)
+	}
+}
+
+// link adds a newly compiled entry to the eviction clock, just behind the hand, so it's the last entry visited.
+func (c *simpleCompiler[Ctx]) link(e *typeEntry[Ctx]) {
+	if c.maxEntries <= 0 {
+		return
+	}
+	if c.hand == nil {
+		e.prev, e.next = e, e
+		c.hand = e
+		return
+	}
+	e.prev, e.next = c.hand.prev, c.hand
+	e.prev.next = e
+	c.hand.prev = e
+}
+
+func (c *simpleCompiler[Ctx]) remove(t g_reflect.Type) {
+	if e := c.typeFns[t]; e.next != nil {
+		if e.next == e {
+			c.hand = nil
+		} else {
+			if c.hand == e {
+				c.hand = e.next
+			}
+			e.prev.next, e.next.prev = e.next, e.prev
+		}
+		e.prev, e.next = nil, nil
+	}
+	delete(c.typeFns, t)
+	c.compiled--
+	if c.onEvict != nil {
+		c.onEvict(t)
+	}
+}
+
+// evict removes compiled entries until the cache is within its bound. The hand of the clock moves round the entries,
+// clearing the referenced flag of each entry that has been looked up since the hand last passed it, and evicting the
+// first entry that hasn't. keep is never evicted, because it was just requested.
+func (c *simpleCompiler[Ctx]) evict(keep *typeEntry[Ctx]) {
+	if c.maxEntries <= 0 {
+		return
+	}
+	for c.compiled > c.maxEntries {
+		e := c.hand
+		c.hand = e.next
+		if e == keep || e.referenced.Load() {
+			e.referenced.Store(false)
+			continue
+		}
+		c.remove(e.t)
+		c.evictions++
+		c.epoch.Add(1)
+	}
+}
+
+// purge removes all compiled entries. If a compile is in progress, the purge is deferred until it finishes.
+func (c *simpleCompiler[Ctx]) purge() {
+	if c.depth > 0 {
+		c.pendingPurge = true
+		return
+	}
+	for t, e := range c.typeFns {
+		if !e.registered {
+			c.remove(t)
+		}
+	}
+	c.epoch.Add(1)
+}
+
+func (c *simpleCompiler[Ctx]) stats() CacheStats {
+	return CacheStats{
+		Entries:     c.compiled,
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Evictions:   c.evictions,
+		CompileTime: c.compileTime,
+	}
+}
+
+func (c *simpleCompiler[Ctx]) compileFn(t g_reflect.Type, e *typeEntry[Ctx]) (walkFn[Ctx], error) {
+	fn, err := c.compileKindFn(t, e)
+	if err != nil {
+		return nil, err
 	}
-	return fn, err
+	return applyMiddleware(c.middleware, t, fn), nil
 }
 
-func (c *simpleCompiler[Ctx]) compileFn(t g_reflect.Type) (walkFn[Ctx], error) {
+func (c *simpleCompiler[Ctx]) compileKindFn(t g_reflect.Type, e *typeEntry[Ctx]) (walkFn[Ctx], error) {
 	k := t.Kind()
 	fnPtr := c.compileFns[k]
 	if fnPtr == nil {
@@ -57,24 +261,24 @@ func (c *simpleCompiler[Ctx]) compileFn(t g_reflect.Type) (walkFn[Ctx], error) {
 	}
 	switch k {
 	case g_reflect.Array:
-		return c.compileArray(t, castTo[CompileArrayFn[Ctx]](fnPtr))
+		return c.compileArray(t, e, castTo[CompileArrayFn[Ctx]](fnPtr))
 	case g_reflect.Ptr:
-		return c.compilePtr(t, castTo[CompilePtrFn[Ctx]](fnPtr))
+		return c.compilePtr(t, e, castTo[CompilePtrFn[Ctx]](fnPtr))
 	case g_reflect.Slice:
-		return c.compileSlice(t, castTo[CompileSliceFn[Ctx]](fnPtr))
+		return c.compileSlice(t, e, castTo[CompileSliceFn[Ctx]](fnPtr))
 	case g_reflect.Struct:
-		return c.compileStruct(t, castTo[CompileStructFn[Ctx]](fnPtr))
+		return c.compileStruct(t, e, castTo[CompileStructFn[Ctx]](fnPtr))
 	case g_reflect.Map:
-		return c.compileMap(t, castTo[CompileMapFn[Ctx]](fnPtr))
+		return c.compileMap(t, e, castTo[CompileMapFn[Ctx]](fnPtr))
 	case g_reflect.Interface:
-		return c.compileInterface(t, castTo[CompileInterfaceFn[Ctx]](fnPtr))
+		return c.compileInterface(t, e, castTo[CompileInterfaceFn[Ctx]](fnPtr))
 	default:
 		compileFn := castTo[compileFn[Ctx]](fnPtr)
 		return compileFn(g_reflect.ToReflectType(t)), nil
 	}
 }
 
-func (c *simpleCompiler[Ctx]) compileArray(t g_reflect.Type, fn CompileArrayFn[Ctx]) (walkFn[Ctx], error) {
+func (c *simpleCompiler[Ctx]) compileArray(t g_reflect.Type, e *typeEntry[Ctx], fn CompileArrayFn[Ctx]) (walkFn[Ctx], error) {
 	arrayWalkFn := fn(g_reflect.ToReflectType(t))
 	elemFn, err := c.getFn(t.Elem())
 	if err != nil {
@@ -86,13 +290,14 @@ func (c *simpleCompiler[Ctx]) compileArray(t g_reflect.Type, fn CompileArrayFn[C
 		length:   t.Len(),
 		elemFn:   elemFn,
 	}
+	e.meta = &arrayMeta
 	return func(ctx Ctx, arg arg) error {
 		structWalker := Array[Ctx]{meta: &arrayMeta, arg: arg}
 		return arrayWalkFn(ctx, structWalker)
 	}, nil
 }
 
-func (c *simpleCompiler[Ctx]) compilePtr(t g_reflect.Type, fn CompilePtrFn[Ctx]) (walkFn[Ctx], error) {
+func (c *simpleCompiler[Ctx]) compilePtr(t g_reflect.Type, e *typeEntry[Ctx], fn CompilePtrFn[Ctx]) (walkFn[Ctx], error) {
 	ptrWalkFn := fn(g_reflect.ToReflectType(t))
 	elemFn, err := c.getFn(t.Elem())
 	if err != nil {
@@ -102,13 +307,14 @@ func (c *simpleCompiler[Ctx]) compilePtr(t g_reflect.Type, fn CompilePtrFn[Ctx])
 		typ:    t,
 		elemFn: elemFn,
 	}
+	e.meta = &ptrMeta
 	return func(ctx Ctx, arg arg) error {
 		structWalker := Ptr[Ctx]{meta: &ptrMeta, arg: arg}
 		return ptrWalkFn(ctx, structWalker)
 	}, nil
 }
 
-func (c *simpleCompiler[Ctx]) compileSlice(t g_reflect.Type, fn CompileSliceFn[Ctx]) (walkFn[Ctx], error) {
+func (c *simpleCompiler[Ctx]) compileSlice(t g_reflect.Type, e *typeEntry[Ctx], fn CompileSliceFn[Ctx]) (walkFn[Ctx], error) {
 	sliceWalkFn := fn(g_reflect.ToReflectType(t))
 	elemFn, err := c.getFn(t.Elem())
 	if err != nil {
@@ -119,16 +325,20 @@ func (c *simpleCompiler[Ctx]) compileSlice(t g_reflect.Type, fn CompileSliceFn[C
 		elemSize: t.Elem().Size(),
 		elemFn:   elemFn,
 	}
+	e.meta = &sliceMeta
 	return func(ctx Ctx, arg arg) error {
 		structWalker := Slice[Ctx]{meta: &sliceMeta, arg: arg}
 		return sliceWalkFn(ctx, structWalker)
 	}, nil
 }
 
-func (c *simpleCompiler[Ctx]) compileStruct(t g_reflect.Type, fn CompileStructFn[Ctx]) (walkFn[Ctx], error) {
+func (c *simpleCompiler[Ctx]) compileStruct(t g_reflect.Type, e *typeEntry[Ctx], fn CompileStructFn[Ctx]) (walkFn[Ctx], error) {
 	reg := structFieldRegister{
 		typ: t,
 	}
+	if c.flattenable != nil {
+		reg.flatten = c.flatten
+	}
 	structWalkFn := fn(g_reflect.ToReflectType(t), StructFieldRegister{&reg})
 	meta := &structMetadata[Ctx]{
 		typ:       t,
@@ -152,17 +362,65 @@ func (c *simpleCompiler[Ctx]) compileStruct(t g_reflect.Type, fn CompileStructFn
 			return nil, err
 		}
 		meta.fieldInfo[i] = structFieldMetadata[Ctx]{
-			typ:    ft,
-			lookup: lookupFieldFn(offsets),
-			fn:     fn,
+			typ:     ft,
+			index:   idx,
+			offsets: offsets,
+			offset:  offsets[0],
+			fn:      fn,
+		}
+		if len(offsets) > 1 {
+			meta.fieldInfo[i].lookup = lookupFieldFn(offsets)
 		}
 	}
+	e.meta = meta
 	return func(ctx Ctx, arg arg) error {
 		structWalker := Struct[Ctx]{meta: meta, arg: arg}
 		return structWalkFn(ctx, structWalker)
 	}, nil
 }
 
+// flatten returns the indexes, relative to t, of the fields which replace a field of type t when it is flattened into
+// its parent struct, or nil if it isn't flattened. See WithFlattenedStructs.
+func (c *simpleCompiler[Ctx]) flatten(t g_reflect.Type) [][]int {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if indexes, ok := c.flattenable[t]; ok {
+		return indexes
+	}
+	c.flattenable[t] = nil
+	if e, ok := c.typeFns[t]; ok && e.registered {
+		return nil
+	}
+	fnPtr := c.compileFns[reflect.Struct]
+	if fnPtr == nil {
+		return nil
+	}
+	// The fields are the ones t's own CompileStructFn registers, which flattens their types in turn. The WalkStructFn
+	// it returns is never called for flattened fields.
+	reg := structFieldRegister{typ: t, flatten: c.flatten}
+	castTo[CompileStructFn[Ctx]](fnPtr)(g_reflect.ToReflectType(t), StructFieldRegister{&reg})
+	if len(reg.indexes) == 0 {
+		return nil
+	}
+	for _, idx := range reg.indexes {
+		ft := reg.fieldType(idx)
+		switch ft.Kind() {
+		case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.String:
+		case reflect.Struct:
+			if e, ok := c.typeFns[ft]; !(ok && e.registered) {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+	c.flattenable[t] = reg.indexes
+	return reg.indexes
+}
+
 type lookupFn func(arg) arg
 
 func lookupFieldFn(offsets []uintptr) lookupFn {
@@ -171,7 +429,7 @@ func lookupFieldFn(offsets []uintptr) lookupFn {
 			if i >= 1 {
 				// If len(offsets) >= 1, the lookup goes through at least one pointer. In this case, it's necessarily
 				// behind a pointer, and therefore addressable.
-				a.canAddr = true
+				a.flags |= flagCanAddr
 				a.p = *(*unsafe.Pointer)(a.p)
 				if a.p == nil {
 					return arg{}
@@ -183,7 +441,7 @@ func lookupFieldFn(offsets []uintptr) lookupFn {
 	}
 }
 
-func (c *simpleCompiler[Ctx]) compileMap(t g_reflect.Type, fn CompileMapFn[Ctx]) (walkFn[Ctx], error) {
+func (c *simpleCompiler[Ctx]) compileMap(t g_reflect.Type, e *typeEntry[Ctx], fn CompileMapFn[Ctx]) (walkFn[Ctx], error) {
 	mapWalkFn := fn(g_reflect.ToReflectType(t))
 	keyType := t.Key()
 	keyFn, err := c.getFn(keyType)
@@ -206,18 +464,21 @@ func (c *simpleCompiler[Ctx]) compileMap(t g_reflect.Type, fn CompileMapFn[Ctx])
 	if valType.Kind() == reflect.Interface {
 		mapMeta.valConvFn = c.ifaceConvertFns[valType]
 	}
+	e.meta = &mapMeta
 	return func(ctx Ctx, arg arg) error {
 		mapWalker := Map[Ctx]{meta: &mapMeta, arg: arg}
 		return mapWalkFn(ctx, mapWalker)
 	}, nil
 }
 
-func (c *simpleCompiler[Ctx]) compileInterface(t g_reflect.Type, fn CompileInterfaceFn[Ctx]) (walkFn[Ctx], error) {
+func (c *simpleCompiler[Ctx]) compileInterface(t g_reflect.Type, e *typeEntry[Ctx], fn CompileInterfaceFn[Ctx]) (walkFn[Ctx], error) {
 	ifaceWalkFn := fn(g_reflect.ToReflectType(t))
 	ifaceMeta := ifaceMetadata[Ctx]{
 		typ:   t,
-		fnSrc: c.getFn,
+		fnSrc: c.dispatch,
+		cache: &ifaceCache[Ctx]{epoch: &c.epoch, touch: c.maxEntries > 0},
 	}
+	e.meta = &ifaceMeta
 	return func(ctx Ctx, arg arg) error {
 		structWalker := Interface[Ctx]{meta: &ifaceMeta, arg: arg}
 		return ifaceWalkFn(ctx, structWalker)
@@ -225,49 +486,173 @@ func (c *simpleCompiler[Ctx]) compileInterface(t g_reflect.Type, fn CompileInter
 }
 
 type threadSafeCompiler[Ctx any] struct {
-	typeFns sync_map.Map[g_reflect.Type, *walkFn[Ctx]]
-	inner   simpleCompiler[Ctx]
+	typeFns sync_map.Map[g_reflect.Type, *typeEntry[Ctx]]
+	inner   *simpleCompiler[Ctx]
 	m       sync.Mutex
+	// hits counts lookups served from typeFns without taking the lock.
+	hits atomic.Uint64
 }
 
-func newThreadSafeCompiler[Ctx any](register *Register[Ctx]) *threadSafeCompiler[Ctx] {
+func newThreadSafeCompiler[Ctx any](register *Register[Ctx], cfg *walkerConfig) *threadSafeCompiler[Ctx] {
 	c := &threadSafeCompiler[Ctx]{
-		inner:   *newSimpleCompiler[Ctx](register),
-		typeFns: sync_map.Map[g_reflect.Type, *walkFn[Ctx]]{},
+		inner:   newSimpleCompiler[Ctx](register, cfg),
+		typeFns: sync_map.Map[g_reflect.Type, *typeEntry[Ctx]]{},
 	}
-	for t, fn := range c.inner.typeFns {
-		c.typeFns.Store(t, fn)
+	c.inner.onEvict = c.typeFns.Delete
+	c.inner.onCompile = c.typeFns.Store
+	c.inner.dispatch = c.getFn
+	for t, e := range c.inner.typeFns {
+		c.typeFns.Store(t, e)
 	}
 	return c
 }
 
 func (c *threadSafeCompiler[Ctx]) getFn(t g_reflect.Type) (fn *walkFn[Ctx], err error) {
-	// Check typeFns first before grabbing the lock. If it's here, we know it's non-nil, since it only exists in the
-	// inner map until we actually return from this function. (There's no parallel vs recursive case.)
-	fn, ok := c.typeFns.Load(t)
-	if !ok {
-		if t == nil {
-			// This panics, rather than returning an error, because it's an easily preventable user error.
-			// Check for nil before calling Walk!
-			// Maybe we should have a way to specify a handler for a nil interface?
-			panic("cannot compile function for nil type")
-		}
+	// Check typeFns first before grabbing the lock. If it's here, we know it's fully compiled, since it only exists in
+	// the inner map until we actually return from this function. (There's no parallel vs recursive case.)
+	e, ok := c.typeFns.Load(t)
+	if ok {
+		c.hits.Add(1)
+		c.inner.touch(e)
+		return &e.fn, nil
+	}
+	if t == nil {
+		// This panics, rather than returning an error, because it's an easily preventable user error.
+		// Check for nil before calling Walk!
+		// Maybe we should have a way to specify a handler for a nil interface?
+		panic("cannot compile function for nil type")
+	}
 
-		c.m.Lock()
-		defer c.m.Unlock()
-
-		// Check again. If another thread tried to get the same type concurrently, it may have already added it.
-		fn, ok = c.typeFns.Load(t)
-		if !ok {
-			// It's safe to call inner.getFn while holding the lock because no other threads will attempt to read or
-			// update its typeFns map concurrently.
-			// N.b. It might be slightly better to copy the full simpleCompiler.getFn implementation, make it work
-			// directly on typeFns, and explicitly check for nil values while not holding the lock.
-			fn, err = c.inner.getFn(t)
-			if err != nil {
-				c.typeFns.Store(t, fn)
-			}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	// It's safe to call inner.getFn while holding the lock because no other threads will attempt to read or update
+	// its typeFns map concurrently. If another thread tried to get the same type concurrently, it may have already
+	// been added, in which case inner.getFn returns it without compiling. Every entry a successful compile adds is
+	// published to typeFns by onCompile, so later lookups of the types nested in t don't take the lock either.
+	return c.inner.getFn(t)
+}
+
+func (c *threadSafeCompiler[Ctx]) purge() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.inner.purge()
+}
+
+func (c *threadSafeCompiler[Ctx]) stats() CacheStats {
+	c.m.Lock()
+	defer c.m.Unlock()
+	stats := c.inner.stats()
+	stats.Hits += c.hits.Load()
+	return stats
+}
+
+// profiledCompiler makes a Walker with a Profiler safe for concurrent use. The Profiler records how the values of each
+// walk are nested, which compiled functions don't pass to each other, so each walk in progress uses its own
+// simpleCompiler, whose functions share a single profileStack.
+type profiledCompiler[Ctx any] struct {
+	newInner func() *simpleCompiler[Ctx]
+	// typeFns holds functions which take an idle inner compiler, and walk the value with its function for the type.
+	typeFns sync_map.Map[g_reflect.Type, *typeEntry[Ctx]]
+	m       sync.Mutex
+	idle    []*pooledCompiler[Ctx]
+	all     []*pooledCompiler[Ctx]
+}
+
+type pooledCompiler[Ctx any] struct {
+	inner *simpleCompiler[Ctx]
+	// stale is set if the Walker is purged while inner is in use, so it can be purged once it's released.
+	stale bool
+	// stats is a copy of the stats of inner, taken when it was last released.
+	stats CacheStats
+}
+
+func newProfiledCompiler[Ctx any](register *Register[Ctx], cfg *walkerConfig) *profiledCompiler[Ctx] {
+	return &profiledCompiler[Ctx]{
+		newInner: func() *simpleCompiler[Ctx] {
+			return newSimpleCompiler(register, cfg)
+		},
+	}
+}
+
+func (c *profiledCompiler[Ctx]) getFn(t g_reflect.Type) (*walkFn[Ctx], error) {
+	e, ok := c.typeFns.Load(t)
+	if ok {
+		return &e.fn, nil
+	}
+	if t == nil {
+		panic("cannot compile function for nil type")
+	}
+
+	// Compile the function up front, so errors are returned here rather than when walking.
+	pc := c.acquire()
+	fn, err := pc.inner.getFn(t)
+	c.release(pc)
+	if err != nil {
+		return nil, err
+	}
+	compiled := entryOf(fn)
+	e = &typeEntry[Ctx]{registered: compiled.registered, t: t, meta: compiled.meta}
+	e.fn = func(ctx Ctx, arg arg) error {
+		pc := c.acquire()
+		defer c.release(pc)
+		fn, err := pc.inner.getFn(t)
+		if err != nil {
+			return err
 		}
+		return (*fn)(ctx, arg)
+	}
+	e, _ = c.typeFns.LoadOrStore(t, e)
+	return &e.fn, nil
+}
+
+func (c *profiledCompiler[Ctx]) acquire() *pooledCompiler[Ctx] {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if n := len(c.idle); n > 0 {
+		pc := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		return pc
+	}
+	pc := &pooledCompiler[Ctx]{inner: c.newInner()}
+	c.all = append(c.all, pc)
+	return pc
+}
+
+func (c *profiledCompiler[Ctx]) release(pc *pooledCompiler[Ctx]) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if pc.stale {
+		pc.inner.purge()
+		pc.stale = false
+	}
+	pc.stats = pc.inner.stats()
+	c.idle = append(c.idle, pc)
+}
+
+func (c *profiledCompiler[Ctx]) purge() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, pc := range c.all {
+		pc.stale = true
+	}
+	for _, pc := range c.idle {
+		pc.inner.purge()
+		pc.stale = false
+		pc.stats = pc.inner.stats()
+	}
+}
+
+func (c *profiledCompiler[Ctx]) stats() CacheStats {
+	c.m.Lock()
+	defer c.m.Unlock()
+	var stats CacheStats
+	for _, pc := range c.all {
+		stats.Entries += pc.stats.Entries
+		stats.Hits += pc.stats.Hits
+		stats.Misses += pc.stats.Misses
+		stats.Evictions += pc.stats.Evictions
+		stats.CompileTime += pc.stats.CompileTime
 	}
-	return fn, err
+	return stats
 }
@@ -0,0 +1,212 @@
+package type_walk
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	g_reflect "github.com/goccy/go-reflect"
+)
+
+// Source identifies which registration produced the function used to walk a type.
+type Source int
+
+const (
+	// SourceTypeFn means the function was registered for the type with RegisterTypeFn.
+	SourceTypeFn Source = iota
+	// SourceCompileFn means the function was compiled by the compile function registered for the type's kind.
+	SourceCompileFn
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceTypeFn:
+		return "type fn"
+	case SourceCompileFn:
+		return "compile fn"
+	default:
+		return fmt.Sprintf("Source(%d)", int(s))
+	}
+}
+
+// Conversion describes how a map key or value of interface type is converted to its static type before walking.
+type Conversion int
+
+const (
+	// ConversionNone means the key or value is not an interface, so no conversion is needed.
+	ConversionNone Conversion = iota
+	// ConversionAny means the interface type is any, which needs no conversion.
+	ConversionAny
+	// ConversionTypeFn means the conversion was created by RegisterTypeFn for the interface type.
+	ConversionTypeFn
+	// ConversionMissing means no conversion is available, so the key or value is walked as an any. Calling Get on an
+	// Arg for it still works, but it is never settable.
+	ConversionMissing
+)
+
+func (c Conversion) String() string {
+	switch c {
+	case ConversionNone:
+		return "none"
+	case ConversionAny:
+		return "any"
+	case ConversionTypeFn:
+		return "type fn"
+	case ConversionMissing:
+		return "missing"
+	default:
+		return fmt.Sprintf("Conversion(%d)", int(c))
+	}
+}
+
+// Explanation describes the function a Walker uses to walk a type, and the functions it uses to walk the values
+// reachable from it.
+type Explanation struct {
+	// Type is the type being explained.
+	Type reflect.Type
+	// Source is the registration that produced the function for Type.
+	Source Source
+	// Repeated is true if Type already appears as an ancestor in the tree. Its children are not repeated.
+	Repeated bool
+
+	// Elem explains the elements of arrays and slices, the target of pointers, and the values of maps.
+	Elem *Explanation
+	// ElemSize is the size of each element of arrays and slices.
+	ElemSize uintptr
+	// Len is the length of arrays.
+	Len int
+
+	// Key explains the keys of maps.
+	Key *Explanation
+	// KeyConversion and ElemConversion describe how the keys and values of maps are converted before walking.
+	KeyConversion  Conversion
+	ElemConversion Conversion
+
+	// Fields explains the fields of structs registered by the CompileStructFn, in the order they were registered.
+	Fields []ExplainedField
+}
+
+// ExplainedField describes a field registered by a CompileStructFn.
+type ExplainedField struct {
+	// Index is the index of the field, as passed to RegisterFieldByIndex.
+	Index []int
+	// Offsets are the offsets followed to reach the field. The first is relative to the start of the struct, and each
+	// subsequent offset is relative to the target of an embedded pointer.
+	Offsets []uintptr
+	*Explanation
+}
+
+// Explain describes how the Walker walks values of type t, compiling functions for any types that have not yet been
+// compiled.
+//
+// Types that are only known at walk time, such as the dynamic types stored in interfaces, are not explained.
+func (w *Walker[Ctx]) Explain(t reflect.Type) (*Explanation, error) {
+	fn, err := w.getFn(g_reflect.ToType(t))
+	if err != nil {
+		return nil, err
+	}
+	return explain(t, fn, map[*walkFn[Ctx]]bool{}), nil
+}
+
+func explain[Ctx any](t reflect.Type, fn *walkFn[Ctx], ancestors map[*walkFn[Ctx]]bool) *Explanation {
+	e := entryOf(fn)
+	x := &Explanation{
+		Type:   t,
+		Source: SourceCompileFn,
+	}
+	if e.registered {
+		x.Source = SourceTypeFn
+		return x
+	}
+	if ancestors[fn] {
+		x.Repeated = true
+		return x
+	}
+	ancestors[fn] = true
+	defer delete(ancestors, fn)
+
+	switch meta := e.meta.(type) {
+	case *arrayMetadata[Ctx]:
+		x.Elem = explain(t.Elem(), meta.elemFn, ancestors)
+		x.ElemSize = meta.elemSize
+		x.Len = meta.length
+	case *sliceMetadata[Ctx]:
+		x.Elem = explain(t.Elem(), meta.elemFn, ancestors)
+		x.ElemSize = meta.elemSize
+	case *ptrMetadata[Ctx]:
+		x.Elem = explain(t.Elem(), meta.elemFn, ancestors)
+	case *mapMetadata[Ctx]:
+		x.Key = explain(t.Key(), meta.keyFn, ancestors)
+		x.Elem = explain(t.Elem(), meta.valFn, ancestors)
+		x.KeyConversion = explainConversion(t.Key(), meta.keyConvFn)
+		x.ElemConversion = explainConversion(t.Elem(), meta.valConvFn)
+	case *structMetadata[Ctx]:
+		x.Fields = make([]ExplainedField, len(meta.fieldInfo))
+		for i := range meta.fieldInfo {
+			f := &meta.fieldInfo[i]
+			x.Fields[i] = ExplainedField{
+				Index:       f.index,
+				Offsets:     f.offsets,
+				Explanation: explain(g_reflect.ToReflectType(f.typ), f.fn, ancestors),
+			}
+		}
+	}
+	return x
+}
+
+func explainConversion(t reflect.Type, fn ifaceConvertFn) Conversion {
+	switch {
+	case t.Kind() != reflect.Interface:
+		return ConversionNone
+	case t == reflect.TypeOf((*any)(nil)).Elem():
+		return ConversionAny
+	case fn != nil:
+		return ConversionTypeFn
+	default:
+		return ConversionMissing
+	}
+}
+
+// String renders the Explanation as an indented tree, one type per line.
+func (x *Explanation) String() string {
+	var sb strings.Builder
+	x.render(&sb, "", 0)
+	return sb.String()
+}
+
+func (x *Explanation) render(sb *strings.Builder, label string, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(label)
+	fmt.Fprintf(sb, "%v: %v", x.Type, x.Source)
+	if x.Source == SourceCompileFn {
+		fmt.Fprintf(sb, " (%v)", x.Type.Kind())
+	}
+	if x.Repeated {
+		sb.WriteString(" (repeated)")
+	}
+	switch x.Type.Kind() {
+	case reflect.Array:
+		if x.Elem != nil {
+			fmt.Fprintf(sb, " len=%d elemSize=%d", x.Len, x.ElemSize)
+		}
+	case reflect.Slice:
+		if x.Elem != nil {
+			fmt.Fprintf(sb, " elemSize=%d", x.ElemSize)
+		}
+	case reflect.Interface:
+		if x.Source == SourceCompileFn {
+			sb.WriteString(" dispatches on dynamic type")
+		}
+	}
+	sb.WriteByte('\n')
+
+	if x.Key != nil {
+		x.Key.render(sb, fmt.Sprintf("key (conversion=%v) ", x.KeyConversion), depth+1)
+		x.Elem.render(sb, fmt.Sprintf("value (conversion=%v) ", x.ElemConversion), depth+1)
+	} else if x.Elem != nil {
+		x.Elem.render(sb, "elem ", depth+1)
+	}
+	for i, f := range x.Fields {
+		f.render(sb, fmt.Sprintf("field %d index=%v offsets=%v ", i, f.Index, f.Offsets), depth+1)
+	}
+}
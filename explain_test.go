@@ -0,0 +1,119 @@
+package type_walk_test
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tw "github.com/zolstein/type-walk"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	type Node struct {
+		Val  int
+		Next *Node
+	}
+	type Inner struct {
+		X string
+	}
+	type S struct {
+		A int
+		*Inner
+		L []Node
+		M map[fmt.Stringer]any
+		R [2]int8
+	}
+
+	register := newCacheTestRegister()
+	tw.RegisterTypeFn(register, func(ctx *strings.Builder, s tw.Arg[fmt.Stringer]) error { return nil })
+	tw.RegisterCompileStringFn(register, func(typ reflect.Type) tw.WalkFn[*strings.Builder, string] {
+		return func(ctx *strings.Builder, s tw.String) error { return nil }
+	})
+	tw.RegisterCompileInt8Fn(register, func(typ reflect.Type) tw.WalkFn[*strings.Builder, int8] {
+		return func(ctx *strings.Builder, s tw.Int8) error { return nil }
+	})
+	tw.RegisterCompileSliceFn(register, func(typ reflect.Type) tw.WalkSliceFn[*strings.Builder] {
+		return func(ctx *strings.Builder, s tw.Slice[*strings.Builder]) error { return nil }
+	})
+	tw.RegisterCompileArrayFn(register, func(typ reflect.Type) tw.WalkArrayFn[*strings.Builder] {
+		return func(ctx *strings.Builder, s tw.Array[*strings.Builder]) error { return nil }
+	})
+	tw.RegisterCompileMapFn(register, func(typ reflect.Type) tw.WalkMapFn[*strings.Builder] {
+		return func(ctx *strings.Builder, s tw.Map[*strings.Builder]) error { return nil }
+	})
+	tw.RegisterCompileInterfaceFn(register, func(typ reflect.Type) tw.WalkInterfaceFn[*strings.Builder] {
+		return func(ctx *strings.Builder, s tw.Interface[*strings.Builder]) error { return nil }
+	})
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfw tw.StructFieldRegister) tw.WalkStructFn[*strings.Builder] {
+		for _, f := range reflect.VisibleFields(typ) {
+			if !f.Anonymous {
+				sfw.RegisterFieldByIndex(f.Index)
+			}
+		}
+		return func(ctx *strings.Builder, s tw.Struct[*strings.Builder]) error { return nil }
+	})
+
+	// Offsets and sizes depend on the platform's word size.
+	st, nodeType := reflect.TypeOf(S{}), reflect.TypeOf(Node{})
+	innerOffset, nextOffset := st.Field(1).Offset, nodeType.Field(1).Offset
+
+	for _, opts := range [][]tw.WalkerOpt{{}, {tw.WithThreadSafe}} {
+		walker := tw.NewWalker(register, opts...)
+		x, err := walker.Explain(st)
+		require.NoError(t, err)
+
+		assert.Equal(t, tw.SourceCompileFn, x.Source)
+		require.Len(t, x.Fields, 5)
+
+		a := x.Fields[0]
+		assert.Equal(t, []int{0}, a.Index)
+		assert.Equal(t, []uintptr{0}, a.Offsets)
+		assert.Equal(t, tw.SourceTypeFn, a.Source)
+
+		inner := x.Fields[1]
+		assert.Equal(t, []int{1, 0}, inner.Index)
+		assert.Equal(t, []uintptr{innerOffset, 0}, inner.Offsets)
+		assert.Equal(t, reflect.TypeOf(""), inner.Type)
+
+		l := x.Fields[2]
+		assert.Equal(t, nodeType.Size(), l.ElemSize)
+		next := l.Elem.Fields[1]
+		assert.Equal(t, reflect.TypeOf(&Node{}), next.Type)
+		assert.True(t, next.Elem.Repeated)
+		assert.Nil(t, next.Elem.Fields)
+
+		m := x.Fields[3]
+		assert.Equal(t, tw.SourceTypeFn, m.Key.Source)
+		assert.Equal(t, tw.ConversionTypeFn, m.KeyConversion)
+		assert.Equal(t, tw.SourceCompileFn, m.Elem.Source)
+		assert.Equal(t, tw.ConversionAny, m.ElemConversion)
+
+		r := x.Fields[4]
+		assert.Equal(t, 2, r.Len)
+		assert.Equal(t, uintptr(1), r.ElemSize)
+
+		assert.Equal(t, fmt.Sprintf(`type_walk_test.S: compile fn (struct)
+  field 0 index=[0] offsets=[0] int: type fn
+  field 1 index=[1 0] offsets=[%d 0] string: compile fn (string)
+  field 2 index=[2] offsets=[%d] []type_walk_test.Node: compile fn (slice) elemSize=%d
+    elem type_walk_test.Node: compile fn (struct)
+      field 0 index=[0] offsets=[0] int: type fn
+      field 1 index=[1] offsets=[%d] *type_walk_test.Node: compile fn (ptr)
+        elem type_walk_test.Node: compile fn (struct) (repeated)
+  field 3 index=[3] offsets=[%d] map[fmt.Stringer]interface {}: compile fn (map)
+    key (conversion=type fn) fmt.Stringer: type fn
+    value (conversion=any) interface {}: compile fn (interface) dispatches on dynamic type
+  field 4 index=[4] offsets=[%d] [2]int8: compile fn (array) len=2 elemSize=%d
+    elem int8: compile fn (int8)
+`, innerOffset, st.Field(2).Offset, nodeType.Size(), nextOffset, st.Field(3).Offset, st.Field(4).Offset,
+			st.Field(4).Type.Elem().Size()), x.String())
+	}
+}
+
+func TestExplainError(t *testing.T) {
+	walker := tw.NewWalker(newCacheTestRegister())
+	_, err := walker.Explain(reflect.TypeOf(struct{ C chan int }{}))
+	require.Error(t, err)
+}
@@ -0,0 +1,166 @@
+// Package walkutil holds helpers shared by the packages built on type-walk Walkers.
+package walkutil
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+)
+
+// StartDetectingCyclesAfter is the nesting depth of pointers, maps and slices after which Cycles starts checking for
+// cycles, which would otherwise recurse forever. Checking is expensive, and deep nesting is rare.
+const StartDetectingCyclesAfter = 1000
+
+// Key identifies a pointer, map or slice, or a pair of them in a lock-step walk, by what it points to and its type.
+// Len distinguishes slices of different lengths sharing a backing array. Fields which don't apply are left zero.
+type Key struct {
+	P, Q unsafe.Pointer
+	Len  int
+	Type reflect.Type
+}
+
+// PointerOf returns the pointer held by v, which must be a pointer or map.
+func PointerOf(v any) unsafe.Pointer {
+	return reflect.ValueOf(v).UnsafePointer()
+}
+
+// Set is a set of Keys. The zero value is an empty set, which allocates when the first Key is added.
+type Set struct {
+	keys map[Key]struct{}
+}
+
+// Add adds key to the set, and reports whether it was added, rather than already being in the set.
+func (s *Set) Add(key Key) bool {
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	if s.keys == nil {
+		s.keys = map[Key]struct{}{}
+	}
+	s.keys[key] = struct{}{}
+	return true
+}
+
+// Remove removes key from the set.
+func (s *Set) Remove(key Key) {
+	delete(s.keys, key)
+}
+
+// Clear removes all Keys from the set, keeping its memory to reuse.
+func (s *Set) Clear() {
+	clear(s.keys)
+}
+
+// Cycles tracks the pointers, maps and slices on the path to the value being walked, once the path is longer than
+// StartDetectingCyclesAfter, so a walk can stop when it reaches one of them again. The zero value is ready to use.
+type Cycles struct {
+	depth uint
+	path  Set
+}
+
+// Enter records that the value identified by key is being walked, and returns the Key to pass to Leave once it has
+// been. It returns false, without recording anything, if the value is already being walked further up the path. key is
+// only called when checking for cycles.
+func (c *Cycles) Enter(key func() Key) (Key, bool) {
+	c.depth++
+	if c.depth <= StartDetectingCyclesAfter {
+		return Key{}, true
+	}
+	k := key()
+	if !c.path.Add(k) {
+		c.depth--
+		return Key{}, false
+	}
+	return k, true
+}
+
+// Leave records that the value identified by key, returned by Enter, has been walked.
+func (c *Cycles) Leave(key Key) {
+	if c.depth > StartDetectingCyclesAfter {
+		c.path.Remove(key)
+	}
+	c.depth--
+}
+
+// Reset empties the path, for a new walk.
+func (c *Cycles) Reset() {
+	c.depth = 0
+	c.path.Clear()
+}
+
+// Pool is a pool of *T values to reuse between walks, like sync.Pool. Get returns new(T) if the pool is empty.
+type Pool[T any] struct {
+	pool sync.Pool
+}
+
+// Get returns a value from the pool, or new(T) if it's empty.
+func (p *Pool[T]) Get() *T {
+	if v, ok := p.pool.Get().(*T); ok {
+		return v
+	}
+	return new(T)
+}
+
+// Put adds v to the pool. v must have been reset for reuse.
+func (p *Pool[T]) Put(v *T) {
+	p.pool.Put(v)
+}
+
+// Registered returns a function which reports whether a function is registered in r for a type with RegisterTypeFn.
+func Registered[Ctx any](r *tw.Register[Ctx]) func(reflect.Type) bool {
+	return func(t reflect.Type) bool {
+		h, ok := r.Lookup(t)
+		return ok && h.Source == tw.SourceTypeFn
+	}
+}
+
+// Unsupported returns a channel or function type within t, or nil if there is none. Walkers can't compile functions
+// for channels or functions, or types containing them, so they must be handled with reflect instead. Struct fields and
+// interfaces aren't searched, since Walkers compile them without compiling what they contain, and neither are types
+// for which registered, if it's not nil, reports true.
+func Unsupported(t reflect.Type, registered func(reflect.Type) bool) reflect.Type {
+	return unsupported(t, registered, map[reflect.Type]bool{})
+}
+
+func unsupported(t reflect.Type, registered func(reflect.Type) bool, seen map[reflect.Type]bool) reflect.Type {
+	if seen[t] || registered != nil && registered(t) {
+		return nil
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func:
+		return t
+	case reflect.Array, reflect.Slice, reflect.Pointer:
+		return unsupported(t.Elem(), registered, seen)
+	case reflect.Map:
+		if u := unsupported(t.Key(), registered, seen); u != nil {
+			return u
+		}
+		return unsupported(t.Elem(), registered, seen)
+	}
+	return nil
+}
+
+// UnsupportedCache caches whether types are unsupported, for types which are checked for every value walked, like the
+// concrete types of interfaces. It is safe for concurrent use.
+type UnsupportedCache struct {
+	registered func(reflect.Type) bool
+	types      sync.Map
+}
+
+// NewUnsupportedCache returns an UnsupportedCache which passes registered to Unsupported.
+func NewUnsupportedCache(registered func(reflect.Type) bool) *UnsupportedCache {
+	return &UnsupportedCache{registered: registered}
+}
+
+// Unsupported reports whether Unsupported finds a channel or function type within t.
+func (c *UnsupportedCache) Unsupported(t reflect.Type) bool {
+	if u, ok := c.types.Load(t); ok {
+		return u.(bool)
+	}
+	u := Unsupported(t, c.registered) != nil
+	c.types.Store(t, u)
+	return u
+}
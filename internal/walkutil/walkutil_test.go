@@ -0,0 +1,102 @@
+package walkutil_test
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+)
+
+func TestUnsupported(t *testing.T) {
+	type withFunc struct {
+		F func()
+	}
+	type list struct {
+		Next *list
+	}
+	chanType := typeOf[chan int]()
+	funcType := typeOf[func()]()
+
+	assert.Nil(t, walkutil.Unsupported(typeOf[int](), nil))
+	assert.Nil(t, walkutil.Unsupported(typeOf[withFunc](), nil))
+	assert.Nil(t, walkutil.Unsupported(typeOf[[]any](), nil))
+	assert.Nil(t, walkutil.Unsupported(typeOf[*list](), nil))
+	assert.Equal(t, chanType, walkutil.Unsupported(chanType, nil))
+	assert.Equal(t, chanType, walkutil.Unsupported(typeOf[[]*[2]chan int](), nil))
+	assert.Equal(t, funcType, walkutil.Unsupported(typeOf[map[string]func()](), nil))
+
+	r := tw.NewRegister[struct{}]()
+	tw.RegisterTypeFn(r, func(struct{}, tw.Arg[func()]) error { return nil })
+	registered := walkutil.Registered(r)
+	assert.True(t, registered(funcType))
+	assert.False(t, registered(chanType))
+	assert.Nil(t, walkutil.Unsupported(typeOf[map[string]func()](), registered))
+
+	cache := walkutil.NewUnsupportedCache(registered)
+	assert.True(t, cache.Unsupported(typeOf[[]chan int]()))
+	assert.False(t, cache.Unsupported(typeOf[[]func()]()))
+	assert.True(t, cache.Unsupported(typeOf[[]chan int]()))
+}
+
+func TestCycles(t *testing.T) {
+	var c walkutil.Cycles
+	x := 1
+	key := walkutil.Key{P: unsafe.Pointer(&x), Type: typeOf[*int]()}
+	calls := 0
+	keyFn := func() walkutil.Key {
+		calls++
+		return key
+	}
+
+	// The same value is entered repeatedly without being reported until the path is deep enough to check.
+	var keys []walkutil.Key
+	for i := 0; i < walkutil.StartDetectingCyclesAfter; i++ {
+		k, ok := c.Enter(keyFn)
+		assert.True(t, ok)
+		keys = append(keys, k)
+	}
+	assert.Zero(t, calls)
+
+	k, ok := c.Enter(keyFn)
+	assert.True(t, ok)
+	assert.Equal(t, key, k)
+	_, ok = c.Enter(keyFn)
+	assert.False(t, ok)
+	assert.Equal(t, 2, calls)
+
+	// Once the value is left, it can be entered again.
+	c.Leave(k)
+	k, ok = c.Enter(keyFn)
+	assert.True(t, ok)
+	c.Leave(k)
+	for i := len(keys) - 1; i >= 0; i-- {
+		c.Leave(keys[i])
+	}
+
+	c.Reset()
+	for i := 0; i <= walkutil.StartDetectingCyclesAfter; i++ {
+		_, ok := c.Enter(keyFn)
+		assert.True(t, ok)
+	}
+}
+
+func TestSet(t *testing.T) {
+	var s walkutil.Set
+	a := walkutil.Key{Len: 1}
+	b := walkutil.Key{Len: 2}
+	assert.True(t, s.Add(a))
+	assert.False(t, s.Add(a))
+	assert.True(t, s.Add(b))
+	s.Remove(a)
+	assert.True(t, s.Add(a))
+	s.Clear()
+	assert.True(t, s.Add(a))
+	assert.True(t, s.Add(b))
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
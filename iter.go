@@ -0,0 +1,51 @@
+//go:build go1.23
+
+package type_walk
+
+import "iter"
+
+// Fields returns an iterator over the registered fields of the struct, by index in the order they were registered.
+// Fields that are not valid are included.
+func (s Struct[Ctx]) Fields() iter.Seq2[int, StructField[Ctx]] {
+	return func(yield func(int, StructField[Ctx]) bool) {
+		for i := range s.meta.fieldInfo {
+			if !yield(i, s.Field(i)) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the elements of the array, by index.
+func (a Array[Ctx]) All() iter.Seq2[int, ArrayElem[Ctx]] {
+	return func(yield func(int, ArrayElem[Ctx]) bool) {
+		for i := 0; i < a.meta.length; i++ {
+			if !yield(i, a.Elem(i)) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the elements of the slice, by index.
+func (s Slice[Ctx]) All() iter.Seq2[int, SliceElem[Ctx]] {
+	return func(yield func(int, SliceElem[Ctx]) bool) {
+		for i, n := 0, s.Len(); i < n; i++ {
+			if !yield(i, s.Elem(i)) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the entries of the map, in the map's iteration order.
+func (m Map[Ctx]) All() iter.Seq[MapEntry[Ctx]] {
+	return func(yield func(MapEntry[Ctx]) bool) {
+		it := m.Iter()
+		for it.Next() {
+			if !yield(it.Entry()) {
+				return
+			}
+		}
+	}
+}
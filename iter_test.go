@@ -0,0 +1,81 @@
+//go:build go1.23
+
+package type_walk_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tw "github.com/zolstein/type-walk"
+	"reflect"
+	"testing"
+)
+
+func TestIterators(t *testing.T) {
+	type S struct {
+		A []int
+		B [3]int
+		M map[int]int
+	}
+
+	register := tw.NewRegister[*[]int]()
+	tw.RegisterTypeFn(register, func(ctx *[]int, i tw.Int) error {
+		*ctx = append(*ctx, i.Get())
+		return nil
+	})
+	tw.RegisterCompileSliceFn(register, func(typ reflect.Type) tw.WalkSliceFn[*[]int] {
+		return func(ctx *[]int, s tw.Slice[*[]int]) error {
+			for i, e := range s.All() {
+				if i == 2 {
+					break
+				}
+				if err := e.Walk(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+	tw.RegisterCompileArrayFn(register, func(typ reflect.Type) tw.WalkArrayFn[*[]int] {
+		return func(ctx *[]int, a tw.Array[*[]int]) error {
+			for i, e := range a.All() {
+				*ctx = append(*ctx, -i)
+				if err := e.Walk(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+	tw.RegisterCompileMapFn(register, func(typ reflect.Type) tw.WalkMapFn[*[]int] {
+		return func(ctx *[]int, m tw.Map[*[]int]) error {
+			for e := range m.All() {
+				if err := e.Key().Walk(ctx); err != nil {
+					return err
+				}
+				if err := e.Value().Walk(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*[]int] {
+		for i := 0; i < typ.NumField(); i++ {
+			sfr.RegisterField(i)
+		}
+		return func(ctx *[]int, s tw.Struct[*[]int]) error {
+			for i, f := range s.Fields() {
+				*ctx = append(*ctx, 100+i)
+				if err := f.Walk(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+
+	var ctx []int
+	err := tw.NewWalker(register).Walk(&ctx, S{A: []int{1, 2, 3}, B: [3]int{4, 5, 6}, M: map[int]int{7: 8}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{100, 1, 2, 101, 0, 4, -1, 5, -2, 6, 102, 7, 8}, ctx)
+}
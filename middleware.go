@@ -0,0 +1,94 @@
+package type_walk
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	g_reflect "github.com/goccy/go-reflect"
+)
+
+// Visit represents a single value being walked, as seen by Middleware.
+type Visit struct {
+	typ g_reflect.Type
+	arg arg
+}
+
+// Type returns the type of the value.
+func (v Visit) Type() reflect.Type {
+	return g_reflect.ToReflectType(v.typ)
+}
+
+// Interface returns the value as an interface.
+func (v Visit) Interface() any {
+	if v.arg.wrongAny() {
+		return *(*any)(v.arg.p)
+	}
+	ptr := v.arg.p
+	if v.arg.directPtr() {
+		ptr = unsafe.Pointer(&v.arg.p)
+	}
+	return g_reflect.NewAt(v.typ, ptr).Elem().Interface()
+}
+
+// VisitFn walks a single value.
+type VisitFn[Ctx any] func(Ctx, Visit) error
+
+// Middleware wraps the function a Walker uses to walk values of type t. It is called once for each type, when the
+// type's function is compiled, and returns a function that will be called for every value of that type. The returned
+// function should usually call next to continue the walk.
+//
+// The kind of the value can be found with t.Kind(). Middleware also wraps functions registered with RegisterTypeFn.
+type Middleware[Ctx any] func(t reflect.Type, next VisitFn[Ctx]) VisitFn[Ctx]
+
+// WithMiddleware installs mw on a Walker. If several middleware are installed, the first is outermost.
+//
+// Middleware is applied when functions are compiled, so it adds no cost to walkers that do not use it. Ctx must
+// match the context type of the Walker, otherwise NewWalker panics.
+func WithMiddleware[Ctx any](mw Middleware[Ctx]) WalkerOpt {
+	return func(w *walkerConfig) {
+		w.middleware = append(w.middleware, mw)
+	}
+}
+
+func middlewareFor[Ctx any](cfg *walkerConfig) []Middleware[Ctx] {
+	mws := make([]Middleware[Ctx], len(cfg.middleware))
+	for i, mw := range cfg.middleware {
+		if p, ok := mw.(*Profiler); ok {
+			mws[i] = profilerMiddleware[Ctx](p, &profileStack{})
+			continue
+		}
+		typed, ok := mw.(Middleware[Ctx])
+		if !ok {
+			panic(fmt.Sprintf("middleware %T does not match walker context type %v", mw, reflectType[Ctx]()))
+		}
+		mws[i] = typed
+	}
+	return mws
+}
+
+// profiled reports whether a Profiler is installed.
+func (cfg *walkerConfig) profiled() bool {
+	for _, mw := range cfg.middleware {
+		if _, ok := mw.(*Profiler); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func applyMiddleware[Ctx any](mws []Middleware[Ctx], t g_reflect.Type, fn walkFn[Ctx]) walkFn[Ctx] {
+	if len(mws) == 0 {
+		return fn
+	}
+	rt := g_reflect.ToReflectType(t)
+	next := VisitFn[Ctx](func(ctx Ctx, v Visit) error {
+		return fn(ctx, v.arg)
+	})
+	for i := len(mws) - 1; i >= 0; i-- {
+		next = mws[i](rt, next)
+	}
+	return func(ctx Ctx, a arg) error {
+		return next(ctx, Visit{typ: t, arg: a})
+	}
+}
@@ -0,0 +1,62 @@
+package type_walk_test
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tw "github.com/zolstein/type-walk"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	type Node struct {
+		Val  int
+		Next *Node
+	}
+
+	for _, opts := range [][]tw.WalkerOpt{{}, {tw.WithThreadSafe}} {
+		var compiled []string
+		var trace []string
+		counts := map[reflect.Kind]int{}
+
+		tracer := tw.Middleware[*strings.Builder](func(t reflect.Type, next tw.VisitFn[*strings.Builder]) tw.VisitFn[*strings.Builder] {
+			compiled = append(compiled, t.String())
+			return func(ctx *strings.Builder, v tw.Visit) error {
+				trace = append(trace, fmt.Sprintf("%v=%v", v.Type(), v.Interface()))
+				return next(ctx, v)
+			}
+		})
+		counter := tw.Middleware[*strings.Builder](func(t reflect.Type, next tw.VisitFn[*strings.Builder]) tw.VisitFn[*strings.Builder] {
+			return func(ctx *strings.Builder, v tw.Visit) error {
+				// The tracer is outermost, so it has already recorded this visit.
+				counts[t.Kind()]++
+				trace = append(trace, "count")
+				return next(ctx, v)
+			}
+		})
+
+		walker := tw.NewWalker(newCacheTestRegister(), append(opts, tw.WithMiddleware(tracer), tw.WithMiddleware(counter))...)
+		var sb strings.Builder
+		err := walker.Walk(&sb, &Node{Val: 1, Next: &Node{Val: 2}})
+		require.NoError(t, err)
+		assert.Equal(t, `{Val:1,Next:{Val:2,Next:nil}}`, sb.String())
+
+		// int is registered, so it is wrapped when the walker is created.
+		assert.ElementsMatch(t, []string{"int", "*type_walk_test.Node", "type_walk_test.Node"}, compiled)
+		assert.Equal(t, map[reflect.Kind]int{reflect.Ptr: 3, reflect.Struct: 2, reflect.Int: 2}, counts)
+		assert.Len(t, trace, 14)
+		assert.Equal(t, "int=1", trace[4])
+		assert.Equal(t, "count", trace[5])
+	}
+}
+
+func TestMiddlewareWrongContext(t *testing.T) {
+	mw := tw.Middleware[int](func(t reflect.Type, next tw.VisitFn[int]) tw.VisitFn[int] {
+		return next
+	})
+	assert.Panics(t, func() {
+		tw.NewWalker(newCacheTestRegister(), tw.WithMiddleware(mw))
+	})
+}
@@ -0,0 +1,119 @@
+package type_walk
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	g_reflect "github.com/goccy/go-reflect"
+)
+
+// WalkPair walks a and b, which must have the same type, in lock-step. It calls the registered function for each value
+// of a it encounters, as Walk does, and each value is paired with the corresponding value of b. The paired value is
+// available from the Pair method of the Arg, Struct, Array, Slice, Ptr, Map or Interface being walked, and is never
+// settable.
+//
+// A child of a paired value is paired if the corresponding child exists in the paired value: a field reached through
+// embedded pointers that are not nil in both, a slice element within the length of both slices, the target of a
+// pointer that is not nil in both, the value of an interface holding the same concrete type in both, or the key and
+// value of a map entry whose key is in both maps. Otherwise, the child is walked unpaired, as are all of its children.
+// Compile functions are shared with Walk, so a function that handles pairs works both ways.
+func (w *Walker[Ctx]) WalkPair(ctx Ctx, a, b any) error {
+	t, p := g_reflect.TypeAndPtrOf(a)
+	pt, pp := g_reflect.TypeAndPtrOf(b)
+	if t != pt {
+		return fmt.Errorf("cannot walk values of different types %v and %v in lock-step", t, pt)
+	}
+	fn, err := w.getFn(t)
+	if err != nil {
+		return err
+	}
+	return (*fn)(ctx, arg{
+		p:     p,
+		q:     pp,
+		flags: flagIf(isDirectIface(t), flagDirectPtr) | flagPaired,
+	})
+}
+
+// WalkPairUnsafe walks the values of type t that p and q point to in lock-step, like WalkPair, and without boxing them
+// into interfaces, like WalkUnsafe. p and q must point to valid values of type t, which must not be modified
+// concurrently. canAddr applies to the value p points to as it does for WalkUnsafe; the paired values are never
+// settable, so the value q points to is never modified.
+func (w *Walker[Ctx]) WalkPairUnsafe(ctx Ctx, t reflect.Type, p, q unsafe.Pointer, canAddr bool) error {
+	fn, err := w.getFn(g_reflect.ToType(t))
+	if err != nil {
+		return err
+	}
+	return (*fn)(ctx, arg{p: p, q: q, flags: flagIf(canAddr, flagCanAddr) | flagPaired})
+}
+
+// pairArg returns the arg for the value paired with a. It must only be called if a is paired.
+func (a arg) pairArg() arg {
+	return arg{p: a.q, flags: a.flags & (flagDirectPtr | flagWrongAny)}
+}
+
+// pairedWith returns a paired with b, or unpaired if b is not valid.
+func (a arg) pairedWith(b arg) arg {
+	a.q = b.p
+	a.flags = a.flags&^flagPaired | flagIf(b.directPtr() || b.p != nil, flagPaired)
+	return a
+}
+
+// Pair returns the value paired with the arg in a lock-step walk, if it is paired. The paired arg is not settable.
+func (a Arg[T]) Pair() (Arg[T], bool) {
+	if !a.paired() {
+		return Arg[T]{}, false
+	}
+	return Arg[T]{arg: a.pairArg()}, true
+}
+
+// Pair returns the struct paired with this one in a lock-step walk, if it is paired. The paired struct is not
+// settable.
+func (s Struct[Ctx]) Pair() (Struct[Ctx], bool) {
+	if !s.arg.paired() {
+		return Struct[Ctx]{}, false
+	}
+	return Struct[Ctx]{meta: s.meta, arg: s.arg.pairArg()}, true
+}
+
+// Pair returns the array paired with this one in a lock-step walk, if it is paired. The paired array is not settable.
+func (a Array[Ctx]) Pair() (Array[Ctx], bool) {
+	if !a.arg.paired() {
+		return Array[Ctx]{}, false
+	}
+	return Array[Ctx]{meta: a.meta, arg: a.arg.pairArg()}, true
+}
+
+// Pair returns the slice paired with this one in a lock-step walk, if it is paired. The paired slice is not settable.
+func (s Slice[Ctx]) Pair() (Slice[Ctx], bool) {
+	if !s.arg.paired() {
+		return Slice[Ctx]{}, false
+	}
+	return Slice[Ctx]{meta: s.meta, arg: s.arg.pairArg()}, true
+}
+
+// Pair returns the pointer paired with this one in a lock-step walk, if it is paired. The paired pointer is not
+// settable.
+func (p Ptr[Ctx]) Pair() (Ptr[Ctx], bool) {
+	if !p.arg.paired() {
+		return Ptr[Ctx]{}, false
+	}
+	return Ptr[Ctx]{meta: p.meta, arg: p.arg.pairArg()}, true
+}
+
+// Pair returns the map paired with this one in a lock-step walk, if it is paired. The paired map is not settable.
+func (m Map[Ctx]) Pair() (Map[Ctx], bool) {
+	if !m.arg.paired() {
+		return Map[Ctx]{}, false
+	}
+	return Map[Ctx]{meta: m.meta, arg: m.arg.pairArg()}, true
+}
+
+// Pair returns the interface paired with this one in a lock-step walk, if it is paired. The paired interface is not
+// settable.
+func (i Interface[Ctx]) Pair() (Interface[Ctx], bool) {
+	if !i.arg.paired() {
+		return Interface[Ctx]{}, false
+	}
+	return Interface[Ctx]{meta: i.meta, arg: i.arg.pairArg()}, true
+}
@@ -0,0 +1,206 @@
+package type_walk_test
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tw "github.com/zolstein/type-walk"
+)
+
+// newPairWalker returns a Walker that records each int it walks with its pair, or "x/-" if it is unpaired.
+func newPairWalker() *tw.Walker[*[]string] {
+	register := tw.NewRegister[*[]string]()
+	tw.RegisterTypeFn(register, func(ctx *[]string, i tw.Int) error {
+		if pair, ok := i.Pair(); ok {
+			*ctx = append(*ctx, fmt.Sprintf("%d/%d", i.Get(), pair.Get()))
+		} else {
+			*ctx = append(*ctx, fmt.Sprintf("%d/-", i.Get()))
+		}
+		return nil
+	})
+	tw.RegisterTypeFn(register, func(ctx *[]string, s tw.String) error {
+		return nil
+	})
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*[]string] {
+		for i := 0; i < typ.NumField(); i++ {
+			sfr.RegisterField(i)
+		}
+		return func(ctx *[]string, s tw.Struct[*[]string]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompileArrayFn(register, func(typ reflect.Type) tw.WalkArrayFn[*[]string] {
+		return func(ctx *[]string, a tw.Array[*[]string]) error {
+			return a.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompileSliceFn(register, func(typ reflect.Type) tw.WalkSliceFn[*[]string] {
+		return func(ctx *[]string, s tw.Slice[*[]string]) error {
+			if pair, ok := s.Pair(); ok {
+				*ctx = append(*ctx, fmt.Sprintf("len %d/%d", s.Len(), pair.Len()))
+			}
+			return s.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompilePtrFn(register, func(typ reflect.Type) tw.WalkPtrFn[*[]string] {
+		return func(ctx *[]string, p tw.Ptr[*[]string]) error {
+			if pair, ok := p.Pair(); ok && pair.IsNil() {
+				*ctx = append(*ctx, "nil pair")
+			}
+			if p.IsNil() {
+				*ctx = append(*ctx, "nil")
+				return nil
+			}
+			return p.Walk(ctx)
+		}
+	})
+	tw.RegisterCompileMapFn(register, func(typ reflect.Type) tw.WalkMapFn[*[]string] {
+		return func(ctx *[]string, m tw.Map[*[]string]) error {
+			var entries []string
+			iter := m.Iter()
+			for iter.Next() {
+				var values []string
+				entry := iter.Entry()
+				if err := entry.Key().Walk(&values); err != nil {
+					return err
+				}
+				if err := entry.Value().Walk(&values); err != nil {
+					return err
+				}
+				entries = append(entries, fmt.Sprintf("%v %v", entry.HasPair(), values))
+			}
+			sort.Strings(entries)
+			*ctx = append(*ctx, entries...)
+			return nil
+		}
+	})
+	tw.RegisterCompileInterfaceFn(register, func(typ reflect.Type) tw.WalkInterfaceFn[*[]string] {
+		return func(ctx *[]string, i tw.Interface[*[]string]) error {
+			if i.IsNil() {
+				return nil
+			}
+			return i.Walk(ctx)
+		}
+	})
+	return tw.NewWalker(register)
+}
+
+func TestWalkPair(t *testing.T) {
+	type Inner struct {
+		A int
+	}
+	type S struct {
+		I   int
+		P   *Inner
+		L   []int
+		R   [2]int
+		M   map[string]int
+		Any any
+		*Inner
+	}
+	walker := newPairWalker()
+
+	tests := []struct {
+		name     string
+		a, b     any
+		expected []string
+	}{
+		{"scalar", 1, 2, []string{"1/2"}},
+		{
+			"struct",
+			S{
+				I:     1,
+				P:     &Inner{2},
+				L:     []int{3, 4, 5},
+				R:     [2]int{6, 7},
+				M:     map[string]int{"a": 8, "b": 9},
+				Any:   10,
+				Inner: &Inner{11},
+			},
+			S{
+				I:     -1,
+				P:     &Inner{-2},
+				L:     []int{-3, -4},
+				R:     [2]int{-6, -7},
+				M:     map[string]int{"a": -8, "c": -9},
+				Any:   -10,
+				Inner: &Inner{-11},
+			},
+			[]string{
+				"1/-1", "2/-2", "len 3/2", "3/-3", "4/-4", "5/-", "6/-6", "7/-7", "false [9/-]", "true [8/-8]",
+				"10/-10", "11/-11",
+			},
+		},
+		{
+			"unpairedChildren",
+			S{P: &Inner{1}, Any: 2, Inner: &Inner{3}},
+			S{Any: "x"},
+			[]string{"0/0", "nil pair", "1/-", "len 0/0", "0/0", "0/0", "2/-", "nil pair", "3/-"},
+		},
+		{"nilLeft", S{}, S{P: &Inner{1}, Inner: &Inner{2}}, []string{"0/0", "nil", "len 0/0", "0/0", "0/0", "nil"}},
+		{"ptr", &Inner{1}, &Inner{2}, []string{"1/2"}},
+		{"iface", []any{1, 2, nil}, []any{3, "x"}, []string{"len 3/2", "1/3", "2/-"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var actual []string
+			require.NoError(t, walker.WalkPair(&actual, test.a, test.b))
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+
+	t.Run("differentTypes", func(t *testing.T) {
+		var actual []string
+		assert.Error(t, walker.WalkPair(&actual, 1, "x"))
+		assert.Empty(t, actual)
+	})
+
+	t.Run("unpairedWalk", func(t *testing.T) {
+		var actual []string
+		require.NoError(t, walker.Walk(&actual, S{I: 1, L: []int{2}}))
+		assert.Equal(t, []string{"1/-", "nil", "2/-", "0/-", "0/-", "nil"}, actual)
+	})
+
+	t.Run("unsafe", func(t *testing.T) {
+		var actual []string
+		a, b := []int{1, 2}, []int{3}
+		require.NoError(t, walker.WalkPairUnsafe(&actual, reflect.TypeOf(a), unsafe.Pointer(&a), unsafe.Pointer(&b), true))
+		assert.Equal(t, []string{"len 2/1", "1/3", "2/-"}, actual)
+	})
+
+	t.Run("pairNotSettable", func(t *testing.T) {
+		register := tw.NewRegister[struct{}]()
+		tw.RegisterTypeFn(register, func(ctx struct{}, i tw.Int) error {
+			pair, ok := i.Pair()
+			require.True(t, ok)
+			assert.True(t, i.CanSet())
+			assert.False(t, pair.CanSet())
+			i.Set(pair.Get())
+			return nil
+		})
+		tw.RegisterCompileArrayFn(register, func(typ reflect.Type) tw.WalkArrayFn[struct{}] {
+			return func(ctx struct{}, a tw.Array[struct{}]) error {
+				return a.WalkAll(ctx)
+			}
+		})
+		tw.RegisterCompilePtrFn(register, func(typ reflect.Type) tw.WalkPtrFn[struct{}] {
+			return func(ctx struct{}, p tw.Ptr[struct{}]) error {
+				return p.Walk(ctx)
+			}
+		})
+		a, b := 1, 2
+		walker := tw.NewWalker(register)
+		require.NoError(t, walker.WalkPair(struct{}{}, &a, &b))
+		assert.Equal(t, 2, a)
+
+		x, y := [2]int{1, 2}, [2]int{3, 4}
+		require.NoError(t, walker.WalkPairUnsafe(struct{}{}, reflect.TypeOf(x), unsafe.Pointer(&x), unsafe.Pointer(&y), true))
+		assert.Equal(t, [2]int{3, 4}, x)
+		assert.Equal(t, [2]int{3, 4}, y)
+	})
+}
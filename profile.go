@@ -0,0 +1,342 @@
+package type_walk
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zolstein/sync-map"
+)
+
+// Profiler records how much time Walkers spend walking values of each type.
+//
+// A Profiler is installed on a Walker with WithProfiler. One Profiler can be shared by several Walkers, and aggregates
+// their results. It keeps no reference to the Walkers, so they can be garbage collected while it's in use.
+type Profiler struct {
+	mu    sync.Mutex
+	start time.Time
+	// types holds the statistics for each type, which Report returns.
+	types sync_map.Map[reflect.Type, *profileStats]
+	// root is the root of the tree of nested types, which WriteProfile writes.
+	root atomic.Pointer[profileNode]
+}
+
+// NewProfiler creates a new Profiler.
+func NewProfiler() *Profiler {
+	p := &Profiler{start: time.Now()}
+	p.root.Store(&profileNode{})
+	return p
+}
+
+// WithProfiler installs p on a Walker, as middleware that records every value walked. Like other middleware, it is
+// nested in the order the options are given, so if it is given first, its times include the time spent in any other
+// middleware.
+//
+// The Profiler records how the values of each walk are nested, so a Walker created with WithThreadSafe compiles its
+// functions separately for each walk that runs at the same time as others.
+func WithProfiler(p *Profiler) WalkerOpt {
+	return func(w *walkerConfig) {
+		w.middleware = append(w.middleware, p)
+	}
+}
+
+// maxProfileDepth bounds the depth of the tree of nested types, so recursive values don't make it grow without bound.
+// Values nested more deeply are recorded as if they were nested directly within the value at that depth.
+const maxProfileDepth = 64
+
+type profileStats struct {
+	visits   atomic.Uint64
+	errors   atomic.Uint64
+	cumTime  atomic.Int64
+	selfTime atomic.Int64
+}
+
+func (s *profileStats) reset() {
+	s.visits.Store(0)
+	s.errors.Store(0)
+	s.cumTime.Store(0)
+	s.selfTime.Store(0)
+}
+
+// profileNode records the values of one type walked within a particular nesting of other types. Nodes are shared by
+// every walk, so cumTime isn't used, since it can be computed from the tree.
+type profileNode struct {
+	typ      reflect.Type
+	children sync_map.Map[reflect.Type, *profileNode]
+	profileStats
+}
+
+func (n *profileNode) child(t reflect.Type) *profileNode {
+	c, ok := n.children.Load(t)
+	if !ok {
+		c, _ = n.children.LoadOrStore(t, &profileNode{typ: t})
+	}
+	return c
+}
+
+// profileStack records the nesting of the values being walked by a single walk.
+type profileStack struct {
+	frames []profileFrame
+	// active counts the frames for each type, so the time spent walking a value nested within another value of the
+	// same type is only added to its cumulative time once.
+	active map[*profileStats]int
+}
+
+type profileFrame struct {
+	node      *profileNode
+	childTime time.Duration
+}
+
+func (p *Profiler) statsFor(t reflect.Type) *profileStats {
+	s, ok := p.types.Load(t)
+	if !ok {
+		s, _ = p.types.LoadOrStore(t, &profileStats{})
+	}
+	return s
+}
+
+// profilerMiddleware records visits to p. Every function compiled with it shares stack, so they must not be called
+// by several goroutines at once.
+func profilerMiddleware[Ctx any](p *Profiler, stack *profileStack) Middleware[Ctx] {
+	return func(t reflect.Type, next VisitFn[Ctx]) VisitFn[Ctx] {
+		stats := p.statsFor(t)
+		return func(ctx Ctx, v Visit) error {
+			return profileVisit(p, stack, stats, t, ctx, v, next)
+		}
+	}
+}
+
+func profileVisit[Ctx any](
+	p *Profiler, stack *profileStack, stats *profileStats, t reflect.Type, ctx Ctx, v Visit, next VisitFn[Ctx],
+) (err error) {
+	parent := p.root.Load()
+	if depth := len(stack.frames); depth > 0 {
+		parent = stack.frames[min(depth, maxProfileDepth)-1].node
+	}
+	node := parent.child(t)
+	stack.frames = append(stack.frames, profileFrame{node: node})
+	if stack.active == nil {
+		stack.active = map[*profileStats]int{}
+	}
+	stack.active[stats]++
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start)
+		top := len(stack.frames) - 1
+		self := max(elapsed-stack.frames[top].childTime, 0)
+		stack.frames = stack.frames[:top]
+		if top > 0 {
+			stack.frames[top-1].childTime += elapsed
+		}
+		stack.active[stats]--
+		if stack.active[stats] == 0 {
+			stats.cumTime.Add(int64(elapsed))
+		}
+		for _, s := range [...]*profileStats{stats, &node.profileStats} {
+			s.visits.Add(1)
+			s.selfTime.Add(int64(self))
+			if err != nil {
+				s.errors.Add(1)
+			}
+		}
+	}()
+	return next(ctx, v)
+}
+
+// TypeProfile reports the time spent walking values of one type.
+type TypeProfile struct {
+	Type reflect.Type
+	// Visits is the number of values of Type walked.
+	Visits uint64
+	// Errors is the number of those walks that returned an error, including errors returned by nested values.
+	Errors uint64
+	// CumTime is the time spent walking values of Type, including nested values. Time spent walking a value nested
+	// within another value of the same type is only counted once.
+	CumTime time.Duration
+	// SelfTime is the time spent walking values of Type, excluding nested values.
+	SelfTime time.Duration
+}
+
+// Report returns the recorded profile of each type, sorted by decreasing cumulative time.
+func (p *Profiler) Report() []TypeProfile {
+	var report []TypeProfile
+	p.types.Range(func(t reflect.Type, s *profileStats) bool {
+		if visits := s.visits.Load(); visits > 0 {
+			report = append(report, TypeProfile{
+				Type:     t,
+				Visits:   visits,
+				Errors:   s.errors.Load(),
+				CumTime:  time.Duration(s.cumTime.Load()),
+				SelfTime: time.Duration(s.selfTime.Load()),
+			})
+		}
+		return true
+	})
+	slices.SortFunc(report, func(a, b TypeProfile) int {
+		if a.CumTime != b.CumTime {
+			return cmp.Compare(b.CumTime, a.CumTime)
+		}
+		return cmp.Compare(b.SelfTime, a.SelfTime)
+	})
+	return report
+}
+
+// WriteReport writes the result of Report to w as a table.
+func (p *Profiler) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "cum\tself\tvisits\terrors\t type\t")
+	for _, tp := range p.Report() {
+		_, _ = fmt.Fprintf(tw, "%v\t%v\t%d\t%d\t %v\t\n", tp.CumTime, tp.SelfTime, tp.Visits, tp.Errors, tp.Type)
+	}
+	return tw.Flush()
+}
+
+// Reset discards everything recorded so far. Values being walked while Reset is called may be only partly discarded.
+func (p *Profiler) Reset() {
+	p.types.Range(func(_ reflect.Type, s *profileStats) bool {
+		s.reset()
+		return true
+	})
+	p.root.Store(&profileNode{})
+	p.mu.Lock()
+	p.start = time.Now()
+	p.mu.Unlock()
+}
+
+// WriteProfile writes the recorded profile to w in the gzipped protobuf format read by pprof, e.g. with
+// `go tool pprof -http=: profile.pb.gz`. Types appear as functions, and values nested within other values appear as
+// calls, so the time spent walking each type can be explored with the usual pprof views.
+//
+// The profile has three sample types - visits, errors and time - with time as the default. Values nested more than 64
+// deep appear as if they were nested directly within the value 64 deep.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	b := &profileBuilder{
+		strings:   map[string]int64{"": 0},
+		locations: map[reflect.Type]uint64{},
+	}
+	b.stringTable = []string{""}
+
+	var profile protoBuffer
+	for _, st := range [][2]string{{"visits", "count"}, {"errors", "count"}, {"time", "nanoseconds"}} {
+		var vt protoBuffer
+		vt.int64Field(1, b.str(st[0]))
+		vt.int64Field(2, b.str(st[1]))
+		profile.bytesField(1, vt)
+	}
+
+	var stack []uint64
+	var visit func(n *profileNode)
+	visit = func(n *profileNode) {
+		stack = append(stack, b.location(n.typ))
+		var sample protoBuffer
+		// Locations are ordered from the leaf to the root.
+		var ids protoBuffer
+		for i := len(stack) - 1; i >= 0; i-- {
+			ids.varint(stack[i])
+		}
+		sample.bytesField(1, ids)
+		var values protoBuffer
+		values.varint(n.visits.Load())
+		values.varint(n.errors.Load())
+		values.varint(uint64(n.selfTime.Load()))
+		sample.bytesField(2, values)
+		profile.bytesField(2, sample)
+		n.children.Range(func(_ reflect.Type, c *profileNode) bool {
+			visit(c)
+			return true
+		})
+		stack = stack[:len(stack)-1]
+	}
+	p.root.Load().children.Range(func(_ reflect.Type, c *profileNode) bool {
+		visit(c)
+		return true
+	})
+
+	for t, id := range b.locations {
+		var line protoBuffer
+		line.varintField(1, id)
+		var loc protoBuffer
+		loc.varintField(1, id)
+		loc.bytesField(4, line)
+		profile.bytesField(4, loc)
+
+		var fn protoBuffer
+		fn.varintField(1, id)
+		fn.int64Field(2, b.str(t.String()))
+		fn.int64Field(3, b.str(t.String()))
+		profile.bytesField(5, fn)
+	}
+
+	p.mu.Lock()
+	start := p.start
+	p.mu.Unlock()
+	defaultSampleType := b.str("time")
+	// The string table must be written last, since writing the other fields adds to it.
+	for _, s := range b.stringTable {
+		profile.bytesField(6, protoBuffer(s))
+	}
+	profile.int64Field(9, start.UnixNano())
+	profile.int64Field(10, int64(time.Since(start)))
+	profile.int64Field(14, defaultSampleType)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(profile); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+type profileBuilder struct {
+	strings     map[string]int64
+	stringTable []string
+	locations   map[reflect.Type]uint64
+}
+
+func (b *profileBuilder) str(s string) int64 {
+	idx, ok := b.strings[s]
+	if !ok {
+		idx = int64(len(b.stringTable))
+		b.strings[s] = idx
+		b.stringTable = append(b.stringTable, s)
+	}
+	return idx
+}
+
+func (b *profileBuilder) location(t reflect.Type) uint64 {
+	id, ok := b.locations[t]
+	if !ok {
+		id = uint64(len(b.locations) + 1)
+		b.locations[t] = id
+	}
+	return id
+}
+
+// protoBuffer encodes the small subset of protobuf used by the pprof format.
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(x uint64) {
+	*b = binary.AppendUvarint(*b, x)
+}
+
+func (b *protoBuffer) varintField(field int, x uint64) {
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64Field(field int, x int64) {
+	b.varintField(field, uint64(x))
+}
+
+func (b *protoBuffer) bytesField(field int, data protoBuffer) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
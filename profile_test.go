@@ -0,0 +1,155 @@
+package type_walk_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tw "github.com/zolstein/type-walk"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProfiler(t *testing.T) {
+	type Node struct {
+		Val  int
+		Next *Node
+	}
+
+	profiler := tw.NewProfiler()
+	register := newCacheTestRegister()
+	errNegative := errors.New("negative")
+	tw.RegisterTypeFn(register, func(ctx *strings.Builder, i tw.Int) error {
+		if i.Get() < 0 {
+			return errNegative
+		}
+		return nil
+	})
+
+	// Two walkers share the profiler.
+	for i := 0; i < 2; i++ {
+		walker := tw.NewWalker(register, tw.WithProfiler(profiler))
+		require.NoError(t, walker.Walk(&strings.Builder{}, Node{Val: 1, Next: &Node{Val: 2, Next: &Node{Val: 3}}}))
+		require.ErrorIs(t, walker.Walk(&strings.Builder{}, Node{Val: 1, Next: &Node{Val: -1}}), errNegative)
+	}
+
+	report := profiler.Report()
+	byType := map[reflect.Type]tw.TypeProfile{}
+	for _, tp := range report {
+		byType[tp.Type] = tp
+	}
+	require.Len(t, byType, 3)
+
+	node := byType[reflect.TypeOf(Node{})]
+	assert.Equal(t, uint64(10), node.Visits)
+	assert.Equal(t, uint64(4), node.Errors)
+	// Node is the outermost type, so its cumulative time covers everything.
+	assert.Equal(t, reflect.TypeOf(Node{}), report[0].Type)
+	assert.GreaterOrEqual(t, node.CumTime, node.SelfTime)
+
+	nodePtr := byType[reflect.TypeOf(&Node{})]
+	assert.Equal(t, uint64(8), nodePtr.Visits)
+	assert.Equal(t, uint64(2), nodePtr.Errors)
+
+	i := byType[reflect.TypeOf(0)]
+	assert.Equal(t, uint64(10), i.Visits)
+	assert.Equal(t, uint64(2), i.Errors)
+	assert.Equal(t, i.CumTime, i.SelfTime)
+
+	var selfTotal int64
+	for _, tp := range report {
+		selfTotal += int64(tp.SelfTime)
+	}
+	assert.Equal(t, int64(node.CumTime), selfTotal)
+
+	var sb strings.Builder
+	require.NoError(t, profiler.WriteReport(&sb))
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[0], "visits")
+	assert.Contains(t, lines[1], "type_walk_test.Node")
+
+	var buf bytes.Buffer
+	require.NoError(t, profiler.WriteProfile(&buf))
+	zr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	raw, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "*type_walk_test.Node")
+	assert.Contains(t, string(raw), "nanoseconds")
+
+	profiler.Reset()
+	assert.Empty(t, profiler.Report())
+}
+
+func TestProfilerThreadSafe(t *testing.T) {
+	type Node struct {
+		Val  int
+		Next *Node
+	}
+
+	profiler := tw.NewProfiler()
+	walker := tw.NewWalker(newCacheTestRegister(), tw.WithThreadSafe, tw.WithProfiler(profiler))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.NoError(t, walker.Walk(&strings.Builder{}, Node{Val: 1, Next: &Node{Val: 2}}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	byType := map[reflect.Type]tw.TypeProfile{}
+	var selfTotal time.Duration
+	for _, tp := range profiler.Report() {
+		byType[tp.Type] = tp
+		selfTotal += tp.SelfTime
+	}
+	node := byType[reflect.TypeOf(Node{})]
+	assert.Equal(t, uint64(1600), node.Visits)
+	assert.Equal(t, uint64(1600), byType[reflect.TypeOf(&Node{})].Visits)
+	assert.Equal(t, uint64(1600), byType[reflect.TypeOf(0)].Visits)
+	// Each walk's values are nested correctly, however the walks are interleaved.
+	assert.Equal(t, node.CumTime, selfTotal)
+
+	walker.Purge()
+	assert.Zero(t, walker.CacheStats().Entries)
+	assert.NoError(t, walker.Walk(&strings.Builder{}, &Node{}))
+}
+
+func TestProfilerRecursion(t *testing.T) {
+	type Node struct {
+		Val  int
+		Next *Node
+	}
+	list := func(n int) *Node {
+		var head *Node
+		for i := 0; i < n; i++ {
+			head = &Node{Val: i, Next: head}
+		}
+		return head
+	}
+
+	profileSize := func(n int) int {
+		profiler := tw.NewProfiler()
+		walker := tw.NewWalker(newCacheTestRegister(), tw.WithProfiler(profiler))
+		require.NoError(t, walker.Walk(&strings.Builder{}, list(n)))
+		var buf bytes.Buffer
+		require.NoError(t, profiler.WriteProfile(&buf))
+		zr, err := gzip.NewReader(&buf)
+		require.NoError(t, err)
+		raw, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		return len(raw)
+	}
+	// Values nested beyond the maximum depth share nodes, so the profile stops growing with the length of the list.
+	assert.InDelta(t, profileSize(100), profileSize(1000), 100)
+}
@@ -1,25 +1,57 @@
 package type_walk
 
 import (
+	"errors"
+	"fmt"
 	g_reflect "github.com/goccy/go-reflect"
+	"maps"
 	"reflect"
+	"runtime"
+	"slices"
+	"strings"
 	"unsafe"
 )
 
 type noCast[T any] [0]T
 
 type arg struct {
-	p       unsafe.Pointer
-	canAddr bool
-	// If directPtr is true, the arg represents a pointer type, and p is the value itself, not a pointer to the value.
-	directPtr bool
-	// If wrongAny is true, the arg represents an interface, and we have a pointer to an `any`, which might not be the
-	// correct interface type.
-	wrongAny bool
+	p unsafe.Pointer
+	// q refers to the paired value in a lock-step walk, in the same way as p, if the arg is paired.
+	q     unsafe.Pointer
+	flags argFlags
+}
+
+// argFlags holds the flags of an arg as the bits of a single byte. Keeping the flags in one field, rather than one
+// field each, keeps arg small enough for the compiler to pass it in registers, which makes a large difference to the
+// cost of walking.
+type argFlags uint8
+
+const (
+	flagCanAddr argFlags = 1 << iota
+	// If flagDirectPtr is set, the arg represents a pointer type, and p is the value itself, not a pointer to the value.
+	flagDirectPtr
+	// If flagWrongAny is set, the arg represents an interface, and we have a pointer to an `any`, which might not be
+	// the correct interface type.
+	flagWrongAny
+	// If flagPaired is set, the arg is being walked in lock-step with another value of the same type, referred to by q.
+	flagPaired
+)
+
+// flagIf returns flag if set is true, and no flags otherwise.
+func flagIf(set bool, flag argFlags) argFlags {
+	if set {
+		return flag
+	}
+	return 0
 }
 
+func (a arg) canAddr() bool   { return a.flags&flagCanAddr != 0 }
+func (a arg) directPtr() bool { return a.flags&flagDirectPtr != 0 }
+func (a arg) wrongAny() bool  { return a.flags&flagWrongAny != 0 }
+func (a arg) paired() bool    { return a.flags&flagPaired != 0 }
+
 func (a arg) canSet() bool {
-	return a.canAddr && !a.directPtr && !a.wrongAny
+	return a.flags&(flagCanAddr|flagDirectPtr|flagWrongAny) == flagCanAddr
 }
 
 // Arg represents a value of a known type.
@@ -35,11 +67,11 @@ func (a Arg[T]) CanSet() bool {
 
 // Get returns the underlying value.
 func (a Arg[T]) Get() T {
-	if a.arg.directPtr {
+	if a.arg.directPtr() {
 		// We have the value directly (for pointer types in interfaces)
 		// a.arg.p IS the T value (when T is a pointer type)
 		return *(*T)(unsafe.Pointer(&a.arg.p))
-	} else if a.arg.wrongAny {
+	} else if a.arg.wrongAny() {
 		return (*(*any)(a.arg.p)).(T)
 	} else {
 		// Normal case: we have a pointer to the value
@@ -57,6 +89,12 @@ func (a Arg[T]) Set(value T) {
 	}
 }
 
+// Value returns the underlying value as a reflect.Value. If the arg is settable, the reflect.Value is addressable and
+// refers to the same memory, otherwise it is a copy.
+func (a Arg[T]) Value() reflect.Value {
+	return valueOf(reflectType[T](), a.arg)
+}
+
 type Bool = Arg[bool]
 type Int = Arg[int]
 type Int8 = Arg[int8]
@@ -86,23 +124,273 @@ type CompileFn[Ctx any, In any] func(reflect.Type) WalkFn[Ctx, In]
 type typeFnEntry[Ctx any] struct {
 	t  g_reflect.Type
 	fn walkFn[Ctx]
-}
-
-type ifaceConvertFnEntry struct {
-	t  g_reflect.Type
-	fn ifaceConvertFn
+	// convFn converts an any to t. It is only set if t is an interface type.
+	convFn ifaceConvertFn
+	site   string
 }
 
 // Register stores a set of WalkFns used to walk specific types, and functions to compile WalkFns for kinds of types.
 type Register[Ctx any] struct {
-	typeFns         []typeFnEntry[Ctx]
-	compileFns      [numKind]unsafe.Pointer
-	ifaceConvertFns []ifaceConvertFnEntry
+	typeFns []typeFnEntry[Ctx]
+	// typeFnIndexes maps each type in typeFns to its index.
+	typeFnIndexes map[g_reflect.Type]int
+	compileFns    [numKind]unsafe.Pointer
+	compileSites  [numKind]string
+	strict        bool
+	errs          []error
 }
 
+type registerConfig struct {
+	strict bool
+}
+
+// RegisterOpt is an option to configure a new register.
+type RegisterOpt func(*registerConfig)
+
+var (
+	// WithStrictRegistration makes a Register reject a function registered for a type or kind that already has one.
+	// The first registration is kept, and the conflict is reported by Register.Err. NewWalker panics if given a
+	// strict Register with conflicts.
+	WithStrictRegistration RegisterOpt = func(r *registerConfig) {
+		r.strict = true
+	}
+)
+
 // NewRegister creates a new register.
-func NewRegister[Ctx any]() *Register[Ctx] {
-	return &Register[Ctx]{}
+//
+// By default, registering a function for a type or kind that already has one replaces the earlier function.
+func NewRegister[Ctx any](opts ...RegisterOpt) *Register[Ctx] {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return &Register[Ctx]{strict: cfg.strict}
+}
+
+// RegistrationConflictError is reported by a strict Register when a function is registered for a type or kind that
+// already has one.
+type RegistrationConflictError struct {
+	// Type is the type registered twice with RegisterTypeFn, or nil if a compile function was registered twice.
+	Type reflect.Type
+	// Kind is the kind registered twice, or the kind of Type.
+	Kind reflect.Kind
+	// FirstSite and SecondSite are the file and line of the calls that registered the conflicting functions.
+	FirstSite  string
+	SecondSite string
+}
+
+func (e *RegistrationConflictError) Error() string {
+	if e.Type != nil {
+		return fmt.Sprintf("type fn for %v registered at %s and again at %s", e.Type, e.FirstSite, e.SecondSite)
+	}
+	return fmt.Sprintf("compile fn for kind %v registered at %s and again at %s", e.Kind, e.FirstSite, e.SecondSite)
+}
+
+// Err returns the conflicts found by a strict Register, joined into a single error, or nil if there were none.
+// It always returns nil if the Register is not strict.
+func (r *Register[Ctx]) Err() error {
+	return errors.Join(r.errs...)
+}
+
+// Handler describes a function registered in a Register.
+type Handler struct {
+	// Type is the type the function was registered for with RegisterTypeFn, or nil for compile functions.
+	Type reflect.Type
+	// Kind is the kind of Type, or the kind the compile function was registered for.
+	Kind reflect.Kind
+	// Source is SourceTypeFn for functions registered with RegisterTypeFn, otherwise SourceCompileFn.
+	Source Source
+	// Site is the file and line of the call that registered the function.
+	Site string
+}
+
+// Lookup returns the Handler a Walker built from the register would use for values of type t - the function
+// registered for t if there is one, otherwise the compile function registered for t's kind.
+func (r *Register[Ctx]) Lookup(t reflect.Type) (Handler, bool) {
+	if i := r.typeFnIndex(g_reflect.ToType(t)); i >= 0 {
+		return r.typeFnHandler(&r.typeFns[i]), true
+	}
+	if k := t.Kind(); int(k) < numKind && r.compileFns[k] != nil {
+		return r.compileFnHandler(k), true
+	}
+	return Handler{}, false
+}
+
+// Handlers returns all functions registered in the register - first those registered with RegisterTypeFn in the
+// order they were registered, then compile functions ordered by kind.
+func (r *Register[Ctx]) Handlers() []Handler {
+	handlers := make([]Handler, 0, len(r.typeFns))
+	for i := range r.typeFns {
+		handlers = append(handlers, r.typeFnHandler(&r.typeFns[i]))
+	}
+	for k, fn := range r.compileFns {
+		if fn != nil {
+			handlers = append(handlers, r.compileFnHandler(reflect.Kind(k)))
+		}
+	}
+	return handlers
+}
+
+func (r *Register[Ctx]) typeFnHandler(e *typeFnEntry[Ctx]) Handler {
+	t := g_reflect.ToReflectType(e.t)
+	return Handler{Type: t, Kind: t.Kind(), Source: SourceTypeFn, Site: e.site}
+}
+
+func (r *Register[Ctx]) compileFnHandler(k reflect.Kind) Handler {
+	return Handler{Kind: k, Source: SourceCompileFn, Site: r.compileSites[k]}
+}
+
+func (r *Register[Ctx]) typeFnIndex(t g_reflect.Type) int {
+	if i, ok := r.typeFnIndexes[t]; ok {
+		return i
+	}
+	return -1
+}
+
+// appendTypeFn adds e, for a type without a registered function, to the end of typeFns.
+func (r *Register[Ctx]) appendTypeFn(e typeFnEntry[Ctx]) {
+	if r.typeFnIndexes == nil {
+		r.typeFnIndexes = map[g_reflect.Type]int{}
+	}
+	r.typeFnIndexes[e.t] = len(r.typeFns)
+	r.typeFns = append(r.typeFns, e)
+}
+
+func (r *Register[Ctx]) addTypeFn(e typeFnEntry[Ctx]) {
+	i := r.typeFnIndex(e.t)
+	if i < 0 {
+		r.appendTypeFn(e)
+	} else if r.strict {
+		r.errs = append(r.errs, &RegistrationConflictError{
+			Type:       g_reflect.ToReflectType(e.t),
+			Kind:       reflect.Kind(e.t.Kind()),
+			FirstSite:  r.typeFns[i].site,
+			SecondSite: e.site,
+		})
+	} else {
+		r.typeFns[i] = e
+	}
+}
+
+// setCompileFn must be called directly by the exported function registering the compile function, so the call site
+// can be found.
+func (r *Register[Ctx]) setCompileFn(k reflect.Kind, fn unsafe.Pointer) {
+	site := callSite(2)
+	if r.strict && r.compileFns[k] != nil {
+		r.errs = append(r.errs, &RegistrationConflictError{
+			Kind:       k,
+			FirstSite:  r.compileSites[k],
+			SecondSite: site,
+		})
+		return
+	}
+	r.compileFns[k] = fn
+	r.compileSites[k] = site
+}
+
+// callSite returns the file and line of the caller skip frames above the caller of callSite.
+func callSite(skip int) string {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+// Clone returns a copy of the register. Functions registered on the copy do not affect the original, and vice versa.
+func (r *Register[Ctx]) Clone() *Register[Ctx] {
+	return &Register[Ctx]{
+		typeFns:       slices.Clone(r.typeFns),
+		typeFnIndexes: maps.Clone(r.typeFnIndexes),
+		compileFns:    r.compileFns,
+		compileSites:  r.compileSites,
+		strict:        r.strict,
+		errs:          slices.Clone(r.errs),
+	}
+}
+
+// MergePolicy determines how Merge resolves a type or kind that has a function registered in both registers.
+type MergePolicy int
+
+const (
+	// MergeOverride replaces the function in the receiver with the function from the other register.
+	MergeOverride MergePolicy = iota
+	// MergeKeep keeps the function in the receiver, ignoring the function from the other register.
+	MergeKeep
+	// MergeError makes Merge return an error, without modifying the receiver.
+	MergeError
+)
+
+// MergeConflictError is returned by Merge with the MergeError policy, when both registers have functions registered
+// for the same types or kinds.
+type MergeConflictError struct {
+	Types []reflect.Type
+	Kinds []reflect.Kind
+}
+
+func (e *MergeConflictError) Error() string {
+	var parts []string
+	if len(e.Types) > 0 {
+		parts = append(parts, fmt.Sprintf("types %v", e.Types))
+	}
+	if len(e.Kinds) > 0 {
+		parts = append(parts, fmt.Sprintf("kinds %v", e.Kinds))
+	}
+	return "conflicting registrations for " + strings.Join(parts, " and ")
+}
+
+// Merge adds the functions registered in other to r. If a type has a function registered with RegisterTypeFn in
+// both registers, or a kind has a compile function registered in both, policy determines which is used, even if
+// either register is strict. If other is strict, r becomes strict, and keeps the conflicts found by other.
+func (r *Register[Ctx]) Merge(other *Register[Ctx], policy MergePolicy) error {
+	if policy == MergeError {
+		conflicts := &MergeConflictError{}
+		for _, e := range other.typeFns {
+			if r.typeFnIndex(e.t) >= 0 {
+				conflicts.Types = append(conflicts.Types, g_reflect.ToReflectType(e.t))
+			}
+		}
+		for k, fn := range other.compileFns {
+			if fn != nil && r.compileFns[k] != nil {
+				conflicts.Kinds = append(conflicts.Kinds, reflect.Kind(k))
+			}
+		}
+		if len(conflicts.Types) > 0 || len(conflicts.Kinds) > 0 {
+			return conflicts
+		}
+	}
+
+	for _, e := range other.typeFns {
+		if i := r.typeFnIndex(e.t); i < 0 {
+			r.appendTypeFn(e)
+		} else if policy == MergeOverride {
+			r.typeFns[i] = e
+		}
+	}
+	for k, fn := range other.compileFns {
+		if fn != nil && (r.compileFns[k] == nil || policy == MergeOverride) {
+			r.compileFns[k] = fn
+			r.compileSites[k] = other.compileSites[k]
+		}
+	}
+	r.strict = r.strict || other.strict
+	r.errs = append(r.errs, other.errs...)
+	return nil
+}
+
+// Layer returns a new register combining the functions registered in each of layers. When several layers register
+// a function for the same type or kind, the function from the last of them is used. The layers are not modified.
+// The new register is strict if any of the layers are, and keeps the conflicts found by each of them.
+//
+// For example, a base register with shared handlers can be extended with team-specific handlers by calling
+// NewWalker(Layer(base, team)).
+func Layer[Ctx any](layers ...*Register[Ctx]) *Register[Ctx] {
+	r := NewRegister[Ctx]()
+	for _, layer := range layers {
+		// MergeOverride never fails.
+		_ = r.Merge(layer, MergeOverride)
+	}
+	return r
 }
 
 // RegisterTypeFn registers a function to handle type In.
@@ -110,7 +398,7 @@ func RegisterTypeFn[Ctx any, In any](register *Register[Ctx], fn WalkFn[Ctx, In]
 	inType := reflectType[In]()
 	_, fp := g_reflect.TypeAndPtrOf(fn)
 	castFn := *(*walkFn[Ctx])(unsafe.Pointer(&fp))
-	register.typeFns = append(register.typeFns, typeFnEntry[Ctx]{t: inType, fn: castFn})
+	e := typeFnEntry[Ctx]{t: inType, fn: castFn, site: callSite(1)}
 
 	// When a map contains values of non-any interface types, we can only get at them using reflection, converted to
 	// any values. Normally, we don't need to be able to return an interface as a specific value, since the calling code
@@ -119,104 +407,102 @@ func RegisterTypeFn[Ctx any, In any](register *Register[Ctx], fn WalkFn[Ctx, In]
 	// actually assigning a variable of that type, which we can only do here where we have access to In as a type
 	// parameter. So we construct this function here, and use it during walking.
 	if inType.Kind() == reflect.Interface {
-		register.ifaceConvertFns = append(register.ifaceConvertFns, ifaceConvertFnEntry{
-			t: inType,
-			fn: func(a any) unsafe.Pointer {
-				conv := a.(In)
-				return unsafe.Pointer(&conv)
-			},
-		})
+		e.convFn = func(a any) unsafe.Pointer {
+			conv := a.(In)
+			return unsafe.Pointer(&conv)
+		}
 	}
+	register.addTypeFn(e)
 }
 
 // RegisterCompileBoolFn registers a compile function for types of kind Bool.
 func RegisterCompileBoolFn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, bool]) {
-	register.compileFns[reflect.Bool] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Bool, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileIntFn registers a compile function for types of kind Int.
 func RegisterCompileIntFn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, int]) {
-	register.compileFns[reflect.Int] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Int, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileInt8Fn registers a compile function for types of kind Int8.
 func RegisterCompileInt8Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, int8]) {
-	register.compileFns[reflect.Int8] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Int8, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileInt16Fn registers a compile function for types of kind Int16.
 func RegisterCompileInt16Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, int16]) {
-	register.compileFns[reflect.Int16] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Int16, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileInt32Fn registers a compile function for types of kind Int32.
 func RegisterCompileInt32Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, int32]) {
-	register.compileFns[reflect.Int32] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Int32, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileInt64Fn registers a compile function for types of kind Int64.
 func RegisterCompileInt64Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, int64]) {
-	register.compileFns[reflect.Int64] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Int64, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileUintFn registers a compile function for types of kind Uint.
 func RegisterCompileUintFn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, uint]) {
-	register.compileFns[reflect.Uint] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Uint, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileUint8Fn registers a compile function for types of kind Uint8.
 func RegisterCompileUint8Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, uint8]) {
-	register.compileFns[reflect.Uint8] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Uint8, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileUint16Fn registers a compile function for types of kind Uint16.
 func RegisterCompileUint16Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, uint16]) {
-	register.compileFns[reflect.Uint16] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Uint16, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileUint32Fn registers a compile function for types of kind Uint32.
 func RegisterCompileUint32Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, uint32]) {
-	register.compileFns[reflect.Uint32] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Uint32, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileUint64Fn registers a compile function for types of kind Uint64.
 func RegisterCompileUint64Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, uint64]) {
-	register.compileFns[reflect.Uint64] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Uint64, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileUintptrFn registers a compile function for types of kind Uintptr.
 func RegisterCompileUintptrFn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, uintptr]) {
-	register.compileFns[reflect.Uintptr] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Uintptr, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileFloat32Fn registers a compile function for types of kind Float32.
 func RegisterCompileFloat32Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, float32]) {
-	register.compileFns[reflect.Float32] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Float32, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileFloat64Fn registers a compile function for types of kind Float64.
 func RegisterCompileFloat64Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, float64]) {
-	register.compileFns[reflect.Float64] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Float64, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileComplex64Fn registers a compile function for types of kind Complex64.
 func RegisterCompileComplex64Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, complex64]) {
-	register.compileFns[reflect.Complex64] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Complex64, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileComplex128Fn registers a compile function for types of kind Complex128.
 func RegisterCompileComplex128Fn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, complex128]) {
-	register.compileFns[reflect.Complex128] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.Complex128, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileStringFn registers a compile function for types of kind String.
 func RegisterCompileStringFn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, string]) {
-	register.compileFns[reflect.String] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.String, eraseTypedCompileFn(fn))
 }
 
 // RegisterCompileUnsafePointerFn registers a compile function for types of kind UnsafePointer.
 func RegisterCompileUnsafePointerFn[Ctx any](register *Register[Ctx], fn CompileFn[Ctx, unsafe.Pointer]) {
-	register.compileFns[reflect.UnsafePointer] = eraseTypedCompileFn(fn)
+	register.setCompileFn(reflect.UnsafePointer, eraseTypedCompileFn(fn))
 }
 
 // CompileStructFn defines the function type that will be called to generate a WalkStructFn when a struct value is
@@ -228,7 +514,7 @@ type WalkStructFn[Ctx any] func(Ctx, Struct[Ctx]) error
 
 // RegisterCompileStructFn registers a compile function for types of kind Struct.
 func RegisterCompileStructFn[Ctx any](register *Register[Ctx], fn CompileStructFn[Ctx]) {
-	register.compileFns[reflect.Struct] = eraseCompileStructFn(fn)
+	register.setCompileFn(reflect.Struct, eraseCompileStructFn(fn))
 }
 
 // CompileArrayFn defines the function type that will be called to generate a WalkArrayFn when an array value is
@@ -240,7 +526,7 @@ type WalkArrayFn[Ctx any] func(Ctx, Array[Ctx]) error
 
 // RegisterCompileArrayFn registers a compile function for types of kind Array.
 func RegisterCompileArrayFn[Ctx any](register *Register[Ctx], fn CompileArrayFn[Ctx]) {
-	register.compileFns[reflect.Array] = eraseCompileArrayFn(fn)
+	register.setCompileFn(reflect.Array, eraseCompileArrayFn(fn))
 }
 
 // CompilePtrFn defines the function type that will be called to generate a WalkPtrFn when a pointer value is
@@ -252,7 +538,7 @@ type WalkPtrFn[Ctx any] func(Ctx, Ptr[Ctx]) error
 
 // RegisterCompilePtrFn registers a compile function for types of kind Ptr.
 func RegisterCompilePtrFn[Ctx any](register *Register[Ctx], fn CompilePtrFn[Ctx]) {
-	register.compileFns[reflect.Ptr] = eraseCompilePtrFn(fn)
+	register.setCompileFn(reflect.Ptr, eraseCompilePtrFn(fn))
 }
 
 // CompileSliceFn defines the function type that will be called to generate a WalkSliceFn when a slice value is
@@ -264,7 +550,7 @@ type WalkSliceFn[Ctx any] func(Ctx, Slice[Ctx]) error
 
 // RegisterCompileSliceFn registers a compile function for types of kind Slice.
 func RegisterCompileSliceFn[Ctx any](register *Register[Ctx], fn CompileSliceFn[Ctx]) {
-	register.compileFns[reflect.Slice] = eraseCompileSliceFn(fn)
+	register.setCompileFn(reflect.Slice, eraseCompileSliceFn(fn))
 }
 
 // CompileMapFn defines the function type that will be called to generate a WalkMapFn when a map value is
@@ -276,7 +562,7 @@ type WalkMapFn[Ctx any] func(Ctx, Map[Ctx]) error
 
 // RegisterCompileMapFn registers a compile function for types of kind Map.
 func RegisterCompileMapFn[Ctx any](register *Register[Ctx], fn CompileMapFn[Ctx]) {
-	register.compileFns[reflect.Map] = eraseCompileMapFn(fn)
+	register.setCompileFn(reflect.Map, eraseCompileMapFn(fn))
 }
 
 // CompileInterfaceFn defines the function type that will be called to generate a WalkInterfaceFn when an interface
@@ -288,7 +574,7 @@ type WalkInterfaceFn[Ctx any] func(Ctx, Interface[Ctx]) error
 
 // RegisterCompileInterfaceFn registers a compile function for types of kind Interface.
 func RegisterCompileInterfaceFn[Ctx any](register *Register[Ctx], fn CompileInterfaceFn[Ctx]) {
-	register.compileFns[reflect.Interface] = eraseCompileInterfaceFn(fn)
+	register.setCompileFn(reflect.Interface, eraseCompileInterfaceFn(fn))
 }
 
 func eraseTypedCompileFn[Ctx any, In any](fn CompileFn[Ctx, In]) unsafe.Pointer {
@@ -0,0 +1,230 @@
+package type_walk_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tw "github.com/zolstein/type-walk"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTypeFn[In any](prefix string) tw.WalkFn[*strings.Builder, In] {
+	return func(ctx *strings.Builder, v tw.Arg[In]) error {
+		_, err := fmt.Fprintf(ctx, "%s:%v", prefix, v.Get())
+		return err
+	}
+}
+
+func writeCompileFn[In any](prefix string) tw.CompileFn[*strings.Builder, In] {
+	return func(typ reflect.Type) tw.WalkFn[*strings.Builder, In] {
+		return writeTypeFn[In](prefix)
+	}
+}
+
+func walkToString(t *testing.T, register *tw.Register[*strings.Builder], v any) string {
+	var sb strings.Builder
+	err := tw.NewWalker(register).Walk(&sb, v)
+	require.NoError(t, err)
+	return sb.String()
+}
+
+func TestRegisterClone(t *testing.T) {
+	base := tw.NewRegister[*strings.Builder]()
+	tw.RegisterTypeFn(base, writeTypeFn[int]("base"))
+
+	clone := base.Clone()
+	tw.RegisterTypeFn(clone, writeTypeFn[int]("clone"))
+	tw.RegisterCompileStringFn(clone, writeCompileFn[string]("clone"))
+
+	assert.Equal(t, "base:1", walkToString(t, base, 1))
+	assert.Equal(t, "clone:1", walkToString(t, clone, 1))
+	assert.Equal(t, "clone:a", walkToString(t, clone, "a"))
+	err := tw.NewWalker(base).Walk(&strings.Builder{}, "a")
+	require.Error(t, err)
+}
+
+func TestRegisterMerge(t *testing.T) {
+	type MyInt int
+
+	newRegisters := func() (*tw.Register[*strings.Builder], *tw.Register[*strings.Builder]) {
+		a := tw.NewRegister[*strings.Builder]()
+		tw.RegisterTypeFn(a, writeTypeFn[int]("a"))
+		tw.RegisterCompileStringFn(a, writeCompileFn[string]("a"))
+		tw.RegisterCompileBoolFn(a, writeCompileFn[bool]("a"))
+
+		b := tw.NewRegister[*strings.Builder]()
+		tw.RegisterTypeFn(b, writeTypeFn[int]("b"))
+		tw.RegisterTypeFn(b, writeTypeFn[MyInt]("b"))
+		tw.RegisterCompileStringFn(b, writeCompileFn[string]("b"))
+		tw.RegisterCompileFloat64Fn(b, writeCompileFn[float64]("b"))
+		return a, b
+	}
+
+	t.Run("override", func(t *testing.T) {
+		a, b := newRegisters()
+		require.NoError(t, a.Merge(b, tw.MergeOverride))
+		assert.Equal(t, "b:1", walkToString(t, a, 1))
+		assert.Equal(t, "b:2", walkToString(t, a, MyInt(2)))
+		assert.Equal(t, "b:x", walkToString(t, a, "x"))
+		assert.Equal(t, "a:true", walkToString(t, a, true))
+		assert.Equal(t, "b:1.5", walkToString(t, a, 1.5))
+	})
+
+	t.Run("keep", func(t *testing.T) {
+		a, b := newRegisters()
+		require.NoError(t, a.Merge(b, tw.MergeKeep))
+		assert.Equal(t, "a:1", walkToString(t, a, 1))
+		assert.Equal(t, "b:2", walkToString(t, a, MyInt(2)))
+		assert.Equal(t, "a:x", walkToString(t, a, "x"))
+		assert.Equal(t, "a:true", walkToString(t, a, true))
+		assert.Equal(t, "b:1.5", walkToString(t, a, 1.5))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		a, b := newRegisters()
+		err := a.Merge(b, tw.MergeError)
+		var conflictErr *tw.MergeConflictError
+		require.True(t, errors.As(err, &conflictErr))
+		assert.Equal(t, []reflect.Type{reflect.TypeOf(0)}, conflictErr.Types)
+		assert.Equal(t, []reflect.Kind{reflect.String}, conflictErr.Kinds)
+		assert.Equal(t, "conflicting registrations for types [int] and kinds [string]", err.Error())
+
+		// The receiver is unmodified on error.
+		assert.Equal(t, "a:1", walkToString(t, a, 1))
+		err = tw.NewWalker(a).Walk(&strings.Builder{}, MyInt(2))
+		require.Error(t, err)
+	})
+}
+
+func TestLayer(t *testing.T) {
+	base := tw.NewRegister[*strings.Builder]()
+	tw.RegisterTypeFn(base, writeTypeFn[int]("base"))
+	tw.RegisterTypeFn(base, writeTypeFn[fmt.Stringer]("base"))
+	tw.RegisterCompileStringFn(base, writeCompileFn[string]("base"))
+	tw.RegisterCompileMapFn(base, func(typ reflect.Type) tw.WalkMapFn[*strings.Builder] {
+		return func(ctx *strings.Builder, m tw.Map[*strings.Builder]) error {
+			iter := m.Iter()
+			for iter.Next() {
+				if err := iter.Entry().Value().Walk(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+
+	team := tw.NewRegister[*strings.Builder]()
+	tw.RegisterTypeFn(team, writeTypeFn[int]("team"))
+
+	override := tw.NewRegister[*strings.Builder]()
+	tw.RegisterCompileStringFn(override, writeCompileFn[string]("override"))
+
+	layered := tw.Layer(base, team, override)
+	assert.Equal(t, "team:1", walkToString(t, layered, 1))
+	assert.Equal(t, "override:x", walkToString(t, layered, "x"))
+	assert.Equal(t, "base:abc", walkToString(t, layered, map[int]fmt.Stringer{1: StringWrapper("abc")}))
+
+	// The layers themselves are unchanged.
+	assert.Equal(t, "base:1", walkToString(t, base, 1))
+	assert.Equal(t, "base:x", walkToString(t, base, "x"))
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	register := tw.NewRegister[*strings.Builder]()
+	tw.RegisterTypeFn(register, writeTypeFn[int]("first"))
+	tw.RegisterTypeFn(register, writeTypeFn[int]("second"))
+	tw.RegisterCompileStringFn(register, writeCompileFn[string]("first"))
+	tw.RegisterCompileStringFn(register, writeCompileFn[string]("second"))
+
+	require.NoError(t, register.Err())
+	assert.Equal(t, "second:1", walkToString(t, register, 1))
+	assert.Equal(t, "second:x", walkToString(t, register, "x"))
+	assert.Len(t, register.Handlers(), 2)
+}
+
+func TestRegisterStrict(t *testing.T) {
+	register := tw.NewRegister[*strings.Builder](tw.WithStrictRegistration)
+	tw.RegisterTypeFn(register, writeTypeFn[int]("first"))
+	tw.RegisterCompileStringFn(register, writeCompileFn[string]("first"))
+	require.NoError(t, register.Err())
+
+	tw.RegisterTypeFn(register, writeTypeFn[int]("second"))
+	tw.RegisterCompileStringFn(register, writeCompileFn[string]("second"))
+
+	err := register.Err()
+	require.Error(t, err)
+	var conflictErr *tw.RegistrationConflictError
+	require.True(t, errors.As(err, &conflictErr))
+	assert.Equal(t, reflect.TypeOf(0), conflictErr.Type)
+	assert.Equal(t, reflect.Int, conflictErr.Kind)
+	assert.Contains(t, conflictErr.FirstSite, "register_test.go:")
+	assert.Contains(t, conflictErr.SecondSite, "register_test.go:")
+	assert.NotEqual(t, conflictErr.FirstSite, conflictErr.SecondSite)
+	assert.Contains(t, err.Error(), "type fn for int registered at ")
+	assert.Contains(t, err.Error(), "compile fn for kind string registered at ")
+
+	// The first registration is kept.
+	h, ok := register.Lookup(reflect.TypeOf(0))
+	require.True(t, ok)
+	assert.Equal(t, conflictErr.FirstSite, h.Site)
+
+	// A Walker can't be built from a register with conflicts.
+	assert.PanicsWithError(t, err.Error(), func() { tw.NewWalker(register) })
+}
+
+func TestRegisterStrictCombined(t *testing.T) {
+	strict := tw.NewRegister[*strings.Builder](tw.WithStrictRegistration)
+	tw.RegisterTypeFn(strict, writeTypeFn[int]("first"))
+	tw.RegisterTypeFn(strict, writeTypeFn[int]("second"))
+	conflict := strict.Err()
+	require.Error(t, conflict)
+
+	// The conflict is kept, however the strict register is combined with others.
+	layered := tw.Layer(tw.NewRegister[*strings.Builder](), strict)
+	assert.Equal(t, conflict.Error(), layered.Err().Error())
+	merged := tw.NewRegister[*strings.Builder]()
+	require.NoError(t, merged.Merge(strict, tw.MergeKeep))
+	assert.Equal(t, conflict.Error(), merged.Err().Error())
+	adapted := tw.Adapt(strict, func(sb *strings.Builder) *strings.Builder { return sb })
+	assert.Equal(t, conflict.Error(), adapted.Err().Error())
+	assert.Panics(t, func() { tw.NewWalker(layered) })
+
+	// A register layered on a strict one is strict too, so it reports conflicts of its own.
+	layered = tw.Layer(tw.NewRegister[*strings.Builder](tw.WithStrictRegistration), tw.NewRegister[*strings.Builder]())
+	require.NoError(t, layered.Err())
+	tw.RegisterCompileStringFn(layered, writeCompileFn[string]("first"))
+	tw.RegisterCompileStringFn(layered, writeCompileFn[string]("second"))
+	assert.ErrorContains(t, layered.Err(), "compile fn for kind string registered at ")
+}
+
+func TestRegisterLookup(t *testing.T) {
+	type MyInt int
+	register := tw.NewRegister[*strings.Builder]()
+	tw.RegisterTypeFn(register, writeTypeFn[MyInt]("my"))
+	tw.RegisterCompileIntFn(register, writeCompileFn[int]("int"))
+
+	h, ok := register.Lookup(reflect.TypeOf(MyInt(0)))
+	require.True(t, ok)
+	assert.Equal(t, reflect.TypeOf(MyInt(0)), h.Type)
+	assert.Equal(t, reflect.Int, h.Kind)
+	assert.Equal(t, tw.SourceTypeFn, h.Source)
+	assert.Contains(t, h.Site, "register_test.go:")
+
+	h, ok = register.Lookup(reflect.TypeOf(0))
+	require.True(t, ok)
+	assert.Nil(t, h.Type)
+	assert.Equal(t, reflect.Int, h.Kind)
+	assert.Equal(t, tw.SourceCompileFn, h.Source)
+	assert.Contains(t, h.Site, "register_test.go:")
+
+	_, ok = register.Lookup(reflect.TypeOf(""))
+	assert.False(t, ok)
+
+	handlers := register.Handlers()
+	require.Len(t, handlers, 2)
+	assert.Equal(t, tw.SourceTypeFn, handlers[0].Source)
+	assert.Equal(t, tw.SourceCompileFn, handlers[1].Source)
+}
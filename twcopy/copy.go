@@ -0,0 +1,365 @@
+// Package twcopy makes deep copies of values, using type-walk Walkers.
+//
+// Unexported fields are copied like exported ones. Pointers and maps, and slices with the same backing array and
+// length, that alias each other in the original alias each other in the copy, so cyclic values can be copied.
+// Strings, unsafe.Pointers, channels and functions are shared, and values the Walker can't compile, like []func(), are
+// copied shallowly.
+package twcopy
+
+import (
+	"reflect"
+	"sync"
+	"time"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+)
+
+// Register stores functions that copy values of particular types, which take the place of the default deep copy.
+// Functions must be registered before the Register is used to create a Copier.
+type Register struct {
+	r *tw.Register[*state]
+}
+
+// NewRegister returns a new Register. Values of type *time.Location are shared rather than copied, since time
+// compares them with the locations it defines, like time.Local, by address.
+func NewRegister() *Register {
+	r := &Register{r: tw.NewRegister[*state]()}
+	RegisterShared[*time.Location](r)
+	return r
+}
+
+// RegisterCopyFn registers fn to copy values of type T, including T in struct fields, elements and interfaces. fn is
+// called with the value being copied, and returns its copy.
+func RegisterCopyFn[T any](r *Register, fn func(T) T) {
+	tw.RegisterTypeFn(r.r, func(s *state, a tw.Arg[T]) error {
+		src, _ := a.Pair()
+		a.Set(fn(src.Get()))
+		return nil
+	})
+}
+
+// RegisterShared registers values of type T to be shared rather than copied, e.g. because they are immutable. A
+// shared value is copied by assignment.
+func RegisterShared[T any](r *Register) {
+	RegisterCopyFn(r, func(v T) T {
+		return v
+	})
+}
+
+// A Copier makes deep copies of values. It is safe for concurrent use.
+type Copier struct {
+	r      *tw.Register[*state]
+	walker *tw.Walker[*state]
+	// registered reports whether a type has a function registered in r.
+	registered func(reflect.Type) bool
+	// assign caches whether values of each type are copied by assignment.
+	assign sync.Map
+}
+
+// NewCopier returns a Copier which uses the functions in r, or those in NewRegister if r is nil.
+func NewCopier(r *Register) *Copier {
+	if r == nil {
+		r = NewRegister()
+	}
+	c := &Copier{r: r.r.Clone()}
+	c.registered = walkutil.Registered(c.r)
+	c.register()
+	c.walker = tw.NewWalker(c.r, tw.WithThreadSafe)
+	return c
+}
+
+var defaultCopier = NewCopier(nil)
+
+// Copy returns a deep copy of v.
+func Copy[T any](v T) T {
+	return CopyWith(defaultCopier, v)
+}
+
+// CopyWith returns a deep copy of v made by c.
+func CopyWith[T any](c *Copier, v T) T {
+	var dst T
+	s := newState(c)
+	defer s.release()
+	s.copyTo(reflect.TypeOf((*T)(nil)).Elem(), unsafe.Pointer(&dst), unsafe.Pointer(&v))
+	return dst
+}
+
+// Copy returns a deep copy of v, with the same dynamic type.
+func (c *Copier) Copy(v any) any {
+	if v == nil {
+		return nil
+	}
+	s := newState(c)
+	defer s.release()
+	return s.copyValue(reflect.TypeOf(v), v).Interface()
+}
+
+type state struct {
+	c *Copier
+	// seen maps the pointers, slices and maps which have been copied to their copies.
+	seen map[walkutil.Key]reflect.Value
+}
+
+var statePool walkutil.Pool[state]
+
+func newState(c *Copier) *state {
+	s := statePool.Get()
+	s.c = c
+	return s
+}
+
+func (s *state) release() {
+	s.c = nil
+	clear(s.seen)
+	statePool.Put(s)
+}
+
+// copyTo copies the value of type t at src to the zero value at dst.
+func (s *state) copyTo(t reflect.Type, dst, src unsafe.Pointer) {
+	if s.c.byAssignment(t) {
+		reflect.NewAt(t, dst).Elem().Set(reflect.NewAt(t, src).Elem())
+		return
+	}
+	if err := s.c.walker.WalkPairUnsafe(s, t, dst, src, true); err != nil {
+		// Every kind has a function, and types the Walker can't compile are handled above.
+		panic(err)
+	}
+}
+
+// copyValue returns a copy of v as a value of type t.
+func (s *state) copyValue(t reflect.Type, v any) reflect.Value {
+	src := reflect.New(t).Elem()
+	if v != nil {
+		src.Set(reflect.ValueOf(v))
+	}
+	if s.c.byAssignment(t) {
+		return src
+	}
+	dst := reflect.New(t)
+	s.copyTo(t, dst.UnsafePointer(), src.Addr().UnsafePointer())
+	return dst.Elem()
+}
+
+// see returns the copy of the value identified by key, if it has been copied.
+func (s *state) see(key walkutil.Key) (reflect.Value, bool) {
+	v, ok := s.seen[key]
+	return v, ok
+}
+
+// saw records that v is the copy of the value identified by key.
+func (s *state) saw(key walkutil.Key, v reflect.Value) {
+	if s.seen == nil {
+		s.seen = map[walkutil.Key]reflect.Value{}
+	}
+	s.seen[key] = v
+}
+
+func (c *Copier) register() {
+	tw.RegisterCompileBoolFn(c.r, compileAssign[bool])
+	tw.RegisterCompileIntFn(c.r, compileAssign[int])
+	tw.RegisterCompileInt8Fn(c.r, compileAssign[int8])
+	tw.RegisterCompileInt16Fn(c.r, compileAssign[int16])
+	tw.RegisterCompileInt32Fn(c.r, compileAssign[int32])
+	tw.RegisterCompileInt64Fn(c.r, compileAssign[int64])
+	tw.RegisterCompileUintFn(c.r, compileAssign[uint])
+	tw.RegisterCompileUint8Fn(c.r, compileAssign[uint8])
+	tw.RegisterCompileUint16Fn(c.r, compileAssign[uint16])
+	tw.RegisterCompileUint32Fn(c.r, compileAssign[uint32])
+	tw.RegisterCompileUint64Fn(c.r, compileAssign[uint64])
+	tw.RegisterCompileUintptrFn(c.r, compileAssign[uintptr])
+	tw.RegisterCompileFloat32Fn(c.r, compileAssign[float32])
+	tw.RegisterCompileFloat64Fn(c.r, compileAssign[float64])
+	tw.RegisterCompileComplex64Fn(c.r, compileAssign[complex64])
+	tw.RegisterCompileComplex128Fn(c.r, compileAssign[complex128])
+	tw.RegisterCompileStringFn(c.r, compileAssign[string])
+	tw.RegisterCompileUnsafePointerFn(c.r, compileAssign[unsafe.Pointer])
+	tw.RegisterCompileStructFn(c.r, c.compileStruct)
+	tw.RegisterCompileArrayFn(c.r, compileArray)
+	tw.RegisterCompileSliceFn(c.r, c.compileSlice)
+	tw.RegisterCompilePtrFn(c.r, compilePtr)
+	tw.RegisterCompileMapFn(c.r, compileMap)
+	tw.RegisterCompileInterfaceFn(c.r, compileInterface)
+}
+
+// The copy is walked paired with the original, and each part of it is created before it's walked, so Pair always
+// succeeds and every value starts settable and zero.
+
+func compileAssign[T any](reflect.Type) tw.WalkFn[*state, T] {
+	return func(s *state, a tw.Arg[T]) error {
+		src, _ := a.Pair()
+		a.Set(src.Get())
+		return nil
+	}
+}
+
+// structField is a field copied by a struct copy.
+type structField struct {
+	// num is the index of the field in the StructFieldRegister.
+	num int
+	// assign is set if the field's type contains a channel or function, which the Walker can't compile, so the field is
+	// copied by assignment.
+	assign bool
+	typ    reflect.Type
+	offset uintptr
+}
+
+func (c *Copier) compileStruct(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*state] {
+	fields := make([]structField, t.NumField())
+	for i := range fields {
+		f := t.Field(i)
+		sf := structField{typ: f.Type, offset: f.Offset}
+		if sf.assign = walkutil.Unsupported(f.Type, c.registered) != nil; !sf.assign {
+			sf.num = sfr.RegisterField(i)
+		}
+		fields[i] = sf
+	}
+
+	return func(s *state, st tw.Struct[*state]) error {
+		for i := range fields {
+			f := &fields[i]
+			if f.assign {
+				assignField(st, f)
+				continue
+			}
+			if err := st.Field(f.num).Walk(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// assignField copies field f of the original struct to the copy by assignment. The original is copied to make it
+// addressable, since reflect can't otherwise read unexported fields.
+func assignField(st tw.Struct[*state], f *structField) {
+	pair, _ := st.Pair()
+	src := pair.Value()
+	if !src.CanAddr() {
+		c := reflect.New(src.Type()).Elem()
+		c.Set(src)
+		src = c
+	}
+	dst := st.Value().Addr().UnsafePointer()
+	reflect.NewAt(f.typ, unsafe.Add(dst, f.offset)).Elem().Set(
+		reflect.NewAt(f.typ, unsafe.Add(src.Addr().UnsafePointer(), f.offset)).Elem(),
+	)
+}
+
+// shallow reports whether values of type t can be copied by assignment, because they don't refer to any memory which
+// must be copied.
+func (c *Copier) shallow(t reflect.Type) bool {
+	if c.registered(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Array:
+		return c.shallow(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !c.shallow(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice, reflect.Pointer, reflect.Map, reflect.Interface, reflect.Chan, reflect.Func:
+		return false
+	}
+	return true
+}
+
+// byAssignment reports whether values of type t are copied by assignment, because they are shallow or unsupported.
+func (c *Copier) byAssignment(t reflect.Type) bool {
+	if assign, ok := c.assign.Load(t); ok {
+		return assign.(bool)
+	}
+	assign := c.shallow(t) || walkutil.Unsupported(t, c.registered) != nil
+	c.assign.Store(t, assign)
+	return assign
+}
+
+func compileArray(reflect.Type) tw.WalkArrayFn[*state] {
+	return func(s *state, a tw.Array[*state]) error {
+		return a.WalkAll(s)
+	}
+}
+
+func (c *Copier) compileSlice(t reflect.Type) tw.WalkSliceFn[*state] {
+	shallow := c.shallow(t.Elem())
+	return func(s *state, sl tw.Slice[*state]) error {
+		src, _ := sl.Pair()
+		if src.IsNil() {
+			return nil
+		}
+		srcValue := src.Value()
+		key := walkutil.Key{P: srcValue.UnsafePointer(), Len: srcValue.Len(), Type: t}
+		if v, ok := s.see(key); ok {
+			sl.Value().Set(v)
+			return nil
+		}
+		dst := sl.Value()
+		dst.Set(reflect.MakeSlice(t, srcValue.Len(), srcValue.Len()))
+		s.saw(key, dst)
+		if shallow {
+			reflect.Copy(dst, srcValue)
+			return nil
+		}
+		return sl.WalkAll(s)
+	}
+}
+
+func compilePtr(t reflect.Type) tw.WalkPtrFn[*state] {
+	return func(s *state, p tw.Ptr[*state]) error {
+		src, _ := p.Pair()
+		if src.IsNil() {
+			return nil
+		}
+		key := walkutil.Key{P: walkutil.PointerOf(src.Interface()), Type: t}
+		if v, ok := s.see(key); ok {
+			p.Value().Set(v)
+			return nil
+		}
+		p.Alloc()
+		s.saw(key, p.Value())
+		return p.Walk(s)
+	}
+}
+
+func compileMap(t reflect.Type) tw.WalkMapFn[*state] {
+	return func(s *state, m tw.Map[*state]) error {
+		src, _ := m.Pair()
+		if src.IsNil() {
+			return nil
+		}
+		key := walkutil.Key{P: walkutil.PointerOf(src.Interface()), Type: t}
+		if v, ok := s.see(key); ok {
+			m.Value().Set(v)
+			return nil
+		}
+		dst := m.Value()
+		dst.Set(reflect.MakeMapWithSize(t, src.Len()))
+		s.saw(key, dst)
+		iter := src.Iter()
+		for iter.Next() {
+			entry := iter.Entry()
+			dst.SetMapIndex(
+				s.copyValue(t.Key(), entry.Key().Interface()),
+				s.copyValue(t.Elem(), entry.Value().Interface()),
+			)
+		}
+		return nil
+	}
+}
+
+func compileInterface(reflect.Type) tw.WalkInterfaceFn[*state] {
+	return func(s *state, i tw.Interface[*state]) error {
+		src, _ := i.Pair()
+		v := src.Interface()
+		if v == nil {
+			return nil
+		}
+		i.Value().Set(s.copyValue(reflect.TypeOf(v), v))
+		return nil
+	}
+}
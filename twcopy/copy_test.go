@@ -0,0 +1,212 @@
+package twcopy_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zolstein/type-walk/twcopy"
+)
+
+type Section struct {
+	Number int
+	Lines  []string
+}
+
+type Document struct {
+	*Section
+	Index   map[string][]int
+	Meta    any
+	Pages   [2]*Section
+	Created time.Time
+	draft   *Section
+}
+
+// assertNoSharing asserts that no pointer, slice or map in a refers to the same memory as the corresponding one in b,
+// other than locations and slices of channels and functions, which are shared.
+func assertNoSharing(t *testing.T, a, b reflect.Value, path string) {
+	switch a.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		if a.IsNil() || a.Kind() == reflect.Slice && a.Cap() == 0 {
+			return
+		}
+		if elem := a.Type().Elem().Kind(); a.Type() == reflect.TypeOf((*time.Location)(nil)) || elem == reflect.Func || elem == reflect.Chan {
+			return
+		}
+		assert.NotEqual(t, a.UnsafePointer(), b.UnsafePointer(), "%s is shared", path)
+	}
+	switch a.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !a.IsNil() {
+			assertNoSharing(t, a.Elem(), b.Elem(), path)
+		}
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			assertNoSharing(t, a.Field(i), b.Field(i), path+"."+a.Type().Field(i).Name)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			assertNoSharing(t, a.Index(i), b.Index(i), path+"[]")
+		}
+	case reflect.Map:
+		for _, k := range a.MapKeys() {
+			assertNoSharing(t, a.MapIndex(k), b.MapIndex(k), path+"[]")
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	values := []any{
+		1,
+		[]int{1, 2},
+		[]int{},
+		[]int(nil),
+		map[int]string{1: "a"},
+		&Section{Number: 1, Lines: []string{"x"}},
+		[]any{nil, 1, []any{2}},
+		Document{
+			Section: &Section{Number: 1},
+			Index:   map[string][]int{"a": {1, 2}, "b": nil, "c": {}},
+			Meta:    map[string]any{"s": &Section{Number: 3}},
+			Pages:   [2]*Section{{Number: 4}, nil},
+			Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local),
+			draft:   &Section{Number: 6},
+		},
+	}
+	for _, v := range values {
+		actual := twcopy.NewCopier(nil).Copy(v)
+		require.Equal(t, v, actual)
+		assertNoSharing(t, reflect.ValueOf(v), reflect.ValueOf(actual), "")
+	}
+
+	// The copy can be modified without modifying the original, including through unexported fields.
+	d := Document{Index: map[string][]int{"a": {1}}, draft: &Section{Number: 6}}
+	actual := twcopy.Copy(d)
+	actual.Index["a"][0] = 9
+	actual.draft.Number = 9
+	assert.Equal(t, 1, d.Index["a"][0])
+	assert.Equal(t, 6, d.draft.Number)
+	// Locations are compared by address, so they're shared.
+	assert.Same(t, time.Local, twcopy.Copy(time.Now()).Location())
+}
+
+func TestCopyAliasing(t *testing.T) {
+	shared := &Section{Number: 1, Lines: []string{"x", "y"}}
+	m := map[string]int{"a": 1}
+	type Aliases struct {
+		P, Q   *Section
+		Any    any
+		Values map[string]*Section
+		S, T   []string
+		Half   []string
+		M1, M2 map[string]int
+		AnyMap any
+	}
+	a := Aliases{
+		P:      shared,
+		Q:      shared,
+		Any:    shared,
+		Values: map[string]*Section{"a": shared, "b": shared},
+		S:      shared.Lines,
+		T:      shared.Lines,
+		Half:   shared.Lines[:1],
+		M1:     m,
+		M2:     m,
+		AnyMap: m,
+	}
+	actual := twcopy.Copy(a)
+	assert.Equal(t, a, actual)
+	assert.NotSame(t, shared, actual.P)
+	// Pointers to the same value point to the same copy, however they're reached.
+	assert.Same(t, actual.P, actual.Q)
+	assert.Same(t, actual.P, actual.Any)
+	assert.Same(t, actual.P, actual.Values["a"])
+	assert.Same(t, actual.P, actual.Values["b"])
+	assert.Same(t, &actual.P.Lines[0], &actual.S[0])
+	assert.Same(t, &actual.S[0], &actual.T[0])
+	// Slices are only aliased if they have the same length.
+	assert.NotSame(t, &actual.S[0], &actual.Half[0])
+	actual.M1["b"] = 2
+	assert.Equal(t, 2, actual.M2["b"])
+	assert.Equal(t, 2, actual.AnyMap.(map[string]int)["b"])
+	assert.Len(t, m, 1)
+
+	// Aliases which form cycles are kept too.
+	type Ring struct {
+		Value int
+		Next  *Ring
+	}
+	ring := &Ring{Value: 1}
+	ring.Next = &Ring{Value: 2, Next: ring}
+	ringCopy := twcopy.Copy(ring)
+	assert.NotSame(t, ring, ringCopy)
+	assert.Same(t, ringCopy, ringCopy.Next.Next)
+
+	type M map[string]any
+	self := M{"a": 1}
+	self["self"] = self
+	selfCopy := twcopy.Copy(self)
+	selfCopy["b"] = 2
+	assert.Equal(t, 2, selfCopy["self"].(M)["b"])
+	assert.Len(t, self, 2)
+}
+
+func TestCopyShared(t *testing.T) {
+	type Handlers struct {
+		Name  string
+		Ch    chan int
+		Hooks []func()
+		Fn    func()
+	}
+	h := Handlers{Name: "name", Ch: make(chan int), Hooks: []func(){func() {}}, Fn: func() {}}
+	actual := twcopy.Copy(h)
+	// Strings can't be modified, so their bytes are shared.
+	assert.Equal(t, unsafe.StringData(h.Name), unsafe.StringData(actual.Name))
+	assert.Equal(t, h.Ch, actual.Ch)
+	assert.NotNil(t, actual.Fn)
+	// Slices of functions can't be copied deeply, so they're copied shallowly.
+	assert.Same(t, &h.Hooks[0], &actual.Hooks[0])
+}
+
+func TestRegisterCopyFn(t *testing.T) {
+	r := twcopy.NewRegister()
+	twcopy.RegisterShared[*Section](r)
+	twcopy.RegisterCopyFn(r, func(s string) string {
+		return s + "!"
+	})
+	c := twcopy.NewCopier(r)
+
+	section := &Section{Number: 1}
+	type S struct {
+		P    *Section
+		Name string
+		Any  any
+	}
+	actual := twcopy.CopyWith(c, S{P: section, Name: "a", Any: "b"})
+	assert.Same(t, section, actual.P)
+	assert.Equal(t, "a!", actual.Name)
+	assert.Equal(t, "b!", actual.Any)
+	assert.Equal(t, map[string]string{"k!": "v!"}, c.Copy(map[string]string{"k": "v"}))
+	// The default Copier doesn't use the register.
+	assert.NotSame(t, section, twcopy.Copy(S{P: section}).P)
+}
+
+func BenchmarkCopy(b *testing.B) {
+	newDocument := func() Document {
+		intro := &Section{Number: 1, Lines: []string{"x"}}
+		return Document{
+			Section: intro,
+			Index:   map[string][]int{"a": {1, 2}, "b": nil},
+			Meta:    []any{1, "two", &Section{Number: 3}},
+			Pages:   [2]*Section{intro, {Number: 5}},
+		}
+	}
+	docs := []Document{newDocument(), newDocument(), newDocument()}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = twcopy.Copy(docs)
+	}
+}
@@ -0,0 +1,559 @@
+// Package twdiff finds the differences between two values of the same type, using type-walk Walkers.
+//
+// Each difference is a Change at the most deeply nested path that differs. Diff reports no changes exactly when
+// reflect.DeepEqual reports the values equal, except for a shared slice holding NaN. Values the Walker can't compile
+// are compared whole, so they're reported as at most one Change.
+package twdiff
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+	"github.com/zolstein/type-walk/twequal"
+)
+
+// Kind is the kind of a Change.
+type Kind int
+
+const (
+	// Changed means the value at the path differs between the old and new values.
+	Changed Kind = iota
+	// Added means the path, a slice element or map entry, only exists in the new value. Old is nil.
+	Added
+	// Removed means the path, a slice element or map entry, only exists in the old value. New is nil.
+	Removed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Changed:
+		return "changed"
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Change is a difference between two values.
+type Change struct {
+	// Path is the path from the root of the values to the value that differs, written as Go selectors and index
+	// expressions, e.g. `.Items[2].Tags["a"]`. It is empty for the roots themselves. Pointers and interfaces are
+	// followed without adding to the path.
+	Path string
+	Kind Kind
+	// Old and New are the old and new values at the path.
+	Old, New any
+}
+
+// String returns a human-readable description of the change.
+func (c Change) String() string {
+	path := c.Path
+	if path == "" {
+		path = "(root)"
+	}
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("%s: added %#v", path, c.New)
+	case Removed:
+		return fmt.Sprintf("%s: removed %#v", path, c.Old)
+	}
+	return fmt.Sprintf("%s: %#v -> %#v", path, c.Old, c.New)
+}
+
+// Format returns a human-readable description of changes, with one change per line, suitable for test failures.
+func Format(changes []Change) string {
+	var sb strings.Builder
+	for _, c := range changes {
+		sb.WriteString(c.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+type config struct {
+	lcs bool
+}
+
+// Option configures a Differ.
+type Option func(*config)
+
+// WithLCS aligns the elements of slices by finding their longest common subsequence, so that inserting or removing
+// elements is reported as elements being added or removed, rather than as every following element changing. Elements
+// are aligned if they are deeply equal, and unaligned elements between aligned ones are compared with each other in
+// order. Alignment takes time proportional to the product of the slices' lengths.
+//
+// Without WithLCS, elements are compared by index, and the elements past the end of the shorter slice are added or
+// removed. With WithLCS, the paths of added elements are indices in the new slice, and those of other elements are
+// indices in the old slice.
+var WithLCS Option = func(c *config) {
+	c.lcs = true
+}
+
+// A Differ finds the differences between values. It is safe for concurrent use.
+type Differ struct {
+	walker *tw.Walker[*state]
+}
+
+// NewDiffer returns a Differ configured by opts.
+func NewDiffer(opts ...Option) *Differ {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	d := &Differ{}
+	d.walker = tw.NewWalker(cfg.newRegister(d), tw.WithThreadSafe)
+	return d
+}
+
+var defaultDiffer = NewDiffer()
+
+// Diff returns the differences from old to new under opts. Diff compiles new functions for every call with options,
+// so a Differ should be used instead to diff many values with the same options.
+func Diff(old, new any, opts ...Option) []Change {
+	if len(opts) == 0 {
+		return defaultDiffer.Diff(old, new)
+	}
+	return NewDiffer(opts...).Diff(old, new)
+}
+
+// Diff returns the differences from old to new, in the order the values are walked, with map entries sorted by key.
+// Values of different types are reported as a single Change.
+func (d *Differ) Diff(old, new any) []Change {
+	s := newState()
+	defer s.release()
+	s.diff(d, old, new)
+	return slices.Clip(s.changes)
+}
+
+type state struct {
+	path    []byte
+	changes []Change
+	// seen holds the pairs of pointers, maps and slices being diffed, so that cycles are diffed once rather than until
+	// the stack overflows. A pair which is reached again while it's being diffed has no further differences.
+	seen walkutil.Set
+}
+
+var statePool walkutil.Pool[state]
+
+func newState() *state {
+	return statePool.Get()
+}
+
+func (s *state) release() {
+	s.path = s.path[:0]
+	// The changes are returned, so they can't be reused.
+	s.changes = nil
+	s.seen.Clear()
+	statePool.Put(s)
+}
+
+// diff appends the differences from old to new to s.changes, at the current path.
+func (s *state) diff(d *Differ, old, new any) {
+	if old == nil || new == nil || reflect.TypeOf(old) != reflect.TypeOf(new) {
+		if !reflect.DeepEqual(old, new) {
+			s.add(Changed, old, new)
+		}
+		return
+	}
+	n, path := len(s.changes), len(s.path)
+	if err := d.walker.WalkPair(s, old, new); err != nil {
+		// The values contain a kind the Walker can't compile.
+		s.changes, s.path = s.changes[:n], s.path[:path]
+		if !reflect.DeepEqual(old, new) {
+			s.add(Changed, old, new)
+		}
+	}
+}
+
+func (s *state) add(kind Kind, old, new any) {
+	s.changes = append(s.changes, Change{Path: string(s.path), Kind: kind, Old: old, New: new})
+}
+
+// push appends a step to the path, and returns the length to restore the path to with pop.
+func (s *state) push(step string) int {
+	n := len(s.path)
+	s.path = append(s.path, step...)
+	return n
+}
+
+func (s *state) pushIndex(i int) int {
+	n := len(s.path)
+	s.path = fmt.Appendf(s.path, "[%d]", i)
+	return n
+}
+
+func (s *state) pop(n int) {
+	s.path = s.path[:n]
+}
+
+func (c *config) newRegister(d *Differ) *tw.Register[*state] {
+	r := tw.NewRegister[*state]()
+	tw.RegisterCompileBoolFn(r, compileComparable[bool])
+	tw.RegisterCompileIntFn(r, compileComparable[int])
+	tw.RegisterCompileInt8Fn(r, compileComparable[int8])
+	tw.RegisterCompileInt16Fn(r, compileComparable[int16])
+	tw.RegisterCompileInt32Fn(r, compileComparable[int32])
+	tw.RegisterCompileInt64Fn(r, compileComparable[int64])
+	tw.RegisterCompileUintFn(r, compileComparable[uint])
+	tw.RegisterCompileUint8Fn(r, compileComparable[uint8])
+	tw.RegisterCompileUint16Fn(r, compileComparable[uint16])
+	tw.RegisterCompileUint32Fn(r, compileComparable[uint32])
+	tw.RegisterCompileUint64Fn(r, compileComparable[uint64])
+	tw.RegisterCompileUintptrFn(r, compileComparable[uintptr])
+	tw.RegisterCompileFloat32Fn(r, compileComparable[float32])
+	tw.RegisterCompileFloat64Fn(r, compileComparable[float64])
+	tw.RegisterCompileComplex64Fn(r, compileComparable[complex64])
+	tw.RegisterCompileComplex128Fn(r, compileComparable[complex128])
+	tw.RegisterCompileStringFn(r, compileComparable[string])
+	tw.RegisterCompileUnsafePointerFn(r, compileComparable[unsafe.Pointer])
+	tw.RegisterCompileStructFn(r, compileStruct)
+	tw.RegisterCompileArrayFn(r, compileArray)
+	tw.RegisterCompileSliceFn(r, c.compileSlice(d))
+	tw.RegisterCompilePtrFn(r, compilePtr)
+	tw.RegisterCompileMapFn(r, compileMap)
+	tw.RegisterCompileInterfaceFn(r, compileInterface)
+	return r
+}
+
+// Slices and maps report the elements and entries only one value has themselves, and walk the rest, so Pair always
+// succeeds.
+
+func compileComparable[T comparable](t reflect.Type) tw.WalkFn[*state, T] {
+	return func(s *state, a tw.Arg[T]) error {
+		pair, _ := a.Pair()
+		if a.Get() != pair.Get() {
+			// The Arg's own Value has type T, which differs from t if t is a named type.
+			s.add(Changed, a.Value().Convert(t).Interface(), pair.Value().Convert(t).Interface())
+		}
+		return nil
+	}
+}
+
+// structField is a field diffed by a struct diff.
+type structField struct {
+	// num is the index of the field in the StructFieldRegister.
+	num int
+	// step is the field's selector in paths.
+	step string
+	// deep is set if the field's type contains a channel or function, which the Walker can't compile, so the field is
+	// compared with reflect.DeepEqual.
+	deep   bool
+	typ    reflect.Type
+	offset uintptr
+}
+
+func compileStruct(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*state] {
+	fields := make([]structField, t.NumField())
+	for i := range fields {
+		f := t.Field(i)
+		sf := structField{step: "." + f.Name, typ: f.Type, offset: f.Offset}
+		if sf.deep = walkutil.Unsupported(f.Type, nil) != nil; !sf.deep {
+			sf.num = sfr.RegisterField(i)
+		}
+		fields[i] = sf
+	}
+
+	return func(s *state, st tw.Struct[*state]) error {
+		for i := range fields {
+			f := &fields[i]
+			n := s.push(f.step)
+			if f.deep {
+				pair, _ := st.Pair()
+				old, new := fieldValue(st, f), fieldValue(pair, f)
+				if !reflect.DeepEqual(old, new) {
+					s.add(Changed, old, new)
+				}
+			} else if err := st.Field(f.num).Walk(s); err != nil {
+				return err
+			}
+			s.pop(n)
+		}
+		return nil
+	}
+}
+
+// fieldValue returns field f of st. The struct is copied to make it addressable, since reflect can't otherwise read
+// unexported fields.
+func fieldValue(st tw.Struct[*state], f *structField) any {
+	v := st.Value()
+	if !v.CanAddr() {
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		v = c
+	}
+	return reflect.NewAt(f.typ, unsafe.Add(v.Addr().UnsafePointer(), f.offset)).Elem().Interface()
+}
+
+func compileArray(reflect.Type) tw.WalkArrayFn[*state] {
+	return func(s *state, a tw.Array[*state]) error {
+		for i := 0; i < a.Len(); i++ {
+			n := s.pushIndex(i)
+			if err := a.Elem(i).Walk(s); err != nil {
+				return err
+			}
+			s.pop(n)
+		}
+		return nil
+	}
+}
+
+func (c *config) compileSlice(d *Differ) tw.CompileSliceFn[*state] {
+	return func(t reflect.Type) tw.WalkSliceFn[*state] {
+		return func(s *state, sl tw.Slice[*state]) error {
+			pair, _ := sl.Pair()
+			if sl.IsNil() != pair.IsNil() && sl.Len() == 0 && pair.Len() == 0 {
+				s.add(Changed, sl.Interface(), pair.Interface())
+				return nil
+			}
+			key := walkutil.Key{P: sl.Value().UnsafePointer(), Q: pair.Value().UnsafePointer(), Type: t}
+			if !s.seen.Add(key) {
+				return nil
+			}
+			var err error
+			if c.lcs {
+				diffAligned(s, d, sl, pair)
+			} else {
+				err = diffByIndex(s, sl, pair)
+			}
+			s.seen.Remove(key)
+			return err
+		}
+	}
+}
+
+func diffByIndex(s *state, sl, pair tw.Slice[*state]) error {
+	for i := 0; i < max(sl.Len(), pair.Len()); i++ {
+		n := s.pushIndex(i)
+		switch {
+		case i >= pair.Len():
+			s.add(Removed, sl.Elem(i).Interface(), nil)
+		case i >= sl.Len():
+			s.add(Added, nil, pair.Elem(i).Interface())
+		default:
+			if err := sl.Elem(i).Walk(s); err != nil {
+				return err
+			}
+		}
+		s.pop(n)
+	}
+	return nil
+}
+
+// diffAligned diffs the elements of sl and pair, aligned by their longest common subsequence.
+func diffAligned(s *state, d *Differ, sl, pair tw.Slice[*state]) {
+	old := make([]any, sl.Len())
+	for i := range old {
+		old[i] = sl.Elem(i).Interface()
+	}
+	new := make([]any, pair.Len())
+	for j := range new {
+		new[j] = pair.Elem(j).Interface()
+	}
+	// lengths[i][j] is the length of the longest common subsequence of old[i:] and new[j:], and equal[i][j] is whether
+	// old[i] and new[j] are equal.
+	lengths := make([][]int, len(old)+1)
+	equal := make([][]bool, len(old))
+	for i := range lengths {
+		lengths[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		equal[i] = make([]bool, len(new))
+		for j := len(new) - 1; j >= 0; j-- {
+			if equal[i][j] = twequal.Equal(old[i], new[j]); equal[i][j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	// Follow the subsequence, collecting the unaligned elements before each aligned pair, and diff them when the pair
+	// is reached.
+	var removed, added []int
+	flush := func() {
+		k := 0
+		for ; k < len(removed) && k < len(added); k++ {
+			n := s.pushIndex(removed[k])
+			s.diff(d, old[removed[k]], new[added[k]])
+			s.pop(n)
+		}
+		for _, i := range removed[k:] {
+			n := s.pushIndex(i)
+			s.add(Removed, old[i], nil)
+			s.pop(n)
+		}
+		for _, j := range added[k:] {
+			n := s.pushIndex(j)
+			s.add(Added, nil, new[j])
+			s.pop(n)
+		}
+		removed, added = removed[:0], added[:0]
+	}
+	for i, j := 0, 0; i < len(old) || j < len(new); {
+		switch {
+		case i < len(old) && j < len(new) && equal[i][j] && lengths[i][j] == lengths[i+1][j+1]+1:
+			flush()
+			i, j = i+1, j+1
+		case j == len(new) || i < len(old) && lengths[i+1][j] >= lengths[i][j+1]:
+			removed = append(removed, i)
+			i++
+		default:
+			added = append(added, j)
+			j++
+		}
+	}
+	flush()
+}
+
+func compilePtr(t reflect.Type) tw.WalkPtrFn[*state] {
+	return func(s *state, p tw.Ptr[*state]) error {
+		pair, _ := p.Pair()
+		if p.IsNil() || pair.IsNil() {
+			if p.IsNil() != pair.IsNil() {
+				s.add(Changed, p.Interface(), pair.Interface())
+			}
+			return nil
+		}
+		x, y := walkutil.PointerOf(p.Interface()), walkutil.PointerOf(pair.Interface())
+		if x == y {
+			return nil
+		}
+		key := walkutil.Key{P: x, Q: y, Type: t}
+		if !s.seen.Add(key) {
+			return nil
+		}
+		err := p.Walk(s)
+		s.seen.Remove(key)
+		return err
+	}
+}
+
+// mapItem is an entry in one or both maps being diffed, with its step in paths.
+type mapItem struct {
+	step  string
+	key   reflect.Value
+	entry tw.MapEntry[*state]
+	// added is set if the entry is only in the new map.
+	added bool
+}
+
+func compileMap(t reflect.Type) tw.WalkMapFn[*state] {
+	return func(s *state, m tw.Map[*state]) error {
+		pair, _ := m.Pair()
+		if m.IsNil() != pair.IsNil() && m.Len() == 0 && pair.Len() == 0 {
+			s.add(Changed, m.Interface(), pair.Interface())
+			return nil
+		}
+		x, y := walkutil.PointerOf(m.Interface()), walkutil.PointerOf(pair.Interface())
+		if x == y {
+			return nil
+		}
+		key := walkutil.Key{P: x, Q: y, Type: t}
+		if !s.seen.Add(key) {
+			return nil
+		}
+		err := diffEntries(s, m, pair)
+		s.seen.Remove(key)
+		return err
+	}
+}
+
+func diffEntries(s *state, m, pair tw.Map[*state]) error {
+	var items []mapItem
+	iter := m.Iter()
+	for iter.Next() {
+		entry := iter.Entry()
+		k := entry.Key().Interface()
+		items = append(items, mapItem{step: fmt.Sprintf("[%#v]", k), key: reflect.ValueOf(k), entry: entry})
+	}
+	old := m.Value()
+	iter = pair.Iter()
+	for iter.Next() {
+		entry := iter.Entry()
+		if k := entry.Key().Interface(); !old.MapIndex(reflect.ValueOf(k)).IsValid() {
+			items = append(items, mapItem{step: fmt.Sprintf("[%#v]", k), key: reflect.ValueOf(k), entry: entry, added: true})
+		}
+	}
+	slices.SortFunc(items, compareItems)
+
+	for _, item := range items {
+		n := s.push(item.step)
+		switch {
+		case item.added:
+			s.add(Added, nil, item.entry.Value().Interface())
+		case !item.entry.HasPair():
+			s.add(Removed, item.entry.Value().Interface(), nil)
+		default:
+			if err := item.entry.Value().Walk(s); err != nil {
+				return err
+			}
+		}
+		s.pop(n)
+	}
+	return nil
+}
+
+// compareItems orders map items by their keys, like fmt does when printing maps. Keys of different types, as in maps
+// with interface keys, are grouped by type. Keys of the same type are ordered by value where their kind has a natural
+// order, and otherwise by their steps, which only keeps the order stable.
+func compareItems(a, b mapItem) int {
+	x, y := a.key, b.key
+	if c := strings.Compare(typeName(x), typeName(y)); c != 0 {
+		return c
+	}
+	if x.IsValid() && x.Type() == y.Type() {
+		c := 0
+		switch x.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			c = cmp.Compare(x.Int(), y.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			c = cmp.Compare(x.Uint(), y.Uint())
+		case reflect.Float32, reflect.Float64:
+			c = cmp.Compare(x.Float(), y.Float())
+		case reflect.String:
+			c = strings.Compare(x.String(), y.String())
+		case reflect.Bool:
+			if x.Bool() != y.Bool() {
+				c = 1
+				if y.Bool() {
+					c = -1
+				}
+			}
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.step, b.step)
+}
+
+// typeName returns the name of the type of v, or "" if v is the zero Value, as it is for a nil interface key.
+func typeName(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	return v.Type().String()
+}
+
+func compileInterface(reflect.Type) tw.WalkInterfaceFn[*state] {
+	return func(s *state, i tw.Interface[*state]) error {
+		pair, _ := i.Pair()
+		x, y := i.Interface(), pair.Interface()
+		if reflect.TypeOf(x) != reflect.TypeOf(y) {
+			s.add(Changed, x, y)
+			return nil
+		}
+		if x == nil {
+			return nil
+		}
+		return i.Walk(s)
+	}
+}
@@ -0,0 +1,192 @@
+package twdiff_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zolstein/type-walk/twdiff"
+)
+
+type Address struct {
+	Port  int
+	Hosts []string
+}
+
+type Server struct {
+	*Address
+	Name     string
+	Routes   map[string][]int
+	Meta     any
+	Weights  [2]float64
+	Timeout  time.Duration
+	restarts int
+	ch       chan int
+}
+
+func changed(path string, old, new any) twdiff.Change {
+	return twdiff.Change{Path: path, Kind: twdiff.Changed, Old: old, New: new}
+}
+
+func added(path string, new any) twdiff.Change {
+	return twdiff.Change{Path: path, Kind: twdiff.Added, New: new}
+}
+
+func removed(path string, old any) twdiff.Change {
+	return twdiff.Change{Path: path, Kind: twdiff.Removed, Old: old}
+}
+
+func TestDiffPaths(t *testing.T) {
+	old := Server{
+		Address:  &Address{Port: 1, Hosts: []string{"x"}},
+		Name:     "r",
+		Routes:   map[string][]int{"a": {1, 2}},
+		Meta:     []any{1, Address{Port: 3}},
+		Weights:  [2]float64{5, 6},
+		Timeout:  time.Second,
+		restarts: 7,
+	}
+	new := Server{
+		Address:  &Address{Port: 2, Hosts: []string{"y"}},
+		Name:     "s",
+		Routes:   map[string][]int{"a": {1, 3}},
+		Meta:     []any{1, Address{Port: 4}},
+		Weights:  [2]float64{5, 7},
+		Timeout:  time.Minute,
+		restarts: 8,
+	}
+	// Embedded fields are named by their type, interfaces add no step, and named types are compared as a whole.
+	assert.Equal(t, []twdiff.Change{
+		changed(".Address.Port", 1, 2),
+		changed(".Address.Hosts[0]", "x", "y"),
+		changed(".Name", "r", "s"),
+		changed(`.Routes["a"][1]`, 2, 3),
+		changed(".Meta[1].Port", 3, 4),
+		changed(".Weights[1]", 6.0, 7.0),
+		changed(".Timeout", time.Second, time.Minute),
+		changed(".restarts", 7, 8),
+	}, twdiff.Diff(old, new))
+}
+
+func TestDiffKinds(t *testing.T) {
+	ch := make(chan int)
+	tests := []struct {
+		name     string
+		old, new any
+		expected []twdiff.Change
+	}{
+		{"root", 1, 2, []twdiff.Change{changed("", 1, 2)}},
+		{"nil", nil, 1, []twdiff.Change{changed("", nil, 1)}},
+		{"differentTypes", 1, "1", []twdiff.Change{changed("", 1, "1")}},
+		{"interfaceTypes", []any{1, "two"}, []any{1, 2.0}, []twdiff.Change{changed("[1]", "two", 2.0)}},
+		{"nilPtr", &Address{Port: 1}, (*Address)(nil), []twdiff.Change{changed("", &Address{Port: 1}, (*Address)(nil))}},
+		{"nilSlice", Address{}, Address{Hosts: []string{}}, []twdiff.Change{changed(".Hosts", []string(nil), []string{})}},
+		{"nilMap", map[int]bool{}, map[int]bool(nil), []twdiff.Change{changed("", map[int]bool{}, map[int]bool(nil))}},
+		{
+			"mapEntries",
+			map[string][]int{"a": {1}, "b": nil},
+			map[string][]int{"a": {1}, "c": {4}},
+			[]twdiff.Change{removed(`["b"]`, []int(nil)), added(`["c"]`, []int{4})},
+		},
+		{"sliceAdded", []any{1}, []any{1, nil}, []twdiff.Change{added("[1]", nil)}},
+		{"sliceRemoved", []int{1, 2, 3}, []int{1}, []twdiff.Change{removed("[1]", 2), removed("[2]", 3)}},
+		{
+			"sliceByIndex",
+			[]int{1, 2, 3},
+			[]int{0, 1, 2},
+			[]twdiff.Change{changed("[0]", 1, 0), changed("[1]", 2, 1), changed("[2]", 3, 2)},
+		},
+		// Channels can't be walked into, so values containing them are compared as a whole.
+		{"chanField", Server{ch: ch}, Server{}, []twdiff.Change{changed(".ch", ch, (chan int)(nil))}},
+		{"chanSlice", []chan int{ch}, []chan int{nil}, []twdiff.Change{changed("", []chan int{ch}, []chan int{nil})}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, twdiff.Diff(test.old, test.new))
+		})
+	}
+}
+
+func TestDiffMapOrder(t *testing.T) {
+	assert.Equal(t, []twdiff.Change{
+		changed("[-1]", "c", "z"),
+		changed("[2]", "a", "x"),
+		changed("[10]", "b", "y"),
+	}, twdiff.Diff(map[int]string{2: "a", 10: "b", -1: "c"}, map[int]string{2: "x", 10: "y", -1: "z"}))
+
+	// Keys of different types are grouped by type, then ordered by value.
+	assert.Equal(t, []twdiff.Change{
+		changed("[false]", 1, 2),
+		changed("[true]", 1, 2),
+		changed("[9.5]", 1, 2),
+		changed("[2]", 1, 2),
+		changed("[10]", 1, 2),
+		changed(`["a"]`, 1, 2),
+		changed(`["b"]`, 1, 2),
+	}, twdiff.Diff(
+		map[any]int{2: 1, 10: 1, 9.5: 1, "b": 1, "a": 1, true: 1, false: 1},
+		map[any]int{2: 2, 10: 2, 9.5: 2, "b": 2, "a": 2, true: 2, false: 2},
+	))
+}
+
+func TestDiffEmptyIffDeepEqual(t *testing.T) {
+	type Ring struct {
+		Value int
+		Next  *Ring
+	}
+	ring := func(values ...int) *Ring {
+		head := &Ring{Value: values[0]}
+		node := head
+		for _, v := range values[1:] {
+			node.Next = &Ring{Value: v}
+			node = node.Next
+		}
+		node.Next = head
+		return head
+	}
+	nan := math.NaN()
+	pairs := [][2]any{
+		{Server{Name: "a"}, Server{Name: "a"}},
+		{Server{Routes: map[string][]int{}}, Server{}},
+		{[]float64{nan}, []float64{nan}},
+		{map[string]any{"a": []any{1}}, map[string]any{"a": []any{1}}},
+		{ring(0, 1, 2), ring(0, 1, 2)},
+		{ring(0, 1, 2), ring(0, 5, 2)},
+	}
+	for _, pair := range pairs {
+		changes := twdiff.Diff(pair[0], pair[1])
+		assert.Equal(t, reflect.DeepEqual(pair[0], pair[1]), len(changes) == 0, "%v", changes)
+	}
+	assert.Equal(t, []twdiff.Change{changed(".Next.Value", 1, 5)}, twdiff.Diff(ring(0, 1, 2), ring(0, 5, 2)))
+}
+
+func TestDiffLCS(t *testing.T) {
+	d := twdiff.NewDiffer(twdiff.WithLCS)
+	changes := d.Diff([]int{1, 2, 3, 4}, []int{0, 1, 2, 3})
+	assert.Equal(t, []twdiff.Change{added("[0]", 0), removed("[3]", 4)}, changes)
+
+	// Unaligned elements between aligned ones are diffed with each other.
+	changes = d.Diff(
+		[]Address{{Port: 1}, {Port: 2, Hosts: []string{"x"}}, {Port: 3}},
+		[]Address{{Port: 1}, {Port: 2, Hosts: []string{"y"}}, {Port: 5}, {Port: 3}},
+	)
+	assert.Equal(t, []twdiff.Change{changed("[1].Hosts[0]", "x", "y"), added("[2]", Address{Port: 5})}, changes)
+
+	assert.Empty(t, d.Diff([]float64{1, 2}, []float64{1, 2}))
+	assert.Len(t, d.Diff([]float64{math.NaN()}, []float64{math.NaN()}), 1)
+}
+
+func TestFormat(t *testing.T) {
+	changes := twdiff.Diff(
+		Server{Name: "r", Routes: map[string][]int{"a": {1, 2}}, Meta: &Address{Port: 4}},
+		Server{Name: "s", Routes: map[string][]int{"c": {1}}, Meta: (*Address)(nil)},
+	)
+	assert.Equal(t, `.Name: "r" -> "s"
+.Routes["a"]: removed []int{1, 2}
+.Routes["c"]: added []int{1}
+.Meta: &twdiff_test.Address{Port:4, Hosts:[]string(nil)} -> (*twdiff_test.Address)(nil)
+`, twdiff.Format(changes))
+	assert.Equal(t, "(root): 1 -> 2", twdiff.Diff(1, 2)[0].String())
+}
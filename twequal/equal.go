@@ -0,0 +1,397 @@
+// Package twequal compares values for deep equality, like reflect.DeepEqual, using type-walk Walkers.
+//
+// By default the rules are reflect.DeepEqual's, including for cyclic values. Options relax them, and RegisterEqualFn
+// overrides them for particular types. Unlike reflect.DeepEqual, slices sharing a backing array are still compared
+// element by element, and values the Walker can't compile are handed to reflect.DeepEqual, which ignores options.
+package twequal
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+)
+
+// errNotEqual stops a walk at the first difference.
+var errNotEqual = errors.New("not equal")
+
+var byteType = reflect.TypeOf(byte(0))
+
+// Register stores functions that compare values of particular types, which take the place of the default comparison.
+// Functions must be registered before the Register is used to create a Comparer.
+type Register struct {
+	r *tw.Register[*state]
+}
+
+// NewRegister returns a new, empty Register.
+func NewRegister() *Register {
+	return &Register{r: tw.NewRegister[*state]()}
+}
+
+// RegisterEqualFn registers fn to compare values of type T, including T in struct fields, elements and interfaces.
+// fn is called with the two values being compared, and reports whether they are equal.
+func RegisterEqualFn[T any](r *Register, fn func(a, b T) bool) {
+	tw.RegisterTypeFn(r.r, func(s *state, a tw.Arg[T]) error {
+		pair, _ := a.Pair()
+		if !fn(a.Get(), pair.Get()) {
+			return errNotEqual
+		}
+		return nil
+	})
+}
+
+type config struct {
+	ignoreTag   string
+	equateEmpty bool
+	epsilon     float64
+}
+
+// Option configures a Comparer.
+type Option func(*config)
+
+// WithIgnoreTag ignores struct fields whose tag for key is "-", e.g. `json:"-"` for WithIgnoreTag("json").
+func WithIgnoreTag(key string) Option {
+	return func(c *config) {
+		c.ignoreTag = key
+	}
+}
+
+// WithEquateEmpty treats nil and empty slices as equal, and nil and empty maps as equal.
+var WithEquateEmpty Option = func(c *config) {
+	c.equateEmpty = true
+}
+
+// WithFloatEpsilon treats floats as equal if they differ by at most epsilon. The real and imaginary parts of complex
+// numbers are compared separately. NaN is still not equal to anything.
+func WithFloatEpsilon(epsilon float64) Option {
+	return func(c *config) {
+		c.epsilon = epsilon
+	}
+}
+
+// A Comparer compares values for equality. It is safe for concurrent use.
+type Comparer struct {
+	walker *tw.Walker[*state]
+}
+
+// NewComparer returns a Comparer which uses the functions in r, if r is not nil, and is configured by opts.
+func NewComparer(r *Register, opts ...Option) *Comparer {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	var register *tw.Register[*state]
+	if r != nil {
+		register = r.r.Clone()
+	} else {
+		register = tw.NewRegister[*state]()
+	}
+	cfg.register(register)
+	return &Comparer{walker: tw.NewWalker(register, tw.WithThreadSafe)}
+}
+
+var defaultComparer = NewComparer(nil)
+
+// Equal reports whether a and b are deeply equal under opts. Equal compiles new comparisons for every call with
+// options, so a Comparer should be used instead to compare many values with the same options.
+func Equal(a, b any, opts ...Option) bool {
+	if len(opts) == 0 {
+		return defaultComparer.Equal(a, b)
+	}
+	return NewComparer(nil, opts...).Equal(a, b)
+}
+
+// Equal reports whether a and b are deeply equal. Values of different types are never equal.
+func (c *Comparer) Equal(a, b any) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	s := newState()
+	defer s.release()
+	err := c.walker.WalkPair(s, a, b)
+	if err == errNotEqual {
+		return false
+	}
+	if err != nil {
+		// The values contain a kind the Walker can't compare.
+		return reflect.DeepEqual(a, b)
+	}
+	return true
+}
+
+type state struct {
+	// cycles holds the pairs of pointers, maps and slices being compared. A pair which is reached again while it's
+	// being compared is treated as equal, as far as it has been compared.
+	cycles walkutil.Cycles
+}
+
+var statePool walkutil.Pool[state]
+
+func newState() *state {
+	return statePool.Get()
+}
+
+func (s *state) release() {
+	s.cycles.Reset()
+	statePool.Put(s)
+}
+
+func (c *config) register(r *tw.Register[*state]) {
+	tw.RegisterCompileBoolFn(r, compileComparable[bool])
+	tw.RegisterCompileIntFn(r, compileComparable[int])
+	tw.RegisterCompileInt8Fn(r, compileComparable[int8])
+	tw.RegisterCompileInt16Fn(r, compileComparable[int16])
+	tw.RegisterCompileInt32Fn(r, compileComparable[int32])
+	tw.RegisterCompileInt64Fn(r, compileComparable[int64])
+	tw.RegisterCompileUintFn(r, compileComparable[uint])
+	tw.RegisterCompileUint8Fn(r, compileComparable[uint8])
+	tw.RegisterCompileUint16Fn(r, compileComparable[uint16])
+	tw.RegisterCompileUint32Fn(r, compileComparable[uint32])
+	tw.RegisterCompileUint64Fn(r, compileComparable[uint64])
+	tw.RegisterCompileUintptrFn(r, compileComparable[uintptr])
+	tw.RegisterCompileStringFn(r, compileComparable[string])
+	tw.RegisterCompileUnsafePointerFn(r, compileComparable[unsafe.Pointer])
+	if c.epsilon == 0 {
+		tw.RegisterCompileFloat32Fn(r, compileComparable[float32])
+		tw.RegisterCompileFloat64Fn(r, compileComparable[float64])
+		tw.RegisterCompileComplex64Fn(r, compileComparable[complex64])
+		tw.RegisterCompileComplex128Fn(r, compileComparable[complex128])
+	} else {
+		tw.RegisterCompileFloat32Fn(r, compileFloat[float32](c.epsilon))
+		tw.RegisterCompileFloat64Fn(r, compileFloat[float64](c.epsilon))
+		tw.RegisterCompileComplex64Fn(r, compileComplex[complex64](c.epsilon))
+		tw.RegisterCompileComplex128Fn(r, compileComplex[complex128](c.epsilon))
+	}
+	tw.RegisterCompileStructFn(r, c.compileStruct(r))
+	tw.RegisterCompileArrayFn(r, compileArray)
+	tw.RegisterCompileSliceFn(r, c.compileSlice(r))
+	tw.RegisterCompilePtrFn(r, compilePtr)
+	tw.RegisterCompileMapFn(r, c.compileMap)
+	tw.RegisterCompileInterfaceFn(r, compileInterface)
+}
+
+// Containers only walk children present in both values, so Pair always succeeds.
+
+func compileComparable[T comparable](reflect.Type) tw.WalkFn[*state, T] {
+	return func(s *state, a tw.Arg[T]) error {
+		pair, _ := a.Pair()
+		if a.Get() != pair.Get() {
+			return errNotEqual
+		}
+		return nil
+	}
+}
+
+func compileFloat[T float32 | float64](epsilon float64) tw.CompileFn[*state, T] {
+	return func(reflect.Type) tw.WalkFn[*state, T] {
+		return func(s *state, a tw.Arg[T]) error {
+			pair, _ := a.Pair()
+			if !floatEqual(float64(a.Get()), float64(pair.Get()), epsilon) {
+				return errNotEqual
+			}
+			return nil
+		}
+	}
+}
+
+func compileComplex[T complex64 | complex128](epsilon float64) tw.CompileFn[*state, T] {
+	return func(reflect.Type) tw.WalkFn[*state, T] {
+		return func(s *state, a tw.Arg[T]) error {
+			pair, _ := a.Pair()
+			x, y := complex128(a.Get()), complex128(pair.Get())
+			if !floatEqual(real(x), real(y), epsilon) || !floatEqual(imag(x), imag(y), epsilon) {
+				return errNotEqual
+			}
+			return nil
+		}
+	}
+}
+
+func floatEqual(x, y, epsilon float64) bool {
+	// Infinities of the same sign are equal, though their difference is NaN.
+	return x == y || math.Abs(x-y) <= epsilon
+}
+
+// structField is a field compared by a struct comparison.
+type structField struct {
+	// num is the index of the field in the StructFieldRegister.
+	num int
+	// deep is set if the field's type contains a channel or function, which the Walker can't compile, so the field is
+	// compared with reflect.DeepEqual.
+	deep   bool
+	typ    reflect.Type
+	offset uintptr
+}
+
+func (c *config) compileStruct(r *tw.Register[*state]) tw.CompileStructFn[*state] {
+	return func(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*state] {
+		var fields []structField
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if c.ignoreTag != "" && f.Tag.Get(c.ignoreTag) == "-" {
+				continue
+			}
+			sf := structField{typ: f.Type, offset: f.Offset}
+			if sf.deep = walkutil.Unsupported(f.Type, walkutil.Registered(r)) != nil; !sf.deep {
+				sf.num = sfr.RegisterField(i)
+			}
+			fields = append(fields, sf)
+		}
+
+		return func(s *state, st tw.Struct[*state]) error {
+			for i := range fields {
+				f := &fields[i]
+				if f.deep {
+					if !deepEqualField(st, f) {
+						return errNotEqual
+					}
+					continue
+				}
+				if err := st.Field(f.num).Walk(s); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// deepEqualField compares field f of st and its pair with reflect.DeepEqual. The structs are copied to make them
+// addressable, since reflect can't otherwise read unexported fields.
+func deepEqualField(st tw.Struct[*state], f *structField) bool {
+	pair, _ := st.Pair()
+	x, y := addressable(st.Value()), addressable(pair.Value())
+	return reflect.DeepEqual(
+		reflect.NewAt(f.typ, unsafe.Add(x, f.offset)).Elem().Interface(),
+		reflect.NewAt(f.typ, unsafe.Add(y, f.offset)).Elem().Interface(),
+	)
+}
+
+func addressable(v reflect.Value) unsafe.Pointer {
+	if !v.CanAddr() {
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		v = c
+	}
+	return v.Addr().UnsafePointer()
+}
+
+func compileArray(reflect.Type) tw.WalkArrayFn[*state] {
+	return func(s *state, a tw.Array[*state]) error {
+		return a.WalkAll(s)
+	}
+}
+
+func (c *config) compileSlice(r *tw.Register[*state]) tw.CompileSliceFn[*state] {
+	return func(t reflect.Type) tw.WalkSliceFn[*state] {
+		bytesEqual := t.Elem() == byteType && !walkutil.Registered(r)(t.Elem())
+		return func(s *state, sl tw.Slice[*state]) error {
+			pair, _ := sl.Pair()
+			if sl.Len() != pair.Len() || !c.equateEmpty && sl.IsNil() != pair.IsNil() {
+				return errNotEqual
+			}
+			if bytesEqual {
+				x, _ := tw.SliceAs[byte](sl)
+				y, _ := tw.SliceAs[byte](pair)
+				if !bytes.Equal(x, y) {
+					return errNotEqual
+				}
+				return nil
+			}
+			key, ok := s.cycles.Enter(func() walkutil.Key {
+				return walkutil.Key{P: sl.Value().UnsafePointer(), Q: pair.Value().UnsafePointer(), Type: t}
+			})
+			if !ok {
+				return nil
+			}
+			err := sl.WalkAll(s)
+			s.cycles.Leave(key)
+			return err
+		}
+	}
+}
+
+func compilePtr(t reflect.Type) tw.WalkPtrFn[*state] {
+	return func(s *state, p tw.Ptr[*state]) error {
+		pair, _ := p.Pair()
+		if p.IsNil() || pair.IsNil() {
+			if p.IsNil() != pair.IsNil() {
+				return errNotEqual
+			}
+			return nil
+		}
+		x, y := walkutil.PointerOf(p.Interface()), walkutil.PointerOf(pair.Interface())
+		if x == y {
+			return nil
+		}
+		key, ok := s.cycles.Enter(func() walkutil.Key {
+			return walkutil.Key{P: x, Q: y, Type: t}
+		})
+		if !ok {
+			return nil
+		}
+		err := p.Walk(s)
+		s.cycles.Leave(key)
+		return err
+	}
+}
+
+func (c *config) compileMap(t reflect.Type) tw.WalkMapFn[*state] {
+	return func(s *state, m tw.Map[*state]) error {
+		pair, _ := m.Pair()
+		if m.Len() != pair.Len() || !c.equateEmpty && m.IsNil() != pair.IsNil() {
+			return errNotEqual
+		}
+		x, y := walkutil.PointerOf(m.Interface()), walkutil.PointerOf(pair.Interface())
+		if x == y {
+			return nil
+		}
+		key, ok := s.cycles.Enter(func() walkutil.Key {
+			return walkutil.Key{P: x, Q: y, Type: t}
+		})
+		if !ok {
+			return nil
+		}
+		err := compareEntries(s, m)
+		s.cycles.Leave(key)
+		return err
+	}
+}
+
+func compareEntries(s *state, m tw.Map[*state]) error {
+	iter := m.Iter()
+	for iter.Next() {
+		entry := iter.Entry()
+		if !entry.HasPair() {
+			return errNotEqual
+		}
+		if err := entry.Value().Walk(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileInterface(reflect.Type) tw.WalkInterfaceFn[*state] {
+	return func(s *state, i tw.Interface[*state]) error {
+		pair, _ := i.Pair()
+		x, y := i.Interface(), pair.Interface()
+		if x == nil || y == nil {
+			if (x == nil) != (y == nil) {
+				return errNotEqual
+			}
+			return nil
+		}
+		if reflect.TypeOf(x) != reflect.TypeOf(y) {
+			return errNotEqual
+		}
+		return i.Walk(s)
+	}
+}
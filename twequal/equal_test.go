@@ -0,0 +1,231 @@
+package twequal_test
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zolstein/type-walk/twequal"
+)
+
+type Limits struct {
+	Max   int
+	Paths []string
+}
+
+type Config struct {
+	*Limits
+	Name     string
+	Hosts    map[string][]int
+	Extra    any
+	Checksum []byte
+	revision int
+	Cache    string `equal:"-"`
+}
+
+type Handle struct {
+	Name string
+	ch   chan int
+	Fn   func()
+}
+
+type Ring struct {
+	Value int
+	Next  *Ring
+}
+
+// newRing returns a cyclic list of the values.
+func newRing(values ...int) *Ring {
+	head := &Ring{Value: values[0]}
+	node := head
+	for _, v := range values[1:] {
+		node.Next = &Ring{Value: v}
+		node = node.Next
+	}
+	node.Next = head
+	return head
+}
+
+func TestEqualMatchesDeepEqual(t *testing.T) {
+	nan := math.NaN()
+	shared := &Limits{Max: 1}
+	ch := make(chan int)
+	config := func(fn func(*Config)) Config {
+		c := Config{
+			Limits:   &Limits{Max: 1, Paths: []string{"/"}},
+			Name:     "c",
+			Hosts:    map[string][]int{"a": {1, 2}, "b": nil},
+			Extra:    []any{1, "two", Limits{Max: 3}},
+			Checksum: []byte("sum"),
+			revision: 7,
+		}
+		fn(&c)
+		return c
+	}
+	unchanged := func(*Config) {}
+	selfMap := func() map[string]any {
+		m := map[string]any{"a": 1}
+		m["self"] = m
+		return m
+	}
+	selfSlice := func() []any {
+		s := []any{1, nil}
+		s[1] = s
+		return s
+	}
+
+	tests := []struct {
+		name string
+		a, b any
+	}{
+		{"nil", nil, nil},
+		{"nilAndValue", nil, 1},
+		{"differentTypes", 1, int64(1)},
+		{"int", 1, 1},
+		{"intDiff", 1, 2},
+		{"stringDiff", "a", "b"},
+		{"nan", nan, nan},
+		{"negativeZero", math.Copysign(0, -1), 0.0},
+		{"complex", complex(1, 2), complex(1, 2)},
+		{"config", config(unchanged), config(unchanged)},
+		{"configPtr", &Config{Name: "a"}, &Config{Name: "a"}},
+		{"embeddedNil", Config{}, Config{Limits: &Limits{}}},
+		{"embeddedDiff", config(unchanged), config(func(c *Config) { c.Limits.Max = 2 })},
+		{"mapValueDiff", config(unchanged), config(func(c *Config) { c.Hosts["a"][1] = 3 })},
+		{"mapKeyDiff", config(unchanged), config(func(c *Config) { delete(c.Hosts, "b"); c.Hosts["c"] = nil })},
+		{"mapNilEmpty", config(unchanged), config(func(c *Config) { c.Hosts["b"] = []int{} })},
+		{"anyElemDiff", config(unchanged), config(func(c *Config) { c.Extra = []any{1, "two", Limits{Max: 4}} })},
+		{"anyTypeDiff", config(unchanged), config(func(c *Config) { c.Extra = []any{1, "two", &Limits{Max: 3}} })},
+		{"anyLenDiff", config(unchanged), config(func(c *Config) { c.Extra = []any{1, "two"} })},
+		{"bytesNil", config(unchanged), config(func(c *Config) { c.Checksum = nil })},
+		{"privateDiff", config(unchanged), config(func(c *Config) { c.revision = 8 })},
+		{"ignoreTagUnused", config(unchanged), config(func(c *Config) { c.Cache = "s" })},
+		{"sameChan", Handle{ch: ch}, Handle{ch: ch}},
+		{"chanDiff", Handle{ch: ch}, Handle{ch: make(chan int)}},
+		{"nilFuncs", Handle{}, Handle{}},
+		{"funcNonNil", Handle{Fn: func() {}}, Handle{Fn: func() {}}},
+		{"samePtr", shared, shared},
+		{"nilAndEmptySlice", []int(nil), []int{}},
+		{"nilAndEmptyMap", map[string]int(nil), map[string]int{}},
+		{"nanMapValue", map[int]float64{1: nan}, map[int]float64{1: nan}},
+		{"namedTypes", []time.Duration{1, 2}, []time.Duration{1, 2}},
+		{"chanSliceDiff", []chan int{ch}, []chan int{make(chan int)}},
+		{"ring", newRing(0, 1, 2), newRing(0, 1, 2)},
+		{"ringLenDiff", newRing(0, 1, 2), newRing(0, 1, 2, 0, 1, 2)},
+		{"ringValueDiff", newRing(0, 1, 2), newRing(0, 1, 5)},
+		{"selfMap", selfMap(), selfMap()},
+		{"selfSlice", selfSlice(), selfSlice()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, reflect.DeepEqual(test.a, test.b), twequal.Equal(test.a, test.b))
+			assert.Equal(t, reflect.DeepEqual(test.b, test.a), twequal.Equal(test.b, test.a))
+		})
+	}
+}
+
+func TestEqualDiffersFromDeepEqual(t *testing.T) {
+	// reflect.DeepEqual treats slices sharing a backing array as equal without comparing their elements.
+	nans := []float64{math.NaN()}
+	assert.True(t, reflect.DeepEqual(nans, nans))
+	assert.False(t, twequal.Equal(nans, nans))
+
+	// Values the Walker can't compile are compared with reflect.DeepEqual, so options don't apply to them.
+	c := twequal.NewComparer(nil, twequal.WithEquateEmpty)
+	assert.False(t, c.Equal(map[string]chan int{}, map[string]chan int(nil)))
+	assert.True(t, c.Equal(map[string][]int{}, map[string][]int(nil)))
+}
+
+func TestEqualOptions(t *testing.T) {
+	t.Run("ignoreTag", func(t *testing.T) {
+		a, b := Config{Name: "a"}, Config{Name: "a", Cache: "s"}
+		assert.False(t, twequal.Equal(a, b))
+		assert.True(t, twequal.Equal(a, b, twequal.WithIgnoreTag("equal")))
+		b.Name = "s"
+		assert.False(t, twequal.Equal(a, b, twequal.WithIgnoreTag("equal")))
+	})
+
+	t.Run("equateEmpty", func(t *testing.T) {
+		c := twequal.NewComparer(nil, twequal.WithEquateEmpty)
+		assert.True(t, c.Equal([]int(nil), []int{}))
+		assert.True(t, c.Equal(map[string]int{}, map[string]int(nil)))
+		assert.True(t, c.Equal(Limits{}, Limits{Paths: []string{}}))
+		assert.False(t, c.Equal([]int(nil), []int{0}))
+		assert.False(t, c.Equal(map[string]int(nil), map[string]int{"a": 0}))
+	})
+
+	t.Run("floatEpsilon", func(t *testing.T) {
+		c := twequal.NewComparer(nil, twequal.WithFloatEpsilon(0.01))
+		assert.True(t, c.Equal(1.0, 1.005))
+		assert.True(t, c.Equal(float32(1), float32(0.995)))
+		assert.True(t, c.Equal([2]float64{1, 2}, [2]float64{1.001, 1.999}))
+		assert.True(t, c.Equal(complex(1, 1), complex(1.005, 0.995)))
+		assert.True(t, c.Equal(math.Inf(1), math.Inf(1)))
+		assert.False(t, c.Equal(math.Inf(1), math.Inf(-1)))
+		assert.False(t, c.Equal(1.0, 1.02))
+		assert.False(t, c.Equal(complex(1, 1), complex(1, 1.02)))
+		assert.False(t, c.Equal(math.NaN(), math.NaN()))
+	})
+}
+
+func TestRegisterEqualFn(t *testing.T) {
+	r := twequal.NewRegister()
+	twequal.RegisterEqualFn(r, func(a, b time.Time) bool {
+		return a.Equal(b)
+	})
+	twequal.RegisterEqualFn(r, func(a, b string) bool {
+		return strings.EqualFold(a, b)
+	})
+	c := twequal.NewComparer(r)
+
+	now := time.Now()
+	type Event struct {
+		Name string
+		At   time.Time
+	}
+	a := []Event{{"start", now}}
+	b := []Event{{"START", now.In(time.FixedZone("x", 3600))}}
+	assert.False(t, reflect.DeepEqual(a, b))
+	assert.True(t, c.Equal(a, b))
+	assert.True(t, c.Equal(map[string]any{"a": now}, map[string]any{"a": now.UTC()}))
+	assert.False(t, c.Equal(a, []Event{{"start", now.Add(time.Second)}}))
+	// Functions are not used by comparers created without the register.
+	assert.False(t, twequal.NewComparer(nil).Equal(a, b))
+}
+
+func BenchmarkEqual(b *testing.B) {
+	newConfig := func() Config {
+		return Config{
+			Limits: &Limits{Max: 1, Paths: []string{"/"}},
+			Name:   "c",
+			Hosts:  map[string][]int{"a": {1, 2}, "b": nil},
+			Extra:  []any{1, "two", Limits{Max: 3}},
+		}
+	}
+	values := []struct {
+		name string
+		a, b any
+	}{
+		{"config", newConfig(), newConfig()},
+		{"configs", []Config{newConfig(), newConfig(), newConfig()}, []Config{newConfig(), newConfig(), newConfig()}},
+		{"map", map[string]Limits{"a": {1, []string{"x"}}}, map[string]Limits{"a": {1, []string{"x"}}}},
+	}
+	for _, v := range values {
+		b.Run(fmt.Sprintf("%s/reflect", v.name), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				reflect.DeepEqual(v.a, v.b)
+			}
+		})
+		b.Run(fmt.Sprintf("%s/twequal", v.name), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				twequal.Equal(v.a, v.b)
+			}
+		})
+	}
+}
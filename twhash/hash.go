@@ -0,0 +1,389 @@
+// Package twhash hashes values deeply, using type-walk Walkers.
+//
+// A value is hashed by writing a canonical encoding of it to a hash.Hash64, FNV-1a by default.
+//
+// The encoding is designed so that values which are equal have equal hashes. Specifically, if twequal.Equal(a, b)
+// reports that a and b are equal, and neither is cyclic, then Hash(a) == Hash(b). The same holds for a Hasher created
+// with WithIgnoreTag(key) and a twequal.Comparer created with twequal.WithIgnoreTag(key), with or without
+// twequal.WithEquateEmpty. It does not hold with twequal.WithFloatEpsilon, or for functions registered with
+// twequal.RegisterEqualFn. The converse does not hold: different values may have equal hashes.
+//
+// The encoding is:
+//   - Booleans are a byte, 0 or 1.
+//   - Integers are 8 bytes, little-endian, and unsafe.Pointers are their address as an integer.
+//   - Floats are the 8 bytes of their value as a float64, with -0 encoded as 0 and every NaN encoded the same.
+//     Complex numbers are their real and then imaginary parts.
+//   - Strings are their length as an integer, followed by their bytes.
+//   - Structs are their fields, in order, except fields ignored by WithIgnoreTag and fields whose types contain
+//     channels or functions other than in struct fields or interfaces, which are skipped.
+//   - Arrays are their elements, in order. Slices are their length, followed by their elements. A nil slice is encoded
+//     the same as an empty one.
+//   - Pointers are a byte, 0 if the pointer is nil, otherwise 1 followed by the value pointed to.
+//   - Maps are their length, followed by the sum of the hashes of each entry's key and value, so that the order of
+//     entries doesn't matter. A nil map is encoded the same as an empty one.
+//   - Interfaces are a byte, 0 if the interface is nil, otherwise 1 followed by the name of the concrete type as a
+//     string and the concrete value. Values of types containing channels or functions are only encoded by their type.
+//   - A pointer, slice or map which is reached again while encoding itself, because the value is cyclic, is a byte, 2.
+//
+// The encoding is stable between runs of a program, so hashes can be stored, as long as the types of the values and
+// the hash function are unchanged.
+package twhash
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+)
+
+// cycleMarker encodes a pointer, slice or map which is reached again while encoding itself.
+const cycleMarker = 2
+
+type config struct {
+	newHash   func() hash.Hash64
+	ignoreTag string
+}
+
+// Option configures a Hasher.
+type Option func(*config)
+
+// WithHashFunc makes a Hasher hash values with hash functions created by newHash, instead of FNV-1a.
+func WithHashFunc(newHash func() hash.Hash64) Option {
+	return func(c *config) {
+		c.newHash = newHash
+	}
+}
+
+// WithIgnoreTag ignores struct fields whose tag for key is "-", e.g. `json:"-"` for WithIgnoreTag("json").
+func WithIgnoreTag(key string) Option {
+	return func(c *config) {
+		c.ignoreTag = key
+	}
+}
+
+// A Hasher hashes values. It is safe for concurrent use.
+type Hasher struct {
+	cfg    config
+	walker *tw.Walker[*state]
+	states walkutil.Pool[state]
+}
+
+// NewHasher returns a Hasher configured by opts.
+func NewHasher(opts ...Option) *Hasher {
+	cfg := config{newHash: fnv.New64a}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Hasher{cfg: cfg, walker: tw.NewWalker(cfg.newRegister(), tw.WithThreadSafe)}
+}
+
+var defaultHasher = NewHasher()
+
+// Hash returns the hash of v under opts. Hash compiles new functions for every call with options, so a Hasher should
+// be used instead to hash many values with the same options.
+func Hash(v any, opts ...Option) uint64 {
+	if len(opts) == 0 {
+		return defaultHasher.Hash(v)
+	}
+	return NewHasher(opts...).Hash(v)
+}
+
+// Hash returns the hash of v.
+func (h *Hasher) Hash(v any) uint64 {
+	s := h.newState()
+	defer h.release(s)
+	s.encode(h, v)
+	s.sub.Reset()
+	s.sub.Write(s.buf)
+	return s.sub.Sum64()
+}
+
+// Write writes the encoding of v to hh. It can be used to combine the hash of v with other data, or to hash v with a
+// different hash function than the Hasher's.
+func (h *Hasher) Write(hh hash.Hash64, v any) {
+	s := h.newState()
+	defer h.release(s)
+	s.encode(h, v)
+	hh.Write(s.buf)
+}
+
+type state struct {
+	buf []byte
+	// sub hashes map entries, and the encoding for Hash.
+	sub    hash.Hash64
+	cycles walkutil.Cycles
+}
+
+func (h *Hasher) newState() *state {
+	s := h.states.Get()
+	if s.sub == nil {
+		s.sub = h.cfg.newHash()
+	}
+	return s
+}
+
+func (h *Hasher) release(s *state) {
+	s.buf = s.buf[:0]
+	s.cycles.Reset()
+	h.states.Put(s)
+}
+
+func (s *state) encode(h *Hasher, v any) {
+	if v == nil {
+		return
+	}
+	if err := h.walker.Walk(s, v); err != nil {
+		// The Walker can't compile a function for v's type, so hash only the type.
+		s.writeString(reflect.TypeOf(v).String())
+	}
+}
+
+func (s *state) writeByte(b byte) {
+	s.buf = append(s.buf, b)
+}
+
+func (s *state) writeUint(u uint64) {
+	s.buf = binary.LittleEndian.AppendUint64(s.buf, u)
+}
+
+func (s *state) writeFloat(f float64) {
+	switch {
+	case f == 0:
+		// -0 == 0, so they must hash the same.
+		f = 0
+	case math.IsNaN(f):
+		f = math.NaN()
+	}
+	s.writeUint(math.Float64bits(f))
+}
+
+func (s *state) writeString(str string) {
+	s.writeUint(uint64(len(str)))
+	s.buf = append(s.buf, str...)
+}
+
+// enter records that the value identified by key is being encoded, once the nesting is deep enough that it may be
+// cyclic. It returns false, after writing cycleMarker, if the value is already being encoded. key is only called when
+// checking for cycles.
+func (s *state) enter(key func() walkutil.Key) (walkutil.Key, bool) {
+	k, ok := s.cycles.Enter(key)
+	if !ok {
+		s.writeByte(cycleMarker)
+	}
+	return k, ok
+}
+
+func (s *state) leave(key walkutil.Key) {
+	s.cycles.Leave(key)
+}
+
+func (c *config) newRegister() *tw.Register[*state] {
+	r := tw.NewRegister[*state]()
+	tw.RegisterCompileBoolFn(r, compileBool)
+	tw.RegisterCompileIntFn(r, compileInt[int])
+	tw.RegisterCompileInt8Fn(r, compileInt[int8])
+	tw.RegisterCompileInt16Fn(r, compileInt[int16])
+	tw.RegisterCompileInt32Fn(r, compileInt[int32])
+	tw.RegisterCompileInt64Fn(r, compileInt[int64])
+	tw.RegisterCompileUintFn(r, compileUint[uint])
+	tw.RegisterCompileUint8Fn(r, compileUint[uint8])
+	tw.RegisterCompileUint16Fn(r, compileUint[uint16])
+	tw.RegisterCompileUint32Fn(r, compileUint[uint32])
+	tw.RegisterCompileUint64Fn(r, compileUint[uint64])
+	tw.RegisterCompileUintptrFn(r, compileUint[uintptr])
+	tw.RegisterCompileFloat32Fn(r, compileFloat[float32])
+	tw.RegisterCompileFloat64Fn(r, compileFloat[float64])
+	tw.RegisterCompileComplex64Fn(r, compileComplex[complex64])
+	tw.RegisterCompileComplex128Fn(r, compileComplex[complex128])
+	tw.RegisterCompileStringFn(r, compileString)
+	tw.RegisterCompileUnsafePointerFn(r, compileUnsafePointer)
+	tw.RegisterCompileStructFn(r, c.compileStruct)
+	tw.RegisterCompileArrayFn(r, compileArray)
+	tw.RegisterCompileSliceFn(r, compileSlice)
+	tw.RegisterCompilePtrFn(r, compilePtr)
+	tw.RegisterCompileMapFn(r, compileMap)
+	tw.RegisterCompileInterfaceFn(r, compileInterface)
+	return r
+}
+
+func compileBool(reflect.Type) tw.WalkFn[*state, bool] {
+	return func(s *state, b tw.Bool) error {
+		if b.Get() {
+			s.writeByte(1)
+		} else {
+			s.writeByte(0)
+		}
+		return nil
+	}
+}
+
+func compileInt[T int | int8 | int16 | int32 | int64](reflect.Type) tw.WalkFn[*state, T] {
+	return func(s *state, i tw.Arg[T]) error {
+		s.writeUint(uint64(i.Get()))
+		return nil
+	}
+}
+
+func compileUint[T uint | uint8 | uint16 | uint32 | uint64 | uintptr](reflect.Type) tw.WalkFn[*state, T] {
+	return func(s *state, u tw.Arg[T]) error {
+		s.writeUint(uint64(u.Get()))
+		return nil
+	}
+}
+
+func compileFloat[T float32 | float64](reflect.Type) tw.WalkFn[*state, T] {
+	return func(s *state, f tw.Arg[T]) error {
+		s.writeFloat(float64(f.Get()))
+		return nil
+	}
+}
+
+func compileComplex[T complex64 | complex128](reflect.Type) tw.WalkFn[*state, T] {
+	return func(s *state, c tw.Arg[T]) error {
+		v := complex128(c.Get())
+		s.writeFloat(real(v))
+		s.writeFloat(imag(v))
+		return nil
+	}
+}
+
+func compileString(reflect.Type) tw.WalkFn[*state, string] {
+	return func(s *state, str tw.String) error {
+		s.writeString(str.Get())
+		return nil
+	}
+}
+
+func compileUnsafePointer(reflect.Type) tw.WalkFn[*state, unsafe.Pointer] {
+	return func(s *state, p tw.UnsafePointer) error {
+		s.writeUint(uint64(uintptr(p.Get())))
+		return nil
+	}
+}
+
+func (c *config) compileStruct(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*state] {
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if c.ignoreTag != "" && f.Tag.Get(c.ignoreTag) == "-" || walkutil.Unsupported(f.Type, nil) != nil {
+			continue
+		}
+		fields = append(fields, sfr.RegisterField(i))
+	}
+
+	return func(s *state, st tw.Struct[*state]) error {
+		for _, num := range fields {
+			if err := st.Field(num).Walk(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// unsupportedTypes caches whether the concrete types of interfaces are unsupported, since they're checked for every
+// value.
+var unsupportedTypes = walkutil.NewUnsupportedCache(nil)
+
+func compileArray(reflect.Type) tw.WalkArrayFn[*state] {
+	return func(s *state, a tw.Array[*state]) error {
+		return a.WalkAll(s)
+	}
+}
+
+func compileSlice(t reflect.Type) tw.WalkSliceFn[*state] {
+	return func(s *state, sl tw.Slice[*state]) error {
+		s.writeUint(uint64(sl.Len()))
+		if bytes, ok := tw.SliceAs[byte](sl); ok {
+			s.buf = append(s.buf, bytes...)
+			return nil
+		}
+		key, ok := s.enter(func() walkutil.Key {
+			return walkutil.Key{P: sl.Value().UnsafePointer(), Len: sl.Len(), Type: t}
+		})
+		if !ok {
+			return nil
+		}
+		err := sl.WalkAll(s)
+		s.leave(key)
+		return err
+	}
+}
+
+func compilePtr(t reflect.Type) tw.WalkPtrFn[*state] {
+	return func(s *state, p tw.Ptr[*state]) error {
+		if p.IsNil() {
+			s.writeByte(0)
+			return nil
+		}
+		s.writeByte(1)
+		key, ok := s.enter(func() walkutil.Key {
+			return walkutil.Key{P: walkutil.PointerOf(p.Interface()), Type: t}
+		})
+		if !ok {
+			return nil
+		}
+		err := p.Walk(s)
+		s.leave(key)
+		return err
+	}
+}
+
+func compileMap(t reflect.Type) tw.WalkMapFn[*state] {
+	return func(s *state, m tw.Map[*state]) error {
+		s.writeUint(uint64(m.Len()))
+		key, ok := s.enter(func() walkutil.Key {
+			return walkutil.Key{P: walkutil.PointerOf(m.Interface()), Type: t}
+		})
+		if !ok {
+			return nil
+		}
+		err := encodeEntries(s, m)
+		s.leave(key)
+		return err
+	}
+}
+
+// encodeEntries writes the sum of the hashes of the entries of m. Each entry is encoded to the end of the buffer, and
+// then hashed and removed from it, so nested maps are hashed before the entries containing them.
+func encodeEntries(s *state, m tw.Map[*state]) error {
+	var sum uint64
+	start := len(s.buf)
+	iter := m.Iter()
+	for iter.Next() {
+		entry := iter.Entry()
+		if err := entry.Key().Walk(s); err != nil {
+			return err
+		}
+		if err := entry.Value().Walk(s); err != nil {
+			return err
+		}
+		s.sub.Reset()
+		s.sub.Write(s.buf[start:])
+		sum += s.sub.Sum64()
+		s.buf = s.buf[:start]
+	}
+	s.writeUint(sum)
+	return nil
+}
+
+func compileInterface(reflect.Type) tw.WalkInterfaceFn[*state] {
+	return func(s *state, i tw.Interface[*state]) error {
+		if i.IsNil() {
+			s.writeByte(0)
+			return nil
+		}
+		s.writeByte(1)
+		t := reflect.TypeOf(i.Interface())
+		s.writeString(t.String())
+		if unsupportedTypes.Unsupported(t) {
+			return nil
+		}
+		return i.Walk(s)
+	}
+}
@@ -0,0 +1,173 @@
+package twhash_test
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc64"
+	"hash/fnv"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zolstein/type-walk/twequal"
+	"github.com/zolstein/type-walk/twhash"
+)
+
+type Record struct {
+	Key    string
+	Tags   map[string][]int
+	Value  any
+	Parent *Record
+	Cached string `hash:"-" equal:"-"`
+	done   chan int
+}
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+func TestHashEqualValues(t *testing.T) {
+	type option struct {
+		hash  []twhash.Option
+		equal []twequal.Option
+	}
+	ignoreTag := option{
+		[]twhash.Option{twhash.WithIgnoreTag("hash")},
+		[]twequal.Option{twequal.WithIgnoreTag("equal")},
+	}
+	equateEmpty := option{nil, []twequal.Option{twequal.WithEquateEmpty}}
+
+	record := func() Record {
+		return Record{
+			Key:    "k",
+			Tags:   map[string][]int{"a": {1, 2}, "b": nil},
+			Value:  []any{1, "two", &Node{Value: 3}},
+			Parent: &Record{Key: "p"},
+		}
+	}
+	cached := record()
+	cached.Cached = "c"
+	emptyTags := record()
+	emptyTags.Tags["b"] = []int{}
+
+	tests := []struct {
+		name string
+		a, b any
+		opt  option
+	}{
+		{"record", record(), record(), option{}},
+		{"ignoreTag", record(), cached, ignoreTag},
+		{"ignoreTagNested", []any{record()}, []any{cached}, ignoreTag},
+		{"emptySlice", []int(nil), []int{}, equateEmpty},
+		{"emptyMap", map[int]int(nil), map[int]int{}, equateEmpty},
+		{"emptyNested", emptyTags, record(), equateEmpty},
+		{"negativeZero", 0.0, math.Copysign(0, -1), option{}},
+		{"negativeZeroComplex", complex(0, 0), complex(math.Copysign(0, -1), 0), option{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.True(t, twequal.Equal(test.a, test.b, test.opt.equal...))
+			assert.Equal(t, twhash.Hash(test.a, test.opt.hash...), twhash.Hash(test.b, test.opt.hash...))
+		})
+	}
+
+	// NaNs are never equal, but they're encoded the same so that a NaN always has the same hash.
+	nan := math.Float64frombits(0x7ff8000000000001)
+	assert.Equal(t, twhash.Hash(math.NaN()), twhash.Hash(nan))
+	assert.Equal(t, twhash.Hash([]float32{float32(math.NaN())}), twhash.Hash([]float32{float32(nan)}))
+	assert.Equal(t, twhash.Hash(complex(math.NaN(), 1)), twhash.Hash(complex(nan, 1)))
+	assert.NotEqual(t, twhash.Hash(math.NaN()), twhash.Hash(math.Inf(1)))
+
+	// Fields containing channels are skipped, so values which differ only by them hash the same, although they're not
+	// equal.
+	withChan := record()
+	withChan.done = make(chan int)
+	assert.False(t, twequal.Equal(record(), withChan))
+	assert.Equal(t, twhash.Hash(record()), twhash.Hash(withChan))
+}
+
+func TestHashDifferentValues(t *testing.T) {
+	ring := func(values ...int) *Node {
+		head := &Node{Value: values[0]}
+		node := head
+		for _, v := range values[1:] {
+			node.Next = &Node{Value: v}
+			node = node.Next
+		}
+		node.Next = head
+		return head
+	}
+	// Each pair differs in a way which the encoding is designed to distinguish.
+	pairs := [][2]any{
+		{"ab", "a"},
+		{[]string{"ab", ""}, []string{"a", "b"}},
+		{[]any{nil}, []any{0}},
+		{[]any{int32(1)}, []any{int64(1)}},
+		{(*int)(nil), new(int)},
+		{map[int]int{1: 1, 2: 2}, map[int]int{1: 2, 2: 1}},
+		{Record{Cached: "a"}, Record{Cached: "b"}},
+		{Record{Parent: &Record{}}, Record{}},
+		{ring(1, 2), ring(1, 3)},
+	}
+	for _, pair := range pairs {
+		assert.NotEqual(t, twhash.Hash(pair[0]), twhash.Hash(pair[1]), "%#v", pair)
+	}
+}
+
+func TestHashMapOrder(t *testing.T) {
+	a := map[string]int{}
+	b := map[string]int{}
+	for i := 0; i < 100; i++ {
+		a[string(rune('a'+i))] = i
+		b[string(rune('a'+99-i))] = 99 - i
+	}
+	assert.Equal(t, twhash.Hash(a), twhash.Hash(b))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, twhash.Hash(a), twhash.Hash(a))
+	}
+}
+
+func TestHashEncoding(t *testing.T) {
+	// The encoding is stable, so the hash of a simple value can be computed by hand.
+	expected := fnv.New64a()
+	_ = binary.Write(expected, binary.LittleEndian, int64(2))
+	expected.Write([]byte("hi"))
+	assert.Equal(t, expected.Sum64(), twhash.Hash("hi"))
+
+	// Write writes the same encoding that Hash hashes.
+	r := Record{Key: "k", Value: map[string]any{"a": 1}}
+	h := fnv.New64a()
+	twhash.NewHasher().Write(h, r)
+	assert.Equal(t, twhash.Hash(r), h.Sum64())
+
+	// Map entries are hashed with the Hasher's hash function, whichever hash the encoding is written to.
+	table := crc64.MakeTable(crc64.ECMA)
+	crc := twhash.NewHasher(twhash.WithHashFunc(func() hash.Hash64 { return crc64.New(table) }))
+	c := crc64.New(table)
+	crc.Write(c, r)
+	assert.Equal(t, c.Sum64(), crc.Hash(r))
+	assert.NotEqual(t, twhash.Hash(r), crc.Hash(r))
+}
+
+func TestHashUnsupported(t *testing.T) {
+	ch := make(chan int)
+	assert.Equal(t, twhash.Hash([]chan int{ch}), twhash.Hash([]chan int{nil}))
+	// Interfaces holding unsupported types are encoded by their type.
+	assert.Equal(t, twhash.Hash([]any{ch}), twhash.Hash([]any{make(chan int)}))
+	assert.NotEqual(t, twhash.Hash([]any{ch}), twhash.Hash([]any{func() {}}))
+}
+
+func BenchmarkHash(b *testing.B) {
+	r := Record{
+		Key:    "k",
+		Tags:   map[string][]int{"a": {1, 2}, "b": nil},
+		Value:  []any{1, "two", &Node{Value: 3}},
+		Parent: &Record{Key: "p"},
+	}
+	records := []Record{r, r, r}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = twhash.Hash(records)
+	}
+}
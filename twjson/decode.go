@@ -0,0 +1,886 @@
+package twjson
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+)
+
+var (
+	unmarshalerType     = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	float64Type         = reflect.TypeOf(float64(0))
+)
+
+// decodeWalker is set in init, since decoders for maps and interfaces walk new values with it.
+var decodeWalker *tw.Walker[*decodeState]
+
+func init() {
+	decodeWalker = tw.NewWalker(newDecodeRegister(), tw.WithThreadSafe)
+}
+
+// Unmarshal parses the JSON-encoded data and stores the result in the value pointed to by v, like json.Unmarshal.
+func Unmarshal(data []byte, v any) error {
+	if err := checkValid(data); err != nil {
+		return err
+	}
+	d := decodeState{data: data}
+	return d.unmarshal(v)
+}
+
+// checkValid returns the *json.SyntaxError for data, if it is not valid JSON. Decoders only run on valid JSON, so
+// they don't check the syntax themselves.
+func checkValid(data []byte) error {
+	if json.Valid(data) {
+		return nil
+	}
+	var raw json.RawMessage
+	return json.Unmarshal(data, &raw)
+}
+
+// A Decoder reads JSON values from an input stream, like json.Decoder.
+type Decoder struct {
+	dec                   *json.Decoder
+	useNumber             bool
+	disallowUnknownFields bool
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{dec: json.NewDecoder(r)}
+}
+
+// UseNumber causes the Decoder to unmarshal a number into an interface value as a json.Number instead of as a
+// float64.
+func (dec *Decoder) UseNumber() {
+	dec.useNumber = true
+}
+
+// DisallowUnknownFields causes the Decoder to return an error when the destination is a struct and the input contains
+// object keys which do not match any non-ignored, exported fields in the destination.
+func (dec *Decoder) DisallowUnknownFields() {
+	dec.disallowUnknownFields = true
+}
+
+// More reports whether there is another element in the current array or object being parsed.
+func (dec *Decoder) More() bool {
+	return dec.dec.More()
+}
+
+// Decode reads the next JSON value from its input and stores it in the value pointed to by v.
+func (dec *Decoder) Decode(v any) error {
+	var raw json.RawMessage
+	if err := dec.dec.Decode(&raw); err != nil {
+		return err
+	}
+	d := decodeState{
+		data:                  raw,
+		useNumber:             dec.useNumber,
+		disallowUnknownFields: dec.disallowUnknownFields,
+	}
+	return d.unmarshal(v)
+}
+
+// decodeState holds the state of decoding a single JSON value. The value is valid JSON, so decoders read it without
+// checking its syntax.
+type decodeState struct {
+	data []byte
+	// off is the offset in data of the next byte to read.
+	off                   int
+	useNumber             bool
+	disallowUnknownFields bool
+	// errorStruct and errorField describe the struct field or map element being decoded, for errors.
+	errorStruct reflect.Type
+	errorField  []string
+	// savedError is the first error which didn't stop decoding. As in encoding/json, decoding carries on after a value
+	// that doesn't fit its destination, and returns the first such error at the end.
+	savedError error
+}
+
+func (d *decodeState) unmarshal(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &json.InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	if err := decodeWalker.WalkUnsafe(d, rv.Type().Elem(), rv.UnsafePointer(), true); err != nil {
+		return err
+	}
+	return d.savedError
+}
+
+func (d *decodeState) saveError(err error) {
+	if d.savedError == nil {
+		d.savedError = err
+	}
+}
+
+// typeError saves a *json.UnmarshalTypeError for a JSON value described by value which can't be stored in type t.
+func (d *decodeState) typeError(value string, t reflect.Type) {
+	err := &json.UnmarshalTypeError{
+		Value:  value,
+		Type:   t,
+		Offset: int64(d.off),
+		Field:  strings.Join(d.errorField, "."),
+	}
+	if d.errorStruct != nil {
+		err.Struct = d.errorStruct.Name()
+	}
+	d.saveError(err)
+}
+
+// mismatch skips the next value, which can't be stored in type t, saving a type error unless the value is null.
+func (d *decodeState) mismatch(t reflect.Type) {
+	var value string
+	switch d.next() {
+	case 'n':
+		d.skipValue()
+		return
+	case '{':
+		value = "object"
+	case '[':
+		value = "array"
+	case '"':
+		value = "string"
+	case 't', 'f':
+		value = "bool"
+	default:
+		value = "number"
+	}
+	d.skipValue()
+	d.typeError(value, t)
+}
+
+// next skips whitespace and returns the first byte of the next token.
+func (d *decodeState) next() byte {
+	for {
+		switch c := d.data[d.off]; c {
+		case ' ', '\t', '\n', '\r':
+			d.off++
+		default:
+			return c
+		}
+	}
+}
+
+// skipValue skips the next value.
+func (d *decodeState) skipValue() {
+	switch d.next() {
+	case '{', '[':
+		depth := 0
+		for {
+			switch d.data[d.off] {
+			case '"':
+				d.skipString()
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			d.off++
+			if depth == 0 {
+				return
+			}
+		}
+	case '"':
+		d.skipString()
+	default:
+		d.literal()
+	}
+}
+
+func (d *decodeState) skipString() {
+	d.off++
+	for {
+		switch d.data[d.off] {
+		case '\\':
+			d.off += 2
+		case '"':
+			d.off++
+			return
+		default:
+			d.off++
+		}
+	}
+}
+
+// rawValue returns the next value as it appears in the input.
+func (d *decodeState) rawValue() []byte {
+	d.next()
+	start := d.off
+	d.skipValue()
+	return d.data[start:d.off]
+}
+
+// literal returns the next number, true, false or null.
+func (d *decodeState) literal() []byte {
+	start := d.off
+	for d.off < len(d.data) {
+		c := d.data[d.off]
+		if c != '-' && c != '+' && c != '.' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && c != 'E' {
+			break
+		}
+		d.off++
+	}
+	return d.data[start:d.off]
+}
+
+// readString returns the contents of the next string, with escape sequences replaced. The result shares memory with
+// the input if the string has no escape sequences.
+func (d *decodeState) readString() []byte {
+	d.off++
+	start := d.off
+	for {
+		switch c := d.data[d.off]; {
+		case c == '"':
+			s := d.data[start:d.off]
+			d.off++
+			return s
+		case c == '\\' || c >= utf8.RuneSelf:
+			d.off = start - 1
+			d.skipString()
+			return unquote(d.data[start : d.off-1])
+		default:
+			d.off++
+		}
+	}
+}
+
+// unquote replaces the escape sequences in s, and replaces invalid UTF-8 and unpaired surrogates with U+FFFD.
+func unquote(s []byte) []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '\\':
+			switch s[i+1] {
+			case 'b':
+				b = append(b, '\b')
+			case 'f':
+				b = append(b, '\f')
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'u':
+				r := getu4(s[i+2:])
+				i += 6
+				if utf16.IsSurrogate(r) {
+					if i+6 <= len(s) && s[i] == '\\' && s[i+1] == 'u' {
+						if pair := utf16.DecodeRune(r, getu4(s[i+2:])); pair != unicode.ReplacementChar {
+							b = utf8.AppendRune(b, pair)
+							i += 6
+							continue
+						}
+					}
+					r = unicode.ReplacementChar
+				}
+				b = utf8.AppendRune(b, r)
+				continue
+			default:
+				// '"', '\\' and '/' stand for themselves.
+				b = append(b, s[i+1])
+			}
+			i += 2
+		case c < utf8.RuneSelf:
+			b = append(b, c)
+			i++
+		default:
+			r, size := utf8.DecodeRune(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				b = utf8.AppendRune(b, unicode.ReplacementChar)
+			} else {
+				b = append(b, s[i:i+size]...)
+			}
+			i += size
+		}
+	}
+	return b
+}
+
+// getu4 decodes the four hex digits at the start of s.
+func getu4(s []byte) rune {
+	var r rune
+	for _, c := range s[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			c = c - 'A' + 10
+		}
+		r = r<<4 | rune(c)
+	}
+	return r
+}
+
+// object calls fn with the key of each member of the next object, after which fn must consume the member's value.
+func (d *decodeState) object(fn func(key []byte) error) error {
+	d.off++
+	for d.next() != '}' {
+		key := d.readString()
+		d.next()
+		d.off++
+		if err := fn(key); err != nil {
+			return err
+		}
+		if d.next() == ',' {
+			d.off++
+		}
+	}
+	d.off++
+	return nil
+}
+
+// array calls fn for each element of the next array, which fn must consume.
+func (d *decodeState) array(fn func() error) error {
+	d.off++
+	for d.next() != ']' {
+		if err := fn(); err != nil {
+			return err
+		}
+		if d.next() == ',' {
+			d.off++
+		}
+	}
+	d.off++
+	return nil
+}
+
+// anyValue returns the next value as a bool, float64 or json.Number, string, []any, map[string]any or nil.
+func (d *decodeState) anyValue() any {
+	switch d.next() {
+	case '{':
+		m := map[string]any{}
+		_ = d.object(func(key []byte) error {
+			m[string(key)] = d.anyValue()
+			return nil
+		})
+		return m
+	case '[':
+		a := []any{}
+		_ = d.array(func() error {
+			a = append(a, d.anyValue())
+			return nil
+		})
+		return a
+	case '"':
+		return string(d.readString())
+	case 't', 'f', 'n':
+		switch string(d.literal()) {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+		return nil
+	}
+	lit := d.literal()
+	if d.useNumber {
+		return json.Number(lit)
+	}
+	f, err := strconv.ParseFloat(string(lit), 64)
+	if err != nil {
+		d.typeError("number "+string(lit), float64Type)
+		return nil
+	}
+	return f
+}
+
+// decoded wraps dec, the decoder for values of type t, to call UnmarshalJSON or UnmarshalText if a pointer to t has
+// either method. value returns the value of type t that a refers to.
+func decoded[A any](t reflect.Type, dec func(*decodeState, A) error, value func(A) reflect.Value) func(*decodeState, A) error {
+	// Values are always addressable while decoding, so methods with pointer receivers are always called.
+	ptr := reflect.PointerTo(t)
+	unmarshalJSON := ptr.Implements(unmarshalerType)
+	unmarshalText := ptr.Implements(textUnmarshalerType)
+	if t.Kind() == reflect.Pointer || !unmarshalJSON && !unmarshalText {
+		return dec
+	}
+	return func(d *decodeState, a A) error {
+		v := value(a).Addr()
+		if unmarshalJSON {
+			return v.Interface().(json.Unmarshaler).UnmarshalJSON(d.rawValue())
+		}
+		if d.next() != '"' {
+			d.mismatch(t)
+			return nil
+		}
+		return v.Interface().(encoding.TextUnmarshaler).UnmarshalText(d.readString())
+	}
+}
+
+func newDecodeRegister() *tw.Register[*decodeState] {
+	r := tw.NewRegister[*decodeState]()
+	tw.RegisterCompileBoolFn(r, boolDecoder)
+	tw.RegisterCompileIntFn(r, intDecoder[int])
+	tw.RegisterCompileInt8Fn(r, intDecoder[int8])
+	tw.RegisterCompileInt16Fn(r, intDecoder[int16])
+	tw.RegisterCompileInt32Fn(r, intDecoder[int32])
+	tw.RegisterCompileInt64Fn(r, intDecoder[int64])
+	tw.RegisterCompileUintFn(r, uintDecoder[uint])
+	tw.RegisterCompileUint8Fn(r, uintDecoder[uint8])
+	tw.RegisterCompileUint16Fn(r, uintDecoder[uint16])
+	tw.RegisterCompileUint32Fn(r, uintDecoder[uint32])
+	tw.RegisterCompileUint64Fn(r, uintDecoder[uint64])
+	tw.RegisterCompileUintptrFn(r, uintDecoder[uintptr])
+	tw.RegisterCompileFloat32Fn(r, floatDecoder[float32])
+	tw.RegisterCompileFloat64Fn(r, floatDecoder[float64])
+	tw.RegisterCompileComplex64Fn(r, unsupportedDecoder[complex64])
+	tw.RegisterCompileComplex128Fn(r, unsupportedDecoder[complex128])
+	tw.RegisterCompileUnsafePointerFn(r, unsupportedDecoder[unsafe.Pointer])
+	tw.RegisterCompileStringFn(r, stringDecoder)
+	tw.RegisterCompileStructFn(r, structDecoder)
+	tw.RegisterCompileArrayFn(r, arrayDecoder)
+	tw.RegisterCompileSliceFn(r, sliceDecoder)
+	tw.RegisterCompilePtrFn(r, ptrDecoder)
+	tw.RegisterCompileMapFn(r, mapDecoder)
+	tw.RegisterCompileInterfaceFn(r, interfaceDecoder)
+	return r
+}
+
+func boolDecoder(t reflect.Type) tw.WalkFn[*decodeState, bool] {
+	return decoded(t, func(d *decodeState, b tw.Bool) error {
+		switch d.next() {
+		case 't':
+			d.off += len("true")
+			b.Set(true)
+		case 'f':
+			d.off += len("false")
+			b.Set(false)
+		default:
+			d.mismatch(t)
+		}
+		return nil
+	}, argValue[bool](t))
+}
+
+// number returns the next number, or saves a type error for t and returns nil if the next value isn't a number.
+func (d *decodeState) number(t reflect.Type) []byte {
+	if c := d.next(); c != '-' && (c < '0' || c > '9') {
+		d.mismatch(t)
+		return nil
+	}
+	return d.literal()
+}
+
+func intDecoder[T int | int8 | int16 | int32 | int64](t reflect.Type) tw.WalkFn[*decodeState, T] {
+	bits := int(unsafe.Sizeof(T(0)) * 8)
+	return decoded(t, func(d *decodeState, a tw.Arg[T]) error {
+		lit := d.number(t)
+		if lit == nil {
+			return nil
+		}
+		n, err := strconv.ParseInt(string(lit), 10, bits)
+		if err != nil {
+			d.typeError("number "+string(lit), t)
+			return nil
+		}
+		a.Set(T(n))
+		return nil
+	}, argValue[T](t))
+}
+
+func uintDecoder[T uint | uint8 | uint16 | uint32 | uint64 | uintptr](t reflect.Type) tw.WalkFn[*decodeState, T] {
+	bits := int(unsafe.Sizeof(T(0)) * 8)
+	return decoded(t, func(d *decodeState, a tw.Arg[T]) error {
+		lit := d.number(t)
+		if lit == nil {
+			return nil
+		}
+		n, err := strconv.ParseUint(string(lit), 10, bits)
+		if err != nil {
+			d.typeError("number "+string(lit), t)
+			return nil
+		}
+		a.Set(T(n))
+		return nil
+	}, argValue[T](t))
+}
+
+func floatDecoder[T float32 | float64](t reflect.Type) tw.WalkFn[*decodeState, T] {
+	bits := int(unsafe.Sizeof(T(0)) * 8)
+	return decoded(t, func(d *decodeState, a tw.Arg[T]) error {
+		lit := d.number(t)
+		if lit == nil {
+			return nil
+		}
+		f, err := strconv.ParseFloat(string(lit), bits)
+		if err != nil {
+			d.typeError("number "+string(lit), t)
+			return nil
+		}
+		a.Set(T(f))
+		return nil
+	}, argValue[T](t))
+}
+
+func stringDecoder(t reflect.Type) tw.WalkFn[*decodeState, string] {
+	if t == numberType {
+		return decoded(t, func(d *decodeState, s tw.String) error {
+			if d.next() != '"' {
+				if lit := d.number(t); lit != nil {
+					s.Set(string(lit))
+				}
+				return nil
+			}
+			num := string(d.readString())
+			if !isValidNumber(num) {
+				return fmt.Errorf("json: invalid number literal, trying to unmarshal %q into Number", num)
+			}
+			s.Set(num)
+			return nil
+		}, argValue[string](t))
+	}
+	return decoded(t, func(d *decodeState, s tw.String) error {
+		if d.next() != '"' {
+			d.mismatch(t)
+			return nil
+		}
+		s.Set(string(d.readString()))
+		return nil
+	}, argValue[string](t))
+}
+
+func unsupportedDecoder[T any](t reflect.Type) tw.WalkFn[*decodeState, T] {
+	return decoded(t, func(d *decodeState, a tw.Arg[T]) error {
+		d.mismatch(t)
+		return nil
+	}, argValue[T](t))
+}
+
+// decodeField is a field decoded by a struct decoder.
+type decodeField struct {
+	field
+	// num is the index of the field in the StructFieldRegister.
+	num int
+	// unsupported is the type of a channel or function in the field's type, which can't be decoded.
+	unsupported reflect.Type
+}
+
+func structDecoder(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*decodeState] {
+	fields := typeFields(t)
+	decodeFields := make([]decodeField, len(fields))
+	byName := make(map[string]*decodeField, len(fields))
+	for i, f := range fields {
+		df := &decodeFields[i]
+		df.field = f
+		if df.unsupported = walkutil.Unsupported(f.typ, nil); df.unsupported == nil {
+			df.num = sfr.RegisterFieldByIndex(f.index)
+		}
+		byName[f.name] = df
+	}
+	// lookup prefers an exact match for key, but also accepts a case-insensitive match, like encoding/json.
+	lookup := func(key []byte) *decodeField {
+		if f, ok := byName[string(key)]; ok {
+			return f
+		}
+		for i := range decodeFields {
+			if strings.EqualFold(decodeFields[i].name, string(key)) {
+				return &decodeFields[i]
+			}
+		}
+		return nil
+	}
+
+	return decoded(t, func(d *decodeState, s tw.Struct[*decodeState]) error {
+		if d.next() != '{' {
+			d.mismatch(t)
+			return nil
+		}
+		return d.object(func(key []byte) error {
+			f := lookup(key)
+			if f == nil {
+				if d.disallowUnknownFields {
+					d.saveError(fmt.Errorf("json: unknown field %q", key))
+				}
+				d.skipValue()
+				return nil
+			}
+			errorStruct := d.errorStruct
+			d.errorStruct, d.errorField = t, append(d.errorField, f.name)
+			err := d.structField(s, f)
+			d.errorStruct, d.errorField = errorStruct, d.errorField[:len(d.errorField)-1]
+			return err
+		})
+	}, tw.Struct[*decodeState].Value)
+}
+
+func (d *decodeState) structField(s tw.Struct[*decodeState], f *decodeField) error {
+	if f.unsupported != nil {
+		d.mismatch(f.unsupported)
+		return nil
+	}
+	sf := s.Field(f.num)
+	if !sf.IsValid() {
+		// The field is promoted through a nil embedded pointer.
+		if err := allocEmbedded(s.Value(), f.index); err != nil {
+			d.saveError(err)
+			d.skipValue()
+			return nil
+		}
+		sf = s.Field(f.num)
+	}
+	if !f.quoted {
+		return sf.Walk(d)
+	}
+
+	switch d.next() {
+	case 'n':
+		return sf.Walk(d)
+	case '"':
+		lit := d.readString()
+		if len(lit) == 0 || !json.Valid(lit) || strings.IndexByte("{[ \t\r\n", lit[0]) >= 0 {
+			d.saveError(fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal %q into %v", lit, f.typ))
+			return nil
+		}
+		// Decode the literal in the string in place of the string.
+		data, off := d.data, d.off
+		d.data, d.off = lit, 0
+		err := sf.Walk(d)
+		d.data, d.off = data, off
+		return err
+	default:
+		d.mismatch(f.typ)
+		return nil
+	}
+}
+
+// allocEmbedded allocates the nil embedded pointers the field at index of struct v is promoted through.
+func allocEmbedded(v reflect.Value, index []int) error {
+	for _, i := range index[:len(index)-1] {
+		v = v.Field(i)
+		if v.Kind() != reflect.Pointer {
+			continue
+		}
+		if v.IsNil() {
+			if !v.CanSet() {
+				return fmt.Errorf("json: cannot set embedded pointer to unexported struct: %v", v.Type().Elem())
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return nil
+}
+
+func arrayDecoder(t reflect.Type) tw.WalkArrayFn[*decodeState] {
+	return decoded(t, func(d *decodeState, a tw.Array[*decodeState]) error {
+		if d.next() != '[' {
+			d.mismatch(t)
+			return nil
+		}
+		n := 0
+		err := d.array(func() error {
+			if n >= a.Len() {
+				// Extra elements are ignored.
+				d.skipValue()
+				return nil
+			}
+			n++
+			return a.Elem(n - 1).Walk(d)
+		})
+		if err != nil {
+			return err
+		}
+		if n < a.Len() {
+			// Missing elements are set to zero.
+			v := a.Value()
+			for ; n < a.Len(); n++ {
+				v.Index(n).SetZero()
+			}
+		}
+		return nil
+	}, tw.Array[*decodeState].Value)
+}
+
+func sliceDecoder(t reflect.Type) tw.WalkSliceFn[*decodeState] {
+	elem := t.Elem()
+	bytes := false
+	if elem.Kind() == reflect.Uint8 {
+		p := reflect.PointerTo(elem)
+		bytes = !p.Implements(unmarshalerType) && !p.Implements(textUnmarshalerType)
+	}
+	return decoded(t, func(d *decodeState, s tw.Slice[*decodeState]) error {
+		switch d.next() {
+		case 'n':
+			d.skipValue()
+			s.SetNil()
+			return nil
+		case '"':
+			if bytes {
+				return d.byteSlice(s)
+			}
+			d.mismatch(t)
+			return nil
+		case '[':
+		default:
+			d.mismatch(t)
+			return nil
+		}
+		// As in encoding/json, elements within the slice's length are decoded into, rather than reset first.
+		n := 0
+		err := d.array(func() error {
+			if n >= s.Len() {
+				s.SetLen(n + 1)
+			}
+			n++
+			return s.Elem(n - 1).Walk(d)
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// An empty array gives an empty slice, rather than nil.
+			s.Value().Set(reflect.MakeSlice(t, 0, 0))
+		} else if n < s.Len() {
+			s.SetLen(n)
+		}
+		return nil
+	}, tw.Slice[*decodeState].Value)
+}
+
+func (d *decodeState) byteSlice(s tw.Slice[*decodeState]) error {
+	src := d.readString()
+	b := make([]byte, base64.StdEncoding.DecodedLen(len(src)))
+	n, err := base64.StdEncoding.Decode(b, src)
+	if err != nil {
+		d.saveError(err)
+		return nil
+	}
+	s.Value().SetBytes(b[:n])
+	return nil
+}
+
+func ptrDecoder(t reflect.Type) tw.WalkPtrFn[*decodeState] {
+	return decoded(t, func(d *decodeState, p tw.Ptr[*decodeState]) error {
+		if d.next() == 'n' {
+			d.skipValue()
+			p.SetNil()
+			return nil
+		}
+		if p.IsNil() {
+			p.Alloc()
+		}
+		return p.Walk(d)
+	}, tw.Ptr[*decodeState].Value)
+}
+
+func interfaceDecoder(t reflect.Type) tw.WalkInterfaceFn[*decodeState] {
+	return decoded(t, func(d *decodeState, i tw.Interface[*decodeState]) error {
+		v := i.Value()
+		if d.next() == 'n' {
+			d.skipValue()
+			v.SetZero()
+			return nil
+		}
+		if !i.IsNil() {
+			// A non-nil pointer in the interface is decoded into, rather than replaced.
+			if e := v.Elem(); e.Kind() == reflect.Pointer && !e.IsNil() {
+				return decodeWalker.WalkUnsafe(d, e.Type().Elem(), e.UnsafePointer(), true)
+			}
+		}
+		if t.NumMethod() > 0 {
+			d.mismatch(t)
+			return nil
+		}
+		if val := d.anyValue(); val != nil {
+			v.Set(reflect.ValueOf(val))
+		} else {
+			v.SetZero()
+		}
+		return nil
+	}, tw.Interface[*decodeState].Value)
+}
+
+func mapDecoder(t reflect.Type) tw.WalkMapFn[*decodeState] {
+	key, elem := t.Key(), t.Elem()
+	textKey := reflect.PointerTo(key).Implements(textUnmarshalerType)
+	if !textKey {
+		switch key.Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			return decoded(t, func(d *decodeState, m tw.Map[*decodeState]) error {
+				d.mismatch(t)
+				return nil
+			}, tw.Map[*decodeState].Value)
+		}
+	}
+
+	return decoded(t, func(d *decodeState, m tw.Map[*decodeState]) error {
+		switch d.next() {
+		case 'n':
+			d.skipValue()
+			m.SetNil()
+			return nil
+		case '{':
+		default:
+			d.mismatch(t)
+			return nil
+		}
+		if m.IsNil() {
+			m.Alloc()
+		}
+		return d.object(func(k []byte) error {
+			// Map elements aren't addressable, so each is decoded into a new value and then stored in the map.
+			ev := reflect.New(elem)
+			d.errorField = append(d.errorField, string(k))
+			err := decodeWalker.WalkUnsafe(d, elem, ev.UnsafePointer(), true)
+			d.errorField = d.errorField[:len(d.errorField)-1]
+			if err != nil {
+				return err
+			}
+			kv, err := d.mapKey(k, key, textKey)
+			if err != nil || !kv.IsValid() {
+				return err
+			}
+			m.SetIndex(kv, ev.Elem())
+			return nil
+		})
+	}, tw.Map[*decodeState].Value)
+}
+
+// mapKey converts the object key k to a map key of type t. It returns the zero reflect.Value if k can't be converted,
+// after saving a type error.
+func (d *decodeState) mapKey(k []byte, t reflect.Type, text bool) (reflect.Value, error) {
+	kv := reflect.New(t).Elem()
+	if text {
+		err := kv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(k)
+		return kv, err
+	}
+	switch t.Kind() {
+	case reflect.String:
+		kv.SetString(string(k))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(k), 10, 64)
+		if err != nil || kv.OverflowInt(n) {
+			d.typeError("number "+string(k), t)
+			return reflect.Value{}, nil
+		}
+		kv.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(string(k), 10, 64)
+		if err != nil || kv.OverflowUint(n) {
+			d.typeError("number "+string(k), t)
+			return reflect.Value{}, nil
+		}
+		kv.SetUint(n)
+	}
+	return kv, nil
+}
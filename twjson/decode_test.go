@@ -0,0 +1,270 @@
+package twjson_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zolstein/type-walk/twjson"
+)
+
+type Inner struct {
+	A int
+	B []string
+}
+
+type Outer struct {
+	Inner `json:"inner"`
+	*Base
+	Audit  *Audit
+	Name   string `json:"name"`
+	Values map[string]Inner
+	Any    any
+	Ptr    *Inner
+	Arr    [2]int
+	Quoted int64 `json:",string"`
+}
+
+func TestUnmarshalEquivalence(t *testing.T) {
+	for i, v := range corpus() {
+		if v == nil {
+			continue
+		}
+		t.Run(fmt.Sprintf("%d_%T", i, v), func(t *testing.T) {
+			data, err := json.Marshal(v)
+			require.NoError(t, err)
+			typ := reflect.TypeOf(v)
+			expected, actual := reflect.New(typ), reflect.New(typ)
+			expectedErr := json.Unmarshal(data, expected.Interface())
+			actualErr := twjson.Unmarshal(data, actual.Interface())
+			assert.Equal(t, expectedErr == nil, actualErr == nil, "expected %v, got %v", expectedErr, actualErr)
+			assert.Equal(t, expected.Elem().Interface(), actual.Elem().Interface())
+		})
+	}
+}
+
+func TestUnmarshalInputs(t *testing.T) {
+	n := 5
+	tests := []struct {
+		name string
+		data string
+		// target returns a new value to decode into. Each decoder gets a value of its own.
+		target func() any
+	}{
+		{"any", `{"a": [1, "b", true, null, {"c": -1.5e3}], "d": {}}`, func() any { return new(any) }},
+		{"struct", `{"inner": {"A": 1, "B": ["x"]}, "ID": 2, "name": "n", "Values": {"k": {"a": 3}}, "Any": [1]}`,
+			func() any { return new(Outer) }},
+		{"caseInsensitive", `{"INNER": {"a": 1}, "id": 2, "NAME": "n", "audit": {"name": "x"}}`,
+			func() any { return new(Outer) }},
+		{"exactMatchPreferred", `{"name": "lower", "Name": "upper"}`, func() any { return new(Tagged) }},
+		{"unknownFields", `{"Unknown": {"x": [1, {"y": "}"}]}, "ID": 1}`, func() any { return new(Outer) }},
+		{"embeddedPtr", `{"ID": 7}`, func() any { return new(Outer) }},
+		{"existingPtr", `{"Ptr": {"B": ["y"]}}`, func() any { return &Outer{Ptr: &Inner{A: 1}} }},
+		{"nullPtr", `{"Ptr": null, "Any": null, "Values": null}`, func() any {
+			return &Outer{Ptr: &Inner{A: 1}, Any: 1, Values: map[string]Inner{}}
+		}},
+		{"existingMap", `{"b": 2}`, func() any { return &map[string]int{"a": 1} }},
+		{"existingSlice", `[1, 2]`, func() any { return &[]int{9, 9, 9} }},
+		{"emptySlice", `[]`, func() any { return &[]int{9} }},
+		{"growSlice", `[1, 2, 3, 4, 5, 6, 7, 8, 9, 10]`, func() any { s := make([]int, 1, 2); return &s }},
+		{"shortArray", `{"Arr": [1]}`, func() any { return &Outer{Arr: [2]int{3, 4}} }},
+		{"longArray", `[1, 2, 3]`, func() any { return new([2]int) }},
+		{"quoted", `{"Quoted": "123"}`, func() any { return new(Outer) }},
+		{"quotedTagged", `{"QuotedInt": "-4", "QuotedPtr": "1.5", "QuotedStr": "\"s\"", "qb": "true"}`,
+			func() any { return new(Tagged) }},
+		{"quotedInvalid", `{"QuotedInt": "x", "QuotedStr": "s"}`, func() any { return new(Tagged) }},
+		{"quotedUnquoted", `{"Quoted": 123, "ID": 1}`, func() any { return new(Outer) }},
+		{"bytesBase64", `"aGVsbG8="`, func() any { return new([]byte) }},
+		{"bytesArray", `[1, 2, 255]`, func() any { return new([]byte) }},
+		{"bytesInvalid", `"!!!"`, func() any { return new([]byte) }},
+		{"escapes", `"a\"b\\c\/d\b\f\n\r\t\u00e9\ud83d\ude00\ud83d x \udc00\u0041"`, func() any { return new(string) }},
+		{"invalidUTF8", "\"a\xffb\"", func() any { return new(string) }},
+		{"typeMismatchContinues", `{"ID": "one", "name": 2, "Arr": [1, "2"], "Ptr": {"A": 3}}`,
+			func() any { return new(Outer) }},
+		{"intOverflow", `[127, 128, -129]`, func() any { return new([]int8) }},
+		{"uintNegative", `[-1]`, func() any { return new([]uint) }},
+		{"fraction", `[1.5]`, func() any { return new([]int) }},
+		{"number", `[1.5e3, "2", "x"]`, func() any { return new([]json.Number) }},
+		{"intKeys", `{"1": "a", "-2": "b", "x": "c"}`, func() any { return new(map[int]string) }},
+		{"uintKeys", `{"1": true, "256": false}`, func() any { return new(map[uint8]bool) }},
+		{"textKeys", `{"color-1": 1, "color-2": 2}`, func() any { return new(map[Color]int) }},
+		{"textUnmarshaler", `["color-3", 4, null]`, func() any { return new([]Color) }},
+		{"jsonUnmarshaler", `{"a": [1, 2], "b": null}`, func() any { return new(map[string]*Point) }},
+		{"time", `"2024-01-02T03:04:05Z"`, func() any { return new(any) }},
+		{"ifacePtr", `{"A": 1}`, func() any { var i any = &Inner{B: []string{"x"}}; return &i }},
+		{"ifaceNonPtr", `{"A": 1}`, func() any { var i any = Inner{B: []string{"x"}}; return &i }},
+		{"ifaceMethods", `{"S": "x"}`, func() any { return new(struct{ S fmt.Stringer }) }},
+		{"nullScalars", `{"A": null, "B": null}`, func() any { return &Inner{A: 1, B: []string{"x"}} }},
+		{"topLevelNull", `null`, func() any { p := &n; return &p }},
+		{"complex", `[1]`, func() any { return new([]complex64) }},
+		{"chanField", `{"C": 1, "D": 2}`, func() any {
+			return new(struct {
+				C chan int
+				D int
+			})
+		}},
+		{"whitespace", " \t\n{ \"A\" : 1 , \"B\" : [ \"x\" , \"y\" ] }\r\n", func() any { return new(Inner) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected, actual := test.target(), test.target()
+			expectedErr := json.Unmarshal([]byte(test.data), expected)
+			actualErr := twjson.Unmarshal([]byte(test.data), actual)
+			assert.Equal(t, expectedErr == nil, actualErr == nil, "expected %v, got %v", expectedErr, actualErr)
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestDecoderEquivalence(t *testing.T) {
+	input := `{"ID": 1, "name": "a"} {"ID": 1.5e3} {"Extra": true, "ID": 2} [1, 2.5, "x"] 7 "s" null`
+	for _, useNumber := range []bool{true, false} {
+		for _, disallowUnknownFields := range []bool{true, false} {
+			name := fmt.Sprintf("useNumber=%v,disallowUnknownFields=%v", useNumber, disallowUnknownFields)
+			t.Run(name, func(t *testing.T) {
+				jsonDec := json.NewDecoder(strings.NewReader(input))
+				dec := twjson.NewDecoder(strings.NewReader(input))
+				if useNumber {
+					jsonDec.UseNumber()
+					dec.UseNumber()
+				}
+				if disallowUnknownFields {
+					jsonDec.DisallowUnknownFields()
+					dec.DisallowUnknownFields()
+				}
+				for i := 0; i < 3; i++ {
+					var expected, actual Base
+					expectedErr := jsonDec.Decode(&expected)
+					actualErr := dec.Decode(&actual)
+					assert.Equal(t, expectedErr == nil, actualErr == nil, "expected %v, got %v", expectedErr, actualErr)
+					assert.Equal(t, expected, actual)
+				}
+				for jsonDec.More() {
+					require.True(t, dec.More())
+					var expected, actual any
+					require.NoError(t, jsonDec.Decode(&expected))
+					require.NoError(t, dec.Decode(&actual))
+					assert.Equal(t, expected, actual)
+				}
+				assert.False(t, dec.More())
+			})
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var i int
+	tests := []struct {
+		name string
+		data string
+		v    any
+		err  any
+	}{
+		{"syntax", `{"a": }`, new(any), new(*json.SyntaxError)},
+		{"trailing", `1 2`, new(any), new(*json.SyntaxError)},
+		{"nonPointer", `1`, i, new(*json.InvalidUnmarshalError)},
+		{"nilPointer", `1`, (*int)(nil), new(*json.InvalidUnmarshalError)},
+		{"typeError", `{"ID": "x"}`, new(Base), new(*json.UnmarshalTypeError)},
+		{"badKeys", `{"a": 1}`, new(map[[2]int]int), new(*json.UnmarshalTypeError)},
+		{"unmarshalerError", `{"a": "x"}`, new(map[string]Point), new(*json.UnmarshalTypeError)},
+		{"unquotedString", `{"Quoted": 1}`, new(Outer), new(*json.UnmarshalTypeError)},
+		{"mapElement", `{"Values": {"x": {"A": "y"}}}`, new(Outer), new(*json.UnmarshalTypeError)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := twjson.Unmarshal([]byte(test.data), test.v)
+			require.Error(t, err)
+			assert.ErrorAs(t, err, test.err)
+		})
+	}
+
+	t.Run("fieldPath", func(t *testing.T) {
+		err := twjson.Unmarshal([]byte(`{"Ptr": {"B": [1]}}`), new(Outer))
+		var typeErr *json.UnmarshalTypeError
+		require.ErrorAs(t, err, &typeErr)
+		assert.Equal(t, "Inner", typeErr.Struct)
+		assert.Equal(t, "Ptr.B", typeErr.Field)
+		assert.Equal(t, "number", typeErr.Value)
+		assert.Equal(t, reflect.TypeOf(""), typeErr.Type)
+	})
+
+	t.Run("unquotedStringPath", func(t *testing.T) {
+		err := twjson.Unmarshal([]byte(`{"Quoted": 1}`), new(Outer))
+		var typeErr *json.UnmarshalTypeError
+		require.ErrorAs(t, err, &typeErr)
+		assert.Equal(t, "Outer", typeErr.Struct)
+		assert.Equal(t, "Quoted", typeErr.Field)
+		assert.Equal(t, "number", typeErr.Value)
+		assert.Equal(t, reflect.TypeOf(int64(0)), typeErr.Type)
+		assert.EqualError(t, err, "json: cannot unmarshal number into Go struct field Outer.Quoted of type int64")
+	})
+
+	t.Run("mapElementPath", func(t *testing.T) {
+		err := twjson.Unmarshal([]byte(`{"Values": {"x": {"A": "y"}}}`), new(Outer))
+		var typeErr *json.UnmarshalTypeError
+		require.ErrorAs(t, err, &typeErr)
+		assert.Equal(t, "Inner", typeErr.Struct)
+		assert.Equal(t, "Values.x.A", typeErr.Field)
+		assert.Equal(t, "string", typeErr.Value)
+		assert.Equal(t, reflect.TypeOf(0), typeErr.Type)
+	})
+
+	t.Run("floatOverflow", func(t *testing.T) {
+		// encoding/json built with GOEXPERIMENT=jsonv2 stores infinities for these instead, so they're checked against
+		// the output of encoding/json v1 rather than compared with it.
+		floats := []float32{}
+		err := twjson.Unmarshal([]byte(`[1e39, 2]`), &floats)
+		assert.EqualError(t, err, "json: cannot unmarshal number 1e39 into Go value of type float32")
+		assert.Equal(t, []float32{0, 2}, floats)
+
+		var a any
+		err = twjson.Unmarshal([]byte(`[1e400, 2]`), &a)
+		assert.EqualError(t, err, "json: cannot unmarshal number 1e400 into Go value of type float64")
+		assert.Equal(t, []any{nil, 2.0}, a)
+
+		s := struct{ F float64 }{F: 7}
+		require.Error(t, twjson.Unmarshal([]byte(`{"F": 1e400}`), &s))
+		assert.Equal(t, 7.0, s.F)
+	})
+
+	t.Run("unknownField", func(t *testing.T) {
+		dec := twjson.NewDecoder(strings.NewReader(`{"ID": 1, "Other": 2}`))
+		dec.DisallowUnknownFields()
+		var b Base
+		assert.EqualError(t, dec.Decode(&b), `json: unknown field "Other"`)
+		assert.Equal(t, 1, b.ID)
+	})
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	v := Outer{
+		Inner:  Inner{A: 1, B: []string{"a", "b", "c"}},
+		Base:   &Base{ID: 2, Name: "base"},
+		Name:   "outer",
+		Values: map[string]Inner{"x": {A: 3}, "y": {B: []string{"d"}}},
+		Any:    []any{1.0, "two", map[string]any{"three": true}},
+		Ptr:    &Inner{A: 4},
+		Arr:    [2]int{5, 6},
+		Quoted: 7,
+	}
+	data, err := json.Marshal([]Outer{v, v, v, v})
+	require.NoError(b, err)
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var out []Outer
+			_ = json.Unmarshal(data, &out)
+		}
+	})
+	b.Run("twjson", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var out []Outer
+			_ = twjson.Unmarshal(data, &out)
+		}
+	})
+}
@@ -0,0 +1,776 @@
+// Package twjson encodes and decodes values as JSON, compatibly with encoding/json, using type-walk Walkers.
+//
+// The output is the same as encoding/json's, including struct tags, embedded structs, marshalers and map key order.
+// Decoding follows encoding/json's rules too, including case-insensitive field matching, unmarshalers, and decoding
+// into existing pointers, slices and maps.
+//
+// The differences from encoding/json are:
+//   - Types containing channels or functions outside of struct fields, e.g. []func(), return the Walker's error
+//     for the kind rather than a *json.UnsupportedTypeError or *json.UnmarshalTypeError.
+//   - Errors returned by MarshalText are reported with the name MarshalJSON in the *json.MarshalerError.
+//   - An Encoder writes its output as it is produced, so if an error occurs, partial output may have been written.
+//   - Decoding carries on after any error which encoding/json saves until the end, including errors from the ",string"
+//     option, rather than only after type errors.
+package twjson
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+)
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	numberType        = reflect.TypeOf(json.Number(""))
+)
+
+// flushSize is the size of the output an Encoder buffers before writing it.
+const flushSize = 4096
+
+var encodeWalker = tw.NewWalker(newEncodeRegister(), tw.WithThreadSafe)
+
+// Marshal returns the JSON encoding of v, like json.Marshal.
+func Marshal(v any) ([]byte, error) {
+	e := newEncodeState(nil, true)
+	defer e.release()
+	if err := e.marshal(v); err != nil {
+		return nil, err
+	}
+	return slices.Clone(e.buf), nil
+}
+
+// An Encoder writes JSON values to an output stream, like json.Encoder.
+type Encoder struct {
+	w          io.Writer
+	escapeHTML bool
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, escapeHTML: true}
+}
+
+// SetEscapeHTML specifies whether problematic HTML characters should be escaped inside JSON strings. The default is
+// true, which replaces <, > and & with \u003c, \u003e and \u0026.
+func (enc *Encoder) SetEscapeHTML(on bool) {
+	enc.escapeHTML = on
+}
+
+// Encode writes the JSON encoding of v to the stream, followed by a newline.
+//
+// Large values are written in several chunks as they are encoded, rather than buffered in full.
+func (enc *Encoder) Encode(v any) error {
+	e := newEncodeState(enc.w, enc.escapeHTML)
+	defer e.release()
+	if err := e.marshal(v); err != nil {
+		return err
+	}
+	e.buf = append(e.buf, '\n')
+	_, err := enc.w.Write(e.buf)
+	return err
+}
+
+type encodeState struct {
+	buf []byte
+	// w receives the output as it is produced, if it is not nil.
+	w          io.Writer
+	escapeHTML bool
+	// quoted is set while encoding a struct field with the ",string" option.
+	quoted bool
+	// checkEmpty is set while checking whether a struct field with the ",omitempty" option is empty. Encoders set empty
+	// and return, rather than encoding the value.
+	checkEmpty bool
+	empty      bool
+	cycles     walkutil.Cycles
+	scratch    bytes.Buffer
+}
+
+var encodeStatePool walkutil.Pool[encodeState]
+
+func newEncodeState(w io.Writer, escapeHTML bool) *encodeState {
+	e := encodeStatePool.Get()
+	e.w = w
+	e.escapeHTML = escapeHTML
+	return e
+}
+
+func (e *encodeState) release() {
+	e.buf = e.buf[:0]
+	e.w = nil
+	e.quoted = false
+	e.checkEmpty = false
+	e.cycles.Reset()
+	encodeStatePool.Put(e)
+}
+
+func (e *encodeState) marshal(v any) error {
+	if v == nil {
+		e.buf = append(e.buf, "null"...)
+		return nil
+	}
+	return encodeWalker.Walk(e, v)
+}
+
+// flush writes the output so far if it is large enough and e is writing to a stream.
+func (e *encodeState) flush() error {
+	if e.w == nil || len(e.buf) < flushSize {
+		return nil
+	}
+	_, err := e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
+
+// enter records that the pointer, map or slice v is being encoded, and returns an error if it is already being
+// encoded further up. If enter returns nil, leave must be called once v has been encoded.
+func (e *encodeState) enter(v func() reflect.Value) (walkutil.Key, error) {
+	var rv reflect.Value
+	key, ok := e.cycles.Enter(func() walkutil.Key {
+		rv = v()
+		key := walkutil.Key{P: rv.UnsafePointer()}
+		if rv.Kind() == reflect.Slice {
+			key.Len = rv.Len()
+		}
+		return key
+	})
+	if !ok {
+		return key, &json.UnsupportedValueError{Value: rv, Str: fmt.Sprintf("encountered a cycle via %s", rv.Type())}
+	}
+	return key, nil
+}
+
+func (e *encodeState) leave(key walkutil.Key) {
+	e.cycles.Leave(key)
+}
+
+func (e *encodeState) beginQuoted() bool {
+	if e.quoted {
+		e.buf = append(e.buf, '"')
+	}
+	return e.quoted
+}
+
+func (e *encodeState) endQuoted(quoted bool) {
+	if quoted {
+		e.buf = append(e.buf, '"')
+	}
+}
+
+func (e *encodeState) marshalJSON(v reflect.Value) error {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		e.buf = append(e.buf, "null"...)
+		return nil
+	}
+	m, ok := v.Interface().(json.Marshaler)
+	if !ok {
+		e.buf = append(e.buf, "null"...)
+		return nil
+	}
+	b, err := m.MarshalJSON()
+	if err == nil {
+		e.scratch.Reset()
+		err = json.Compact(&e.scratch, b)
+	}
+	if err != nil {
+		return &json.MarshalerError{Type: v.Type(), Err: err}
+	}
+	if e.escapeHTML {
+		var out bytes.Buffer
+		json.HTMLEscape(&out, e.scratch.Bytes())
+		e.buf = append(e.buf, out.Bytes()...)
+	} else {
+		e.buf = append(e.buf, e.scratch.Bytes()...)
+	}
+	return nil
+}
+
+func (e *encodeState) marshalText(v reflect.Value) error {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		e.buf = append(e.buf, "null"...)
+		return nil
+	}
+	m, ok := v.Interface().(encoding.TextMarshaler)
+	if !ok {
+		e.buf = append(e.buf, "null"...)
+		return nil
+	}
+	b, err := m.MarshalText()
+	if err != nil {
+		return &json.MarshalerError{Type: v.Type(), Err: err}
+	}
+	e.buf = appendString(e.buf, string(b), e.escapeHTML)
+	return nil
+}
+
+// compiled wraps enc, the encoder for values of type t, to report whether values are empty when checking ",omitempty"
+// fields, and to call MarshalJSON or MarshalText if t has either method. value returns the value of type t that a
+// refers to.
+func compiled[A any](
+	t reflect.Type,
+	enc func(*encodeState, A) error,
+	isEmpty func(A) bool,
+	value func(A) reflect.Value,
+) func(*encodeState, A) error {
+	enc = withMarshaler(t, enc, value)
+	return func(e *encodeState, a A) error {
+		if e.checkEmpty {
+			e.empty = isEmpty(a)
+			return nil
+		}
+		return enc(e, a)
+	}
+}
+
+func withMarshaler[A any](t reflect.Type, enc func(*encodeState, A) error, value func(A) reflect.Value) func(*encodeState, A) error {
+	// As in encoding/json, methods with pointer receivers are only called if the value is addressable.
+	isPtr := t.Kind() == reflect.Pointer
+	jsonAddr := !isPtr && reflect.PointerTo(t).Implements(marshalerType)
+	json := t.Implements(marshalerType)
+	textAddr := !isPtr && reflect.PointerTo(t).Implements(textMarshalerType)
+	text := t.Implements(textMarshalerType)
+	if !jsonAddr && !json && !textAddr && !text {
+		return enc
+	}
+	return func(e *encodeState, a A) error {
+		v := value(a)
+		switch {
+		case jsonAddr && v.CanAddr():
+			return e.marshalJSON(v.Addr())
+		case json:
+			return e.marshalJSON(v)
+		case textAddr && v.CanAddr():
+			return e.marshalText(v.Addr())
+		case text:
+			return e.marshalText(v)
+		}
+		return enc(e, a)
+	}
+}
+
+// argValue returns a function returning the value of type t that an Arg[T] refers to. The Arg's own Value has type T,
+// which differs from t if t is a named type.
+func argValue[T any](t reflect.Type) func(tw.Arg[T]) reflect.Value {
+	return func(a tw.Arg[T]) reflect.Value {
+		v := a.Value()
+		if v.CanAddr() {
+			return reflect.NewAt(t, v.Addr().UnsafePointer()).Elem()
+		}
+		return v.Convert(t)
+	}
+}
+
+func newEncodeRegister() *tw.Register[*encodeState] {
+	r := tw.NewRegister[*encodeState]()
+	tw.RegisterCompileBoolFn(r, compileBool)
+	tw.RegisterCompileIntFn(r, compileInt[int])
+	tw.RegisterCompileInt8Fn(r, compileInt[int8])
+	tw.RegisterCompileInt16Fn(r, compileInt[int16])
+	tw.RegisterCompileInt32Fn(r, compileInt[int32])
+	tw.RegisterCompileInt64Fn(r, compileInt[int64])
+	tw.RegisterCompileUintFn(r, compileUint[uint])
+	tw.RegisterCompileUint8Fn(r, compileUint[uint8])
+	tw.RegisterCompileUint16Fn(r, compileUint[uint16])
+	tw.RegisterCompileUint32Fn(r, compileUint[uint32])
+	tw.RegisterCompileUint64Fn(r, compileUint[uint64])
+	tw.RegisterCompileUintptrFn(r, compileUint[uintptr])
+	tw.RegisterCompileFloat32Fn(r, compileFloat[float32])
+	tw.RegisterCompileFloat64Fn(r, compileFloat[float64])
+	tw.RegisterCompileComplex64Fn(r, compileUnsupported[complex64])
+	tw.RegisterCompileComplex128Fn(r, compileUnsupported[complex128])
+	tw.RegisterCompileUnsafePointerFn(r, compileUnsupported[unsafe.Pointer])
+	tw.RegisterCompileStringFn(r, compileString)
+	tw.RegisterCompileStructFn(r, compileStruct)
+	tw.RegisterCompileArrayFn(r, compileArray)
+	tw.RegisterCompileSliceFn(r, compileSlice)
+	tw.RegisterCompilePtrFn(r, compilePtr)
+	tw.RegisterCompileMapFn(r, compileMap)
+	tw.RegisterCompileInterfaceFn(r, compileInterface)
+	return r
+}
+
+func compileBool(t reflect.Type) tw.WalkFn[*encodeState, bool] {
+	return compiled(t, func(e *encodeState, b tw.Bool) error {
+		quoted := e.beginQuoted()
+		e.buf = strconv.AppendBool(e.buf, b.Get())
+		e.endQuoted(quoted)
+		return nil
+	}, func(b tw.Bool) bool {
+		return !b.Get()
+	}, argValue[bool](t))
+}
+
+func compileInt[T int | int8 | int16 | int32 | int64](t reflect.Type) tw.WalkFn[*encodeState, T] {
+	return compiled(t, func(e *encodeState, i tw.Arg[T]) error {
+		quoted := e.beginQuoted()
+		e.buf = strconv.AppendInt(e.buf, int64(i.Get()), 10)
+		e.endQuoted(quoted)
+		return nil
+	}, func(i tw.Arg[T]) bool {
+		return i.Get() == 0
+	}, argValue[T](t))
+}
+
+func compileUint[T uint | uint8 | uint16 | uint32 | uint64 | uintptr](t reflect.Type) tw.WalkFn[*encodeState, T] {
+	return compiled(t, func(e *encodeState, u tw.Arg[T]) error {
+		quoted := e.beginQuoted()
+		e.buf = strconv.AppendUint(e.buf, uint64(u.Get()), 10)
+		e.endQuoted(quoted)
+		return nil
+	}, func(u tw.Arg[T]) bool {
+		return u.Get() == 0
+	}, argValue[T](t))
+}
+
+func compileFloat[T float32 | float64](t reflect.Type) tw.WalkFn[*encodeState, T] {
+	bits := int(unsafe.Sizeof(T(0)) * 8)
+	return compiled(t, func(e *encodeState, a tw.Arg[T]) error {
+		f := float64(a.Get())
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return &json.UnsupportedValueError{Value: argValue[T](t)(a), Str: strconv.FormatFloat(f, 'g', -1, bits)}
+		}
+		quoted := e.beginQuoted()
+		e.buf = appendFloat(e.buf, f, bits)
+		e.endQuoted(quoted)
+		return nil
+	}, func(a tw.Arg[T]) bool {
+		return a.Get() == 0
+	}, argValue[T](t))
+}
+
+// appendFloat formats f like ES6 number to string conversion, like encoding/json.
+func appendFloat(b []byte, f float64, bits int) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	// Use float32 comparisons for float32 values to get the cutoffs right.
+	if abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// Clean up e-09 to e-9.
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+func compileString(t reflect.Type) tw.WalkFn[*encodeState, string] {
+	isEmpty := func(s tw.String) bool {
+		return s.Get() == ""
+	}
+	if t == numberType {
+		return compiled(t, func(e *encodeState, s tw.String) error {
+			num := s.Get()
+			if num == "" {
+				num = "0"
+			}
+			if !isValidNumber(num) {
+				return fmt.Errorf("json: invalid number literal %q", num)
+			}
+			quoted := e.beginQuoted()
+			e.buf = append(e.buf, num...)
+			e.endQuoted(quoted)
+			return nil
+		}, isEmpty, argValue[string](t))
+	}
+	return compiled(t, func(e *encodeState, s tw.String) error {
+		if e.quoted {
+			e.scratch.Reset()
+			quoted := appendString(e.scratch.AvailableBuffer(), s.Get(), e.escapeHTML)
+			e.buf = appendString(e.buf, string(quoted), false)
+			return nil
+		}
+		e.buf = appendString(e.buf, s.Get(), e.escapeHTML)
+		return nil
+	}, isEmpty, argValue[string](t))
+}
+
+func compileUnsupported[T any](t reflect.Type) tw.WalkFn[*encodeState, T] {
+	return compiled(t, func(e *encodeState, a tw.Arg[T]) error {
+		return &json.UnsupportedTypeError{Type: t}
+	}, func(a tw.Arg[T]) bool {
+		return false
+	}, argValue[T](t))
+}
+
+// structField is a field encoded by a struct encoder.
+type structField struct {
+	field
+	// num is the index of the field in the StructFieldRegister.
+	num int
+	// prefix is the field's quoted name followed by a colon, and prefixHTML is the same with HTML escaping.
+	prefix, prefixHTML []byte
+	// unsupported is the type of a channel or function in the field's type, which can't be encoded.
+	unsupported reflect.Type
+}
+
+func compileStruct(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*encodeState] {
+	fields := typeFields(t)
+	structFields := make([]structField, len(fields))
+	for i, f := range fields {
+		sf := structField{
+			field:      f,
+			prefix:     append(appendString(nil, f.name, false), ':'),
+			prefixHTML: append(appendString(nil, f.name, true), ':'),
+		}
+		if sf.unsupported = walkutil.Unsupported(f.typ, nil); sf.unsupported == nil {
+			sf.num = sfr.RegisterFieldByIndex(f.index)
+		}
+		structFields[i] = sf
+	}
+
+	return compiled(t, func(e *encodeState, s tw.Struct[*encodeState]) error {
+		e.buf = append(e.buf, '{')
+		first := true
+		for i := range structFields {
+			f := &structFields[i]
+			if f.unsupported != nil {
+				return &json.UnsupportedTypeError{Type: f.unsupported}
+			}
+			sf := s.Field(f.num)
+			if !sf.IsValid() {
+				// The field is promoted through a nil embedded pointer.
+				continue
+			}
+			if f.omitEmpty {
+				e.checkEmpty = true
+				err := sf.Walk(e)
+				e.checkEmpty = false
+				if err != nil {
+					return err
+				}
+				if e.empty {
+					continue
+				}
+			}
+			if !first {
+				e.buf = append(e.buf, ',')
+			}
+			first = false
+			if e.escapeHTML {
+				e.buf = append(e.buf, f.prefixHTML...)
+			} else {
+				e.buf = append(e.buf, f.prefix...)
+			}
+			e.quoted = f.quoted
+			err := sf.Walk(e)
+			e.quoted = false
+			if err != nil {
+				return err
+			}
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, '}')
+		return nil
+	}, func(s tw.Struct[*encodeState]) bool {
+		return false
+	}, tw.Struct[*encodeState].Value)
+}
+
+func compileArray(t reflect.Type) tw.WalkArrayFn[*encodeState] {
+	return compiled(t, func(e *encodeState, a tw.Array[*encodeState]) error {
+		e.buf = append(e.buf, '[')
+		for i := 0; i < a.Len(); i++ {
+			if i > 0 {
+				e.buf = append(e.buf, ',')
+			}
+			if err := a.Elem(i).Walk(e); err != nil {
+				return err
+			}
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, ']')
+		return nil
+	}, func(a tw.Array[*encodeState]) bool {
+		return a.Len() == 0
+	}, tw.Array[*encodeState].Value)
+}
+
+func compileSlice(t reflect.Type) tw.WalkSliceFn[*encodeState] {
+	isEmpty := func(s tw.Slice[*encodeState]) bool {
+		return s.Len() == 0
+	}
+	elem := t.Elem()
+	if elem.Kind() == reflect.Uint8 {
+		p := reflect.PointerTo(elem)
+		if !p.Implements(marshalerType) && !p.Implements(textMarshalerType) {
+			return compiled(t, encodeByteSlice, isEmpty, tw.Slice[*encodeState].Value)
+		}
+	}
+	return compiled(t, func(e *encodeState, s tw.Slice[*encodeState]) error {
+		if s.IsNil() {
+			e.buf = append(e.buf, "null"...)
+			return nil
+		}
+		key, err := e.enter(s.Value)
+		if err != nil {
+			return err
+		}
+		defer e.leave(key)
+		e.buf = append(e.buf, '[')
+		for i := 0; i < s.Len(); i++ {
+			if i > 0 {
+				e.buf = append(e.buf, ',')
+			}
+			if err := s.Elem(i).Walk(e); err != nil {
+				return err
+			}
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, ']')
+		return nil
+	}, isEmpty, tw.Slice[*encodeState].Value)
+}
+
+func encodeByteSlice(e *encodeState, s tw.Slice[*encodeState]) error {
+	if s.IsNil() {
+		e.buf = append(e.buf, "null"...)
+		return nil
+	}
+	b, ok := tw.SliceAs[byte](s)
+	if !ok {
+		// The elements have a named byte type.
+		b = s.Value().Bytes()
+	}
+	n := len(e.buf) + 1
+	e.buf = slices.Grow(e.buf, base64.StdEncoding.EncodedLen(len(b))+2)
+	e.buf = e.buf[:n+base64.StdEncoding.EncodedLen(len(b))]
+	e.buf[n-1] = '"'
+	base64.StdEncoding.Encode(e.buf[n:], b)
+	e.buf = append(e.buf, '"')
+	return nil
+}
+
+func compilePtr(t reflect.Type) tw.WalkPtrFn[*encodeState] {
+	return compiled(t, func(e *encodeState, p tw.Ptr[*encodeState]) error {
+		if p.IsNil() {
+			e.buf = append(e.buf, "null"...)
+			return nil
+		}
+		key, err := e.enter(p.Value)
+		if err != nil {
+			return err
+		}
+		defer e.leave(key)
+		return p.Walk(e)
+	}, tw.Ptr[*encodeState].IsNil, tw.Ptr[*encodeState].Value)
+}
+
+func compileInterface(t reflect.Type) tw.WalkInterfaceFn[*encodeState] {
+	return compiled(t, func(e *encodeState, i tw.Interface[*encodeState]) error {
+		if i.IsNil() {
+			e.buf = append(e.buf, "null"...)
+			return nil
+		}
+		return i.Walk(e)
+	}, tw.Interface[*encodeState].IsNil, tw.Interface[*encodeState].Value)
+}
+
+func compileMap(t reflect.Type) tw.WalkMapFn[*encodeState] {
+	isEmpty := func(m tw.Map[*encodeState]) bool {
+		return m.IsNil() || m.Value().Len() == 0
+	}
+	key := t.Key()
+	switch key.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		if !key.Implements(textMarshalerType) {
+			return compiled(t, func(e *encodeState, m tw.Map[*encodeState]) error {
+				return &json.UnsupportedTypeError{Type: t}
+			}, isEmpty, tw.Map[*encodeState].Value)
+		}
+	}
+
+	type entry struct {
+		key string
+		val tw.MapValue[*encodeState]
+	}
+	return compiled(t, func(e *encodeState, m tw.Map[*encodeState]) error {
+		if m.IsNil() {
+			e.buf = append(e.buf, "null"...)
+			return nil
+		}
+		k, err := e.enter(m.Value)
+		if err != nil {
+			return err
+		}
+		defer e.leave(k)
+
+		var entries []entry
+		iter := m.Iter()
+		for iter.Next() {
+			en := iter.Entry()
+			name, err := resolveKeyName(reflect.ValueOf(en.Key().Interface()))
+			if err != nil {
+				return &json.MarshalerError{Type: key, Err: err}
+			}
+			entries = append(entries, entry{key: name, val: en.Value()})
+		}
+		slices.SortFunc(entries, func(a, b entry) int {
+			return strings.Compare(a.key, b.key)
+		})
+
+		e.buf = append(e.buf, '{')
+		for i, en := range entries {
+			if i > 0 {
+				e.buf = append(e.buf, ',')
+			}
+			e.buf = appendString(e.buf, en.key, e.escapeHTML)
+			e.buf = append(e.buf, ':')
+			if err := en.val.Walk(e); err != nil {
+				return err
+			}
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, '}')
+		return nil
+	}, isEmpty, tw.Map[*encodeState].Value)
+}
+
+func resolveKeyName(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	panic("unexpected map key type")
+}
+
+const hex = "0123456789abcdef"
+
+// appendString appends s to dst as a quoted JSON string, like encoding/json.
+func appendString(dst []byte, s string, escapeHTML bool) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' && (!escapeHTML || b != '<' && b != '>' && b != '&') {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '\\', '"':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				// Other control characters, and <, > and & when escaping HTML.
+				dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid in JSON but not in JavaScript, so they are always escaped.
+		if c == '\u2028' || c == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// isValidNumber reports whether s is a valid JSON number literal.
+func isValidNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	if s[0] == '-' {
+		s = s[1:]
+		if s == "" {
+			return false
+		}
+	}
+	switch {
+	case s[0] == '0':
+		s = s[1:]
+	case '1' <= s[0] && s[0] <= '9':
+		s = s[1:]
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	default:
+		return false
+	}
+	if len(s) >= 2 && s[0] == '.' && '0' <= s[1] && s[1] <= '9' {
+		s = s[2:]
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+	if len(s) >= 2 && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if s[0] == '+' || s[0] == '-' {
+			s = s[1:]
+			if s == "" {
+				return false
+			}
+		}
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+	return s == ""
+}
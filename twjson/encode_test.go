@@ -0,0 +1,342 @@
+package twjson_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zolstein/type-walk/twjson"
+)
+
+type Celsius float64
+
+type Color int
+
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("color-%d", int(c))), nil
+}
+
+func (c *Color) UnmarshalText(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "color-%d", (*int)(c))
+	return err
+}
+
+type Point struct {
+	X, Y int
+}
+
+func (p Point) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`[ %d, %d ]`, p.X, p.Y)), nil
+}
+
+func (p *Point) UnmarshalJSON(b []byte) error {
+	var xy [2]int
+	if err := json.Unmarshal(b, &xy); err != nil {
+		return err
+	}
+	p.X, p.Y = xy[0], xy[1]
+	return nil
+}
+
+type PtrMarshaler struct {
+	V string
+}
+
+func (p *PtrMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`"ptr:` + p.V + `"`), nil
+}
+
+type HTMLMarshaler struct{}
+
+func (HTMLMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`"<b>&amp;</b>"`), nil
+}
+
+type FailingMarshaler struct{}
+
+func (FailingMarshaler) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("failed")
+}
+
+type InvalidMarshaler struct{}
+
+func (InvalidMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`{`), nil
+}
+
+type Base struct {
+	ID   int
+	Name string `json:"name"`
+}
+
+type Audit struct {
+	Created time.Time
+	Name    string
+}
+
+type unexportedEmbedded struct {
+	Hidden string
+}
+
+type Tagged struct {
+	Base
+	*Audit
+	unexportedEmbedded
+	Renamed   string         `json:"renamed_field"`
+	Omitted   string         `json:"-"`
+	Dash      string         `json:"-,"`
+	Empty     string         `json:",omitempty"`
+	EmptyPtr  *int           `json:",omitempty"`
+	EmptyMap  map[string]int `json:",omitempty"`
+	EmptySl   []int          `json:",omitempty"`
+	EmptyArr  [0]int         `json:",omitempty"`
+	EmptyIf   any            `json:",omitempty"`
+	Zero      float64        `json:",omitempty"`
+	NegZero   float64        `json:",omitempty"`
+	Struct    struct{}       `json:",omitempty"`
+	QuotedInt int            `json:",string"`
+	QuotedPtr *float64       `json:",string"`
+	QuotedStr string         `json:",string"`
+	QuotedB   bool           `json:"qb,string,omitempty"`
+	NotQuoted []int          `json:",string"`
+	private   int
+	Func      func()            `json:"-"`
+	Chan      chan int          `json:"-"`
+	Nested    map[string][]*int `json:"nested"`
+}
+
+type Conflict1 struct {
+	A int
+	B int
+}
+
+type Conflict2 struct {
+	A int
+	B int `json:"B"`
+}
+
+type Conflicts struct {
+	Conflict1
+	Conflict2
+}
+
+type Node struct {
+	Val  int
+	Next *Node `json:",omitempty"`
+}
+
+func corpus() []any {
+	negZero := math.Copysign(0, -1)
+	f := 1.5
+	n := 0
+	ptr := &PtrMarshaler{V: "x"}
+	return []any{
+		nil,
+		true,
+		false,
+		0,
+		-12345,
+		int8(-8),
+		uint64(math.MaxUint64),
+		uintptr(7),
+		1.0,
+		-0.0,
+		negZero,
+		1e21,
+		1e-7,
+		123456789.125,
+		float32(3.14),
+		float32(1e-7),
+		Celsius(21.5),
+		"",
+		"hello, world",
+		"quote \" backslash \\ slash / tab \t newline \n cr \r bs \b ff \f",
+		"control \x00 \x01 \x1f del \x7f",
+		"html <b>&amp;</b>",
+		"unicode é \u2028 \u2029 \U0001F600",
+		json.Number("12.5e3"),
+		json.Number(""),
+		[]byte(nil),
+		[]byte{},
+		[]byte("bytes\x00\xff"),
+		[3]byte{1, 2, 3},
+		[]int(nil),
+		[]int{},
+		[]int{1, 2, 3},
+		[2][]string{{"a"}, nil},
+		[]any{1, "a", nil, []int{1}, map[string]int{"x": 1}},
+		map[string]int(nil),
+		map[string]int{},
+		map[string]int{"b": 2, "a": 1, "<": 3},
+		map[int]string{10: "ten", -1: "minus one", 2: "two"},
+		map[uint8]bool{255: true, 0: false},
+		map[Color]int{1: 1, 2: 2},
+		map[string]any{"x": []any{map[string]any{"y": nil}}},
+		Point{1, 2},
+		&Point{3, 4},
+		[]Point{{5, 6}},
+		Color(3),
+		[]Color{1, 2},
+		PtrMarshaler{V: "not addressable"},
+		ptr,
+		[]PtrMarshaler{{V: "addressable"}},
+		[]*PtrMarshaler{ptr, nil},
+		HTMLMarshaler{},
+		time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		net.IPv4(127, 0, 0, 1),
+		(*int)(nil),
+		&n,
+		&f,
+		Tagged{},
+		Tagged{
+			Base:               Base{ID: 1, Name: "base"},
+			Audit:              &Audit{Created: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Name: "audit"},
+			unexportedEmbedded: unexportedEmbedded{Hidden: "hidden"},
+			Renamed:            "renamed",
+			Omitted:            "omitted",
+			Dash:               "dash",
+			Empty:              "not empty",
+			EmptyPtr:           &n,
+			EmptyMap:           map[string]int{"a": 1},
+			EmptySl:            []int{1},
+			EmptyIf:            0,
+			Zero:               1,
+			NegZero:            negZero,
+			QuotedInt:          42,
+			QuotedPtr:          &f,
+			QuotedStr:          "quoted <str>",
+			QuotedB:            true,
+			NotQuoted:          []int{1},
+			private:            1,
+			Nested:             map[string][]*int{"a": {&n, nil}},
+		},
+		Conflicts{Conflict1{1, 2}, Conflict2{3, 4}},
+		&Node{Val: 1, Next: &Node{Val: 2}},
+		struct {
+			Conflict1
+			A string
+		}{Conflict1{1, 2}, "shallower"},
+		struct {
+			I  any
+			S  fmt.Stringer
+			Ms map[string]Point
+		}{I: &Point{1, 1}, Ms: map[string]Point{"p": {2, 2}}},
+	}
+}
+
+func TestMarshalEquivalence(t *testing.T) {
+	for i, v := range corpus() {
+		t.Run(fmt.Sprintf("%d_%T", i, v), func(t *testing.T) {
+			expected, err := json.Marshal(v)
+			require.NoError(t, err)
+			actual, err := twjson.Marshal(v)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), string(actual))
+		})
+	}
+}
+
+func TestEncoderEquivalence(t *testing.T) {
+	for _, escapeHTML := range []bool{true, false} {
+		var expected, actual bytes.Buffer
+		jsonEnc := json.NewEncoder(&expected)
+		jsonEnc.SetEscapeHTML(escapeHTML)
+		enc := twjson.NewEncoder(&actual)
+		enc.SetEscapeHTML(escapeHTML)
+		for _, v := range corpus() {
+			require.NoError(t, jsonEnc.Encode(v))
+			require.NoError(t, enc.Encode(v))
+		}
+		assert.Equal(t, expected.String(), actual.String())
+	}
+}
+
+func TestMarshalInvalidUTF8(t *testing.T) {
+	// encoding/json escapes the replacement for invalid UTF-8 unless it's built with GOEXPERIMENT=jsonv2, which writes
+	// it literally, so this isn't checked against it.
+	actual, err := twjson.Marshal([]string{"invalid \xff utf8", "\xed\xa0\x80"})
+	require.NoError(t, err)
+	assert.Equal(t, `["invalid \ufffd utf8","\ufffd\ufffd\ufffd"]`, string(actual))
+}
+
+// chunkWriter records the size of each write.
+type chunkWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestEncoderStreams(t *testing.T) {
+	v := make([]string, 10000)
+	for i := range v {
+		v[i] = strings.Repeat("x", i%100)
+	}
+	expected, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	var w chunkWriter
+	require.NoError(t, twjson.NewEncoder(&w).Encode(v))
+	assert.Equal(t, string(expected)+"\n", w.String())
+	assert.Greater(t, w.writes, 10)
+}
+
+func TestMarshalErrors(t *testing.T) {
+	type Cycle struct {
+		Self *Cycle
+	}
+	selfRef := &Cycle{}
+	selfRef.Self = selfRef
+
+	tests := []struct {
+		name string
+		v    any
+		err  any
+	}{
+		{"nan", math.NaN(), new(*json.UnsupportedValueError)},
+		{"inf", math.Inf(1), new(*json.UnsupportedValueError)},
+		{"complex", complex(1, 2), new(*json.UnsupportedTypeError)},
+		{"funcField", struct{ F func() }{}, new(*json.UnsupportedTypeError)},
+		{"chanField", struct{ C []chan int }{}, new(*json.UnsupportedTypeError)},
+		{"mapKey", map[[2]int]int{{1, 2}: 3}, new(*json.UnsupportedTypeError)},
+		{"marshalerError", FailingMarshaler{}, new(*json.MarshalerError)},
+		{"invalidMarshaler", InvalidMarshaler{}, new(*json.MarshalerError)},
+		{"cycle", selfRef, new(*json.UnsupportedValueError)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// encoding/json fails for each of these too, but the errors for some depend on whether it's built with
+			// GOEXPERIMENT=jsonv2, so only the error types used by both are checked.
+			_, err := twjson.Marshal(test.v)
+			require.Error(t, err)
+			assert.ErrorAs(t, err, test.err)
+		})
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	v := corpus()
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = json.Marshal(v)
+		}
+	})
+	b.Run("twjson", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = twjson.Marshal(v)
+		}
+	})
+}
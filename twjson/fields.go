@@ -0,0 +1,169 @@
+package twjson
+
+import (
+	"cmp"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// field describes a struct field encoded as a JSON object member, following the rules of encoding/json.
+type field struct {
+	name string
+	// index is the index of the field within the struct, which may go through embedded structs.
+	index []int
+	typ   reflect.Type
+	// tagged is true if the name came from the field's json tag.
+	tagged    bool
+	omitEmpty bool
+	// quoted is true if the field has the ",string" option and a type it applies to.
+	quoted bool
+}
+
+// typeFields returns the fields of struct type t that encoding/json would encode, in the order it would encode them.
+// Fields of embedded structs are promoted, and conflicting names are resolved the same way as Go resolves them, except
+// that a field with a json tag dominates untagged fields at the same depth.
+func typeFields(t reflect.Type) []field {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var fields []field
+	next := []embedded{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	for len(next) > 0 {
+		current := next
+		next = nil
+		for _, e := range current {
+			// A type embedded at a shallower depth hides all of its fields at this depth.
+			if visited[e.typ] {
+				continue
+			}
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Pointer {
+						ft = ft.Elem()
+					}
+					// Embedded structs are promoted even if they are unexported, but other unexported embedded types
+					// are ignored.
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				if !isValidTag(name) {
+					name = ""
+				}
+				index := append(slices.Clip(e.index), i)
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{typ: ft, index: index})
+					continue
+				}
+
+				f := field{
+					name:      name,
+					index:     index,
+					typ:       sf.Type,
+					tagged:    name != "",
+					omitEmpty: hasOption(opts, "omitempty"),
+				}
+				if f.name == "" {
+					f.name = sf.Name
+				}
+				if hasOption(opts, "string") {
+					switch ft.Kind() {
+					case reflect.Bool,
+						reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+						reflect.Float32, reflect.Float64,
+						reflect.String:
+						f.quoted = true
+					}
+				}
+				fields = append(fields, f)
+			}
+		}
+		// Types embedded more than once at the same depth are walked each time, so their fields conflict with each
+		// other and are dropped.
+		for _, e := range current {
+			visited[e.typ] = true
+		}
+	}
+
+	// Sort by name, then from the most to the least dominant field, so the dominant field is first in each run of
+	// fields with the same name.
+	slices.SortStableFunc(fields, func(a, b field) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(len(a.index), len(b.index)); c != 0 {
+			return c
+		}
+		if a.tagged != b.tagged {
+			if a.tagged {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(a.index, b.index)
+	})
+	dominant := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		// The first field is hidden by the second if they are equally dominant.
+		if j == i+1 || len(fields[i+1].index) > len(fields[i].index) || fields[i].tagged && !fields[i+1].tagged {
+			dominant = append(dominant, fields[i])
+		}
+		i = j
+	}
+	slices.SortFunc(dominant, func(a, b field) int {
+		return slices.Compare(a.index, b.index)
+	})
+	return dominant
+}
+
+func hasOption(opts string, option string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidTag reports whether s can be used as the name of a field in a json tag.
+func isValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+			// Backslash and quote characters are reserved, but any other punctuation is allowed.
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
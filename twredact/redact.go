@@ -0,0 +1,379 @@
+// Package twredact redacts sensitive values, e.g. before logging them, using type-walk Walkers.
+//
+// A Redactor is configured with a struct tag key, whose fields tagged "true" are sensitive, e.g. `sensitive:"true"`
+// for WithTag("sensitive"), and with a list of types whose values are sensitive wherever they appear.
+//
+// Sensitive strings are replaced with a mask, "[REDACTED]" by default. Other sensitive values are set to zero: numbers
+// and booleans become 0 and false, and structs, arrays, slices, maps, pointers and interfaces are replaced with their
+// zero values. A pointer to a value of a sensitive type isn't itself sensitive, so the value it points to is redacted
+// instead. Sensitive values are found inside structs, arrays, slices, pointers, interfaces, and the values, but not
+// the keys, of maps. Channels and functions are never walked, though fields of their types can be tagged.
+//
+// Redact redacts a value in place, so it modifies everything reachable from it, including values shared with others.
+// Copy redacts a deep copy of a value, made with twcopy, leaving the original unchanged.
+package twredact
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+	"github.com/zolstein/type-walk/twcopy"
+)
+
+// DefaultMask is the mask which replaces sensitive strings, unless WithMask is used.
+const DefaultMask = "[REDACTED]"
+
+type config struct {
+	tag   string
+	types map[reflect.Type]bool
+	mask  string
+}
+
+// Option configures a Redactor.
+type Option func(*config)
+
+// WithTag makes struct fields whose tag for key is "true" sensitive.
+func WithTag(key string) Option {
+	return func(c *config) {
+		c.tag = key
+	}
+}
+
+// WithTypes makes values of types sensitive, wherever they appear.
+func WithTypes(types ...reflect.Type) Option {
+	return func(c *config) {
+		for _, t := range types {
+			c.types[t] = true
+		}
+	}
+}
+
+// WithMask replaces sensitive strings with mask, instead of DefaultMask.
+func WithMask(mask string) Option {
+	return func(c *config) {
+		c.mask = mask
+	}
+}
+
+// A Redactor redacts values. It is safe for concurrent use.
+type Redactor struct {
+	cfg    config
+	walker *tw.Walker[*state]
+	// affected caches whether values of each type can contain sensitive values.
+	affected sync.Map
+}
+
+// NewRedactor returns a Redactor configured by opts.
+func NewRedactor(opts ...Option) *Redactor {
+	cfg := config{types: map[reflect.Type]bool{}, mask: DefaultMask}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	r := &Redactor{cfg: cfg}
+	r.walker = tw.NewWalker(r.newRegister(), tw.WithThreadSafe)
+	return r
+}
+
+// Redact redacts the value p points to in place. It does nothing if p is nil.
+func Redact[T any](r *Redactor, p *T) {
+	if p == nil {
+		return
+	}
+	r.redact(reflect.ValueOf(p).Elem())
+}
+
+// Copy returns a redacted deep copy of v.
+func Copy[T any](r *Redactor, v T) T {
+	c := twcopy.Copy(v)
+	Redact(r, &c)
+	return c
+}
+
+// redact redacts v, which must be addressable.
+func (r *Redactor) redact(v reflect.Value) {
+	if !r.canContainSensitive(v.Type()) {
+		return
+	}
+	s := newState(r)
+	defer s.release()
+	s.redact(v)
+}
+
+type state struct {
+	r *Redactor
+	// sensitive is set while walking a sensitive value, e.g. a tagged struct field.
+	sensitive bool
+	// seen holds the pointers, slices and maps which have been redacted, so they're only redacted once, which also
+	// stops cycles.
+	seen walkutil.Set
+}
+
+var statePool walkutil.Pool[state]
+
+func newState(r *Redactor) *state {
+	s := statePool.Get()
+	s.r = r
+	return s
+}
+
+func (s *state) release() {
+	s.r = nil
+	s.sensitive = false
+	s.seen.Clear()
+	statePool.Put(s)
+}
+
+// redact redacts v, which must be addressable.
+func (s *state) redact(v reflect.Value) {
+	if err := s.r.walker.WalkValue(s, v); err != nil && s.r.sensitiveType(v.Type()) {
+		// The Walker can't compile a function for v's type, which contains a channel or function, but v is sensitive,
+		// e.g. a listed function type in an interface.
+		v.SetZero()
+	}
+}
+
+func (r *Redactor) sensitiveType(t reflect.Type) bool {
+	return r.cfg.types[t]
+}
+
+func (r *Redactor) sensitiveField(f reflect.StructField) bool {
+	return r.cfg.tag != "" && f.Tag.Get(r.cfg.tag) == "true" || r.sensitiveType(f.Type)
+}
+
+// canContainSensitive reports whether values of type t can contain sensitive values, so they need to be walked.
+func (r *Redactor) canContainSensitive(t reflect.Type) bool {
+	if affected, ok := r.affected.Load(t); ok {
+		return affected.(bool)
+	}
+	affected := r.containsSensitive(t, map[reflect.Type]bool{})
+	r.affected.Store(t, affected)
+	return affected
+}
+
+func (r *Redactor) containsSensitive(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if r.sensitiveType(t) {
+		return true
+	}
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		return r.cfg.tag != "" || len(r.cfg.types) > 0
+	case reflect.Array, reflect.Slice, reflect.Pointer, reflect.Map:
+		return r.containsSensitive(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); r.sensitiveField(f) || r.containsSensitive(f.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Redactor) newRegister() *tw.Register[*state] {
+	reg := tw.NewRegister[*state]()
+	tw.RegisterCompileBoolFn(reg, compileZero[bool](r))
+	tw.RegisterCompileIntFn(reg, compileZero[int](r))
+	tw.RegisterCompileInt8Fn(reg, compileZero[int8](r))
+	tw.RegisterCompileInt16Fn(reg, compileZero[int16](r))
+	tw.RegisterCompileInt32Fn(reg, compileZero[int32](r))
+	tw.RegisterCompileInt64Fn(reg, compileZero[int64](r))
+	tw.RegisterCompileUintFn(reg, compileZero[uint](r))
+	tw.RegisterCompileUint8Fn(reg, compileZero[uint8](r))
+	tw.RegisterCompileUint16Fn(reg, compileZero[uint16](r))
+	tw.RegisterCompileUint32Fn(reg, compileZero[uint32](r))
+	tw.RegisterCompileUint64Fn(reg, compileZero[uint64](r))
+	tw.RegisterCompileUintptrFn(reg, compileZero[uintptr](r))
+	tw.RegisterCompileFloat32Fn(reg, compileZero[float32](r))
+	tw.RegisterCompileFloat64Fn(reg, compileZero[float64](r))
+	tw.RegisterCompileComplex64Fn(reg, compileZero[complex64](r))
+	tw.RegisterCompileComplex128Fn(reg, compileZero[complex128](r))
+	tw.RegisterCompileUnsafePointerFn(reg, compileZero[unsafe.Pointer](r))
+	tw.RegisterCompileStringFn(reg, r.compileString)
+	tw.RegisterCompileStructFn(reg, r.compileStruct)
+	tw.RegisterCompileArrayFn(reg, r.compileArray)
+	tw.RegisterCompileSliceFn(reg, r.compileSlice)
+	tw.RegisterCompilePtrFn(reg, r.compilePtr)
+	tw.RegisterCompileMapFn(reg, r.compileMap)
+	tw.RegisterCompileInterfaceFn(reg, r.compileInterface)
+	return reg
+}
+
+// Every value the Redactor walks is settable, since values are walked through pointers, and the values of map entries
+// and interfaces are copied to be walked. Each function redacts the whole value if its type is sensitive or it's walked
+// as part of a sensitive value, and otherwise only walks the parts of it which can contain sensitive values.
+
+func compileZero[T any](r *Redactor) tw.CompileFn[*state, T] {
+	return func(t reflect.Type) tw.WalkFn[*state, T] {
+		listed := r.sensitiveType(t)
+		return func(s *state, a tw.Arg[T]) error {
+			if listed || s.sensitive {
+				var zero T
+				a.Set(zero)
+			}
+			return nil
+		}
+	}
+}
+
+func (r *Redactor) compileString(t reflect.Type) tw.WalkFn[*state, string] {
+	listed := r.sensitiveType(t)
+	return func(s *state, str tw.String) error {
+		if listed || s.sensitive {
+			str.Set(r.cfg.mask)
+		}
+		return nil
+	}
+}
+
+// structField is a field which a struct function redacts or walks.
+type structField struct {
+	// num is the index of the field in the StructFieldRegister.
+	num       int
+	sensitive bool
+	// unsupported is set if the field's type contains a channel or function, which the Walker can't compile, so the
+	// field is set to zero with reflect if it's sensitive, and ignored otherwise.
+	unsupported bool
+	typ         reflect.Type
+	offset      uintptr
+}
+
+func (r *Redactor) compileStruct(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*state] {
+	listed := r.sensitiveType(t)
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		sf := structField{
+			sensitive:   r.sensitiveField(f),
+			unsupported: walkutil.Unsupported(f.Type, nil) != nil,
+			typ:         f.Type,
+			offset:      f.Offset,
+		}
+		if !sf.sensitive && (sf.unsupported || !r.canContainSensitive(f.Type)) {
+			continue
+		}
+		if !sf.unsupported {
+			sf.num = sfr.RegisterField(i)
+		}
+		fields = append(fields, sf)
+	}
+
+	return func(s *state, st tw.Struct[*state]) error {
+		if listed || s.sensitive {
+			st.Value().SetZero()
+			return nil
+		}
+		for i := range fields {
+			f := &fields[i]
+			if f.unsupported {
+				// The field is set through a pointer, since reflect can't set unexported fields.
+				p := unsafe.Add(st.Value().Addr().UnsafePointer(), f.offset)
+				reflect.NewAt(f.typ, p).Elem().SetZero()
+				continue
+			}
+			s.sensitive = f.sensitive
+			err := st.Field(f.num).Walk(s)
+			s.sensitive = false
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (r *Redactor) compileArray(t reflect.Type) tw.WalkArrayFn[*state] {
+	listed := r.sensitiveType(t)
+	walkElems := r.canContainSensitive(t.Elem())
+	return func(s *state, a tw.Array[*state]) error {
+		if listed || s.sensitive {
+			a.Value().SetZero()
+			return nil
+		}
+		if !walkElems {
+			return nil
+		}
+		return a.WalkAll(s)
+	}
+}
+
+func (r *Redactor) compileSlice(t reflect.Type) tw.WalkSliceFn[*state] {
+	listed := r.sensitiveType(t)
+	walkElems := r.canContainSensitive(t.Elem())
+	return func(s *state, sl tw.Slice[*state]) error {
+		if listed || s.sensitive {
+			sl.SetNil()
+			return nil
+		}
+		if !walkElems || sl.Len() == 0 || !s.seen.Add(walkutil.Key{P: sl.Value().UnsafePointer(), Len: sl.Len(), Type: t}) {
+			return nil
+		}
+		return sl.WalkAll(s)
+	}
+}
+
+func (r *Redactor) compilePtr(t reflect.Type) tw.WalkPtrFn[*state] {
+	listed := r.sensitiveType(t)
+	return func(s *state, p tw.Ptr[*state]) error {
+		if listed || s.sensitive {
+			p.SetNil()
+			return nil
+		}
+		if p.IsNil() || !s.seen.Add(walkutil.Key{P: walkutil.PointerOf(p.Interface()), Type: t}) {
+			return nil
+		}
+		return p.Walk(s)
+	}
+}
+
+func (r *Redactor) compileMap(t reflect.Type) tw.WalkMapFn[*state] {
+	listed := r.sensitiveType(t)
+	return func(s *state, m tw.Map[*state]) error {
+		if listed || s.sensitive {
+			m.SetNil()
+			return nil
+		}
+		if m.IsNil() || !s.seen.Add(walkutil.Key{P: walkutil.PointerOf(m.Interface()), Type: t}) {
+			return nil
+		}
+		// Map values aren't addressable, so each is copied, redacted, and set back.
+		iter := m.Value().MapRange()
+		for iter.Next() {
+			v := reflect.New(t.Elem()).Elem()
+			v.Set(iter.Value())
+			s.redact(v)
+			m.SetIndex(iter.Key(), v)
+		}
+		return nil
+	}
+}
+
+func (r *Redactor) compileInterface(t reflect.Type) tw.WalkInterfaceFn[*state] {
+	listed := r.sensitiveType(t)
+	return func(s *state, i tw.Interface[*state]) error {
+		if listed || s.sensitive {
+			i.Value().SetZero()
+			return nil
+		}
+		if i.IsNil() {
+			return nil
+		}
+		concrete := i.Interface()
+		ct := reflect.TypeOf(concrete)
+		if !r.canContainSensitive(ct) {
+			return nil
+		}
+		// The concrete value isn't addressable, so it's copied, redacted, and set back.
+		v := reflect.New(ct).Elem()
+		v.Set(reflect.ValueOf(concrete))
+		s.redact(v)
+		i.Value().Set(v)
+		return nil
+	}
+}
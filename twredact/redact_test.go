@@ -0,0 +1,176 @@
+package twredact_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zolstein/type-walk/twredact"
+)
+
+type Password string
+
+type Card struct {
+	Number string
+	CVV    int
+}
+
+type Login struct {
+	User     string
+	Password Password
+	Token    string `sensitive:"true"`
+	PIN      int    `sensitive:"true"`
+	Remember bool   `sensitive:"true"`
+	Cards    []Card `sensitive:"true"`
+	Notify   func() `sensitive:"true"`
+	Attempts int
+}
+
+type Request struct {
+	Login    *Login
+	Headers  map[string]any
+	Params   []any
+	Card     Card
+	Backup   *Card
+	Accounts map[string]Card
+	Secrets  [2]Password
+	secret   Password
+	Next     *Request
+}
+
+func newRequest() *Request {
+	login := &Login{
+		User:     "user",
+		Password: "hunter2",
+		Token:    "abc",
+		PIN:      1234,
+		Remember: true,
+		Cards:    []Card{{Number: "4111", CVV: 123}},
+		Notify:   func() {},
+		Attempts: 3,
+	}
+	return &Request{
+		Login: login,
+		Headers: map[string]any{
+			"login": *login,
+			"user":  "user",
+			"pass":  Password("pw"),
+			"nil":   nil,
+		},
+		Params:   []any{Password("pw"), 1, login, []any{Card{Number: "4111"}}},
+		Card:     Card{Number: "5500", CVV: 456},
+		Backup:   &Card{Number: "3400"},
+		Accounts: map[string]Card{"a": {Number: "6011", CVV: 789}},
+		Secrets:  [2]Password{"a", "b"},
+		secret:   "s",
+	}
+}
+
+func newRedactor(opts ...twredact.Option) *twredact.Redactor {
+	opts = append([]twredact.Option{
+		twredact.WithTag("sensitive"),
+		twredact.WithTypes(reflect.TypeOf(Password("")), reflect.TypeOf(Card{})),
+	}, opts...)
+	return twredact.NewRedactor(opts...)
+}
+
+func redactedLogin() Login {
+	return Login{
+		User:     "user",
+		Password: twredact.DefaultMask,
+		Token:    twredact.DefaultMask,
+		Attempts: 3,
+	}
+}
+
+func TestRedact(t *testing.T) {
+	r := newRedactor()
+	req := newRequest()
+	login := req.Login
+	twredact.Redact(r, req)
+
+	expectedLogin := redactedLogin()
+	assert.Equal(t, &Request{
+		Login: &expectedLogin,
+		Headers: map[string]any{
+			"login": expectedLogin,
+			"user":  "user",
+			"pass":  Password(twredact.DefaultMask),
+			"nil":   nil,
+		},
+		Params:   []any{Password(twredact.DefaultMask), 1, &expectedLogin, []any{Card{}}},
+		Backup:   &Card{},
+		Accounts: map[string]Card{"a": {}},
+		Secrets:  [2]Password{twredact.DefaultMask, twredact.DefaultMask},
+		secret:   twredact.DefaultMask,
+	}, req)
+	// Values are redacted in place.
+	assert.Same(t, login, req.Login)
+	assert.Same(t, login, req.Params[2])
+}
+
+func TestCopy(t *testing.T) {
+	r := newRedactor(twredact.WithMask("***"))
+	req := newRequest()
+	actual := twredact.Copy(r, req)
+
+	assert.Equal(t, newRequest().Login.Token, req.Login.Token)
+	assert.Equal(t, Password("pw"), req.Headers["pass"])
+	assert.Equal(t, "***", actual.Login.Token)
+	assert.Equal(t, Password("***"), actual.Headers["pass"])
+	assert.Same(t, actual.Login, actual.Params[2])
+	assert.Equal(t, "user", actual.Login.User)
+
+	assert.Equal(t, "user", twredact.Copy(r, "user"))
+	assert.Equal(t, Password("***"), twredact.Copy(r, Password("pw")))
+	assert.Equal(t, []any{Password("***"), "x"}, twredact.Copy(r, []any{Password("pw"), "x"}))
+}
+
+func TestRedactCycles(t *testing.T) {
+	r := newRedactor()
+	req := newRequest()
+	req.Next = req
+	req.Params = append(req.Params, req.Params)
+	twredact.Redact(r, req)
+	assert.Equal(t, twredact.DefaultMask, req.Login.Token)
+	assert.Equal(t, Password(twredact.DefaultMask), req.Params[0])
+}
+
+func TestRedactTagOnly(t *testing.T) {
+	r := twredact.NewRedactor(twredact.WithTag("sensitive"))
+	req := newRequest()
+	twredact.Redact(r, req)
+	assert.Equal(t, Password("hunter2"), req.Login.Password)
+	assert.Equal(t, twredact.DefaultMask, req.Login.Token)
+	assert.Equal(t, Card{Number: "5500", CVV: 456}, req.Card)
+	assert.Nil(t, req.Login.Cards)
+	assert.Equal(t, 0, req.Headers["login"].(Login).PIN)
+}
+
+func TestRedactUnsupported(t *testing.T) {
+	type Hook func()
+	r := twredact.NewRedactor(twredact.WithTypes(reflect.TypeOf(Hook(nil)), reflect.TypeOf((chan int)(nil))))
+	values := []any{Hook(func() {}), make(chan int), func() {}}
+	twredact.Redact(r, &values)
+	assert.Nil(t, values[0])
+	assert.Nil(t, values[1])
+	assert.NotNil(t, values[2])
+	assert.IsType(t, Hook(nil), values[0])
+}
+
+func TestRedactNil(t *testing.T) {
+	assert.NotPanics(t, func() {
+		twredact.Redact(newRedactor(), (*Request)(nil))
+	})
+}
+
+func BenchmarkRedact(b *testing.B) {
+	r := newRedactor()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		req := newRequest()
+		b.StartTimer()
+		twredact.Redact(r, req)
+	}
+}
@@ -0,0 +1,277 @@
+// Package twsize estimates how much memory values retain, using type-walk Walkers.
+//
+// Size walks a value and sums the sizes of its type and of all memory reachable from it: the values pointed to by
+// pointers, the backing arrays of slices and strings, maps and their entries, and the concrete values of interfaces.
+//
+// Memory reachable from a value more than once is only counted once. Pointers to the same value, and slices and
+// strings sharing a backing array, are recognised, as long as they share the end of the memory they refer to - e.g.
+// s and s[1:] are, but s[:1:1] isn't. A pointer to a field of a struct, or an element of an array or slice, which is
+// also reached by other means is counted twice.
+//
+// The result is an estimate. In particular:
+//   - Slices are counted by their capacity, and strings by their length, without rounding up to the size classes the
+//     allocator uses.
+//   - Maps are counted by an estimate of the size of a hash table of their length and entry size, which depends on the
+//     implementation of maps in the Go runtime.
+//   - Interfaces are assumed to hold their concrete values in separate allocations, unless they're pointers, although
+//     the runtime avoids allocating some small values.
+//   - Channels and functions, and memory referenced by values of types which contain them other than in struct
+//     fields or interfaces, aren't counted beyond their own size.
+package twsize
+
+import (
+	"reflect"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+)
+
+// Map size estimates, based on the runtime's hash tables, which store entries in groups of 8 slots with a control
+// byte per slot, and grow to keep at most 7/8 of the slots full.
+const (
+	mapHeaderSize = 48
+	mapGroupSlots = 8
+)
+
+// Size returns an estimate of the number of bytes v retains, including the size of v itself.
+func Size(v any) uintptr {
+	if v == nil {
+		return 0
+	}
+	s := newState()
+	defer s.release()
+	t := reflect.TypeOf(v)
+	s.total = t.Size()
+	if err := walker.Walk(s, v); err != nil {
+		// The Walker can't compile a function for v's type, so count only v itself.
+		return t.Size()
+	}
+	return s.total
+}
+
+var walker = tw.NewWalker(newRegister(), tw.WithThreadSafe)
+
+type state struct {
+	total uintptr
+	// counted maps the end address of each region of memory counted so far to the number of bytes counted before
+	// that address. Regions sharing an end are assumed to be the same allocation. The addresses are kept as uintptrs,
+	// since the end of a region may be the start of another allocation, which the map mustn't keep alive.
+	counted map[uintptr]uintptr
+	// walked holds the pointers, slices and maps whose contents have been walked, to avoid walking them again, which
+	// also stops cycles.
+	walked walkutil.Set
+}
+
+var statePool walkutil.Pool[state]
+
+func newState() *state {
+	s := statePool.Get()
+	if s.counted == nil {
+		s.counted = map[uintptr]uintptr{}
+	}
+	return s
+}
+
+func (s *state) release() {
+	s.total = 0
+	clear(s.counted)
+	s.walked.Clear()
+	statePool.Put(s)
+}
+
+// count counts the size bytes of memory starting at p, less any already counted. It never forms a pointer to the end
+// of the memory, which may be outside the allocation p points into.
+func (s *state) count(p unsafe.Pointer, size uintptr) {
+	if size == 0 {
+		return
+	}
+	end := uintptr(p) + size
+	if prev := s.counted[end]; prev < size {
+		s.total += size - prev
+		s.counted[end] = size
+	}
+}
+
+func newRegister() *tw.Register[*state] {
+	r := tw.NewRegister[*state]()
+	tw.RegisterCompileBoolFn(r, compileNoop[bool])
+	tw.RegisterCompileIntFn(r, compileNoop[int])
+	tw.RegisterCompileInt8Fn(r, compileNoop[int8])
+	tw.RegisterCompileInt16Fn(r, compileNoop[int16])
+	tw.RegisterCompileInt32Fn(r, compileNoop[int32])
+	tw.RegisterCompileInt64Fn(r, compileNoop[int64])
+	tw.RegisterCompileUintFn(r, compileNoop[uint])
+	tw.RegisterCompileUint8Fn(r, compileNoop[uint8])
+	tw.RegisterCompileUint16Fn(r, compileNoop[uint16])
+	tw.RegisterCompileUint32Fn(r, compileNoop[uint32])
+	tw.RegisterCompileUint64Fn(r, compileNoop[uint64])
+	tw.RegisterCompileUintptrFn(r, compileNoop[uintptr])
+	tw.RegisterCompileFloat32Fn(r, compileNoop[float32])
+	tw.RegisterCompileFloat64Fn(r, compileNoop[float64])
+	tw.RegisterCompileComplex64Fn(r, compileNoop[complex64])
+	tw.RegisterCompileComplex128Fn(r, compileNoop[complex128])
+	tw.RegisterCompileUnsafePointerFn(r, compileNoop[unsafe.Pointer])
+	tw.RegisterCompileStringFn(r, compileString)
+	tw.RegisterCompileStructFn(r, compileStruct)
+	tw.RegisterCompileArrayFn(r, compileArray)
+	tw.RegisterCompileSliceFn(r, compileSlice)
+	tw.RegisterCompilePtrFn(r, compilePtr)
+	tw.RegisterCompileMapFn(r, compileMap)
+	tw.RegisterCompileInterfaceFn(r, compileInterface)
+	return r
+}
+
+func compileNoop[T any](reflect.Type) tw.WalkFn[*state, T] {
+	return func(*state, tw.Arg[T]) error {
+		return nil
+	}
+}
+
+func compileString(reflect.Type) tw.WalkFn[*state, string] {
+	return func(s *state, str tw.String) error {
+		v := str.Get()
+		s.count(unsafe.Pointer(unsafe.StringData(v)), uintptr(len(v)))
+		return nil
+	}
+}
+
+func compileStruct(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*state] {
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); references(f.Type) && walkutil.Unsupported(f.Type, nil) == nil {
+			fields = append(fields, sfr.RegisterField(i))
+		}
+	}
+	return func(s *state, st tw.Struct[*state]) error {
+		for _, num := range fields {
+			if err := st.Field(num).Walk(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func compileArray(t reflect.Type) tw.WalkArrayFn[*state] {
+	if !references(t.Elem()) {
+		return func(*state, tw.Array[*state]) error {
+			return nil
+		}
+	}
+	return func(s *state, a tw.Array[*state]) error {
+		return a.WalkAll(s)
+	}
+}
+
+func compileSlice(t reflect.Type) tw.WalkSliceFn[*state] {
+	elemSize := t.Elem().Size()
+	walkElems := references(t.Elem())
+	return func(s *state, sl tw.Slice[*state]) error {
+		if sl.Cap() == 0 {
+			return nil
+		}
+		p := sl.Value().UnsafePointer()
+		size := uintptr(sl.Cap()) * elemSize
+		s.count(p, size)
+		if !walkElems || !s.walked.Add(walkutil.Key{P: p, Len: sl.Len(), Type: t}) {
+			return nil
+		}
+		return sl.WalkAll(s)
+	}
+}
+
+func compilePtr(t reflect.Type) tw.WalkPtrFn[*state] {
+	size := t.Elem().Size()
+	return func(s *state, ptr tw.Ptr[*state]) error {
+		if ptr.IsNil() {
+			return nil
+		}
+		p := walkutil.PointerOf(ptr.Interface())
+		s.count(p, size)
+		if !s.walked.Add(walkutil.Key{P: p, Type: t}) {
+			return nil
+		}
+		return ptr.Walk(s)
+	}
+}
+
+func compileMap(t reflect.Type) tw.WalkMapFn[*state] {
+	slotSize := t.Key().Size() + t.Elem().Size()
+	walkEntries := references(t.Key()) || references(t.Elem())
+	return func(s *state, m tw.Map[*state]) error {
+		if m.IsNil() {
+			return nil
+		}
+		if !s.walked.Add(walkutil.Key{P: walkutil.PointerOf(m.Interface()), Type: t}) {
+			return nil
+		}
+		s.total += mapSize(m.Len(), slotSize)
+		if !walkEntries {
+			return nil
+		}
+		return m.WalkAll(s)
+	}
+}
+
+// mapSize estimates the size of a map with n entries, whose keys and values take slotSize bytes.
+func mapSize(n int, slotSize uintptr) uintptr {
+	if n == 0 {
+		return mapHeaderSize
+	}
+	slots := uintptr(mapGroupSlots)
+	for slots*7/8 < uintptr(n) {
+		slots *= 2
+	}
+	return mapHeaderSize + slots*(1+slotSize)
+}
+
+func compileInterface(reflect.Type) tw.WalkInterfaceFn[*state] {
+	return func(s *state, i tw.Interface[*state]) error {
+		if i.IsNil() {
+			return nil
+		}
+		t := reflect.TypeOf(i.Interface())
+		if !isPointerShaped(t) {
+			s.total += t.Size()
+		}
+		if unsupportedTypes.Unsupported(t) {
+			return nil
+		}
+		return i.Walk(s)
+	}
+}
+
+// isPointerShaped reports whether values of t are stored directly in interfaces, rather than in separate allocations.
+func isPointerShaped(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return true
+	case reflect.Struct:
+		return t.NumField() == 1 && isPointerShaped(t.Field(0).Type)
+	case reflect.Array:
+		return t.Len() == 1 && isPointerShaped(t.Elem())
+	}
+	return false
+}
+
+// references reports whether values of t can reference other memory which Size counts.
+func references(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	case reflect.Array:
+		return t.Len() > 0 && references(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if references(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unsupportedTypes caches whether the concrete types of interfaces are unsupported, since they're checked for every
+// value.
+var unsupportedTypes = walkutil.NewUnsupportedCache(nil)
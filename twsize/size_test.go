@@ -0,0 +1,122 @@
+package twsize_test
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zolstein/type-walk/twsize"
+)
+
+// Entry retains the backing array of Tags, and the bytes of the strings in it, as well as its own size.
+type Entry struct {
+	ID   int
+	Tags []string
+}
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+const (
+	intSize    = unsafe.Sizeof(0)
+	stringSize = unsafe.Sizeof("")
+	sliceSize  = unsafe.Sizeof([]int(nil))
+	entrySize  = unsafe.Sizeof(Entry{})
+	anySize    = unsafe.Sizeof(any(nil))
+)
+
+func TestSize(t *testing.T) {
+	str := "hello"
+	tests := []struct {
+		name     string
+		v        any
+		expected uintptr
+	}{
+		{"nil", nil, 0},
+		{"int", 1, intSize},
+		{"string", str, stringSize + 5},
+		{"bytes", make([]byte, 3, 10), sliceSize + 10},
+		{"nilSlice", []int(nil), sliceSize},
+		{"strings", []string{"ab", "cde"}, sliceSize + 2*stringSize + 5},
+		{"ptr", &Entry{ID: 1}, intSize + entrySize},
+		{"nilPtr", (*Entry)(nil), intSize},
+		{"struct", Entry{Tags: []string{"x"}}, entrySize + stringSize + 1},
+		{"array", [2]*int{new(int), nil}, 2*intSize + intSize},
+		{"interfaces", []any{1, "ab", &Node{}}, sliceSize + 3*anySize + intSize + stringSize + 2 + unsafe.Sizeof(Node{})},
+		{"nilMap", map[int]int(nil), intSize},
+		{"emptyMap", map[int]int{}, intSize + 48},
+		{"map", map[int]int{1: 2}, intSize + 48 + 8*(1+2*intSize)},
+		{"largeMap", func() map[int]int {
+			m := map[int]int{}
+			for i := 0; i < 8; i++ {
+				m[i] = i
+			}
+			return m
+		}(), intSize + 48 + 16*(1+2*intSize)},
+		{"mapEntries", map[string]*int{"ab": new(int)}, intSize + 48 + 8*(1+stringSize+intSize) + 2 + intSize},
+		{"chanField", struct{ ch chan []int }{make(chan []int)}, intSize},
+		{"chanSlice", []chan int{make(chan int)}, sliceSize},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, twsize.Size(test.v))
+		})
+	}
+}
+
+func TestSizeSharedMemory(t *testing.T) {
+	entry := &Entry{ID: 1}
+	assert.Equal(t, 2*intSize+entrySize, twsize.Size([2]*Entry{entry, entry}))
+	assert.Equal(t, sliceSize+2*anySize+entrySize, twsize.Size([]any{entry, entry}))
+
+	// Slices sharing a backing array are counted once, by their largest extent, wherever they're reached from.
+	backing := make([]int, 10)
+	assert.Equal(t, 3*sliceSize+10*intSize, twsize.Size([3][]int{backing[5:], backing, backing[2:4]}))
+	assert.Equal(t, 2*sliceSize+10*intSize, twsize.Size(struct{ Tail, All []int }{backing[8:], backing}))
+	assert.Equal(
+		t,
+		intSize+48+8*(1+stringSize+sliceSize)+2+10*intSize,
+		twsize.Size(map[string][]int{"a": backing, "b": backing[3:]}),
+	)
+	assert.Equal(t, sliceSize+2*anySize+2*sliceSize+10*intSize, twsize.Size([]any{backing[1:], backing}))
+	// Slices which don't share the end of their backing array can't be recognised.
+	assert.Equal(t, 2*sliceSize+(10+4)*intSize, twsize.Size([2][]int{backing, backing[:4:4]}))
+	// Neither can pointers into the middle of a backing array.
+	assert.Equal(t, sliceSize+10*intSize+intSize+intSize, twsize.Size(struct {
+		S []int
+		P *int
+	}{backing, &backing[0]}))
+
+	// Strings sharing a suffix of their bytes are counted once.
+	str := "hello, world"
+	assert.Equal(t, 2*stringSize+12, twsize.Size([2]string{str, str[7:]}))
+
+	m := map[int]int{}
+	assert.Equal(t, 2*intSize+48, twsize.Size([2]map[int]int{m, m}))
+
+	// Memory which is reached again because the value is cyclic is also only counted once.
+	n := &Node{Value: 1}
+	n.Next = &Node{Value: 2, Next: n}
+	assert.Equal(t, intSize+2*unsafe.Sizeof(Node{}), twsize.Size(n))
+
+	s := []any{nil}
+	s[0] = s
+	assert.Equal(t, sliceSize+anySize+sliceSize, twsize.Size(s))
+
+	self := map[string]any{}
+	self["m"] = self
+	assert.Equal(t, intSize+48+8*(1+stringSize+anySize)+1, twsize.Size(self))
+}
+
+func BenchmarkSize(b *testing.B) {
+	values := make([]Entry, 100)
+	for i := range values {
+		values[i] = Entry{ID: i, Tags: []string{"a", "b", "c"}}
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = twsize.Size(values)
+	}
+}
@@ -0,0 +1,569 @@
+// Package twvalidate validates structs against rules in their field tags, using type-walk Walkers.
+//
+// Rules are written in a field's validate tag, separated by commas, like `validate:"required,min=1,max=10"`. The tag
+// of each field is parsed once, when the function for the struct type is compiled, into a list of checks, which are
+// run each time a value of the type is validated. Validation reports every field which fails a rule, by its path from
+// the validated struct, written as Go selectors and index expressions, e.g. .Items[0].Name.
+//
+// Only exported fields are validated, and fields tagged `validate:"-"` are skipped. Fields which are structs or
+// pointers to structs are validated recursively by their own tags. Each field reports at most one failure, for the
+// first rule it fails.
+//
+// The built-in rules are:
+//   - required: the value isn't zero. Slices, maps, pointers, interfaces, channels and functions must not be nil.
+//   - omitempty: if the value is zero, or nil for the kinds above, the remaining rules are skipped.
+//   - min, max, len, eq, ne, gt, gte, lt, lte: the value, compared with the parameter, must be at least, at most,
+//     equal to, not equal to, greater than, at least, less than, or at most the parameter. Numbers are compared by
+//     value, and strings, slices, maps and arrays by length, in runes for strings, except that eq and ne compare
+//     strings by value.
+//   - oneof: the value is one of the parameter's space-separated strings or numbers, e.g. oneof=red green.
+//   - dive: the remaining rules apply to each element of a slice or array, or each value of a map, rather than to the
+//     value itself. Elements which are structs are validated recursively. Rules for the keys of a map can be given
+//     between keys and endkeys, straight after dive, e.g. dive,keys,min=1,endkeys,required.
+//
+// Rules other than required and omitempty apply to the values pointers point to, and fail for nil pointers. Custom
+// rules can be added with WithRule.
+package twvalidate
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+)
+
+// A Rule reports whether v satisfies a custom rule with param, the text after = in the tag, or "" if there is none. v
+// is never a pointer, since rules apply to the values pointers point to.
+type Rule func(v reflect.Value, param string) bool
+
+type config struct {
+	tag   string
+	rules map[string]Rule
+}
+
+// Option configures a Validator.
+type Option func(*config)
+
+// WithTag reads rules from the struct tag for key, instead of validate.
+func WithTag(key string) Option {
+	return func(c *config) {
+		c.tag = key
+	}
+}
+
+// WithRule adds a rule called name, which replaces any built-in rule of the same name.
+func WithRule(name string, rule Rule) Option {
+	return func(c *config) {
+		c.rules[name] = rule
+	}
+}
+
+// A FieldError is a failure of a field to satisfy a rule.
+type FieldError struct {
+	// Path is the path to the field from the validated struct.
+	Path string
+	// Rule and Param are the name and parameter of the rule the field failed.
+	Rule  string
+	Param string
+	// Value is the field's value.
+	Value any
+}
+
+func (e *FieldError) Error() string {
+	rule := e.Rule
+	if e.Param != "" {
+		rule += "=" + e.Param
+	}
+	return fmt.Sprintf("%s: failed %q, with value %#v", e.Path, rule, e.Value)
+}
+
+// Errors is the list of failures returned by Validate when any fields fail their rules.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// A Validator validates structs. It is safe for concurrent use.
+type Validator struct {
+	cfg    config
+	walker *tw.Walker[*state]
+}
+
+// NewValidator returns a Validator configured by opts.
+func NewValidator(opts ...Option) *Validator {
+	cfg := config{tag: "validate", rules: map[string]Rule{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	val := &Validator{cfg: cfg}
+	r := tw.NewRegister[*state]()
+	tw.RegisterCompileStructFn(r, val.compileStruct)
+	tw.RegisterCompilePtrFn(r, compilePtr)
+	val.walker = tw.NewWalker(r, tw.WithThreadSafe)
+	return val
+}
+
+var defaultValidator = NewValidator()
+
+// Validate validates v, which must be a struct or a non-nil pointer to one, with the built-in rules.
+func Validate(v any) error {
+	return defaultValidator.Validate(v)
+}
+
+// Validate validates v, which must be a struct or a non-nil pointer to one. It returns Errors if any fields fail their
+// rules, or another error if v can't be validated, e.g. because it's a nil pointer or a tag is invalid.
+func (val *Validator) Validate(v any) error {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || deref(rv.Type()).Kind() != reflect.Struct {
+		return fmt.Errorf("twvalidate: can't validate %T, which isn't a struct or a pointer to one", v)
+	}
+	if rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return fmt.Errorf("twvalidate: can't validate nil %T", v)
+	}
+	s := newState(val)
+	defer s.release()
+	var err error
+	if rv.Kind() == reflect.Pointer {
+		err = val.walker.Walk(s, v)
+	} else {
+		// Copy the struct so that it's addressable, so its fields can be read without copying it again.
+		p := reflect.New(rv.Type())
+		p.Elem().Set(rv)
+		err = val.walker.WalkUnsafe(s, rv.Type(), p.UnsafePointer(), true)
+	}
+	if err != nil {
+		return err
+	}
+	if len(s.errs) == 0 {
+		return nil
+	}
+	errs := s.errs
+	s.errs = nil
+	return errs
+}
+
+type state struct {
+	val  *Validator
+	path []byte
+	errs Errors
+	// seen holds the pointers being validated, to stop cycles, which would otherwise report the same failures forever.
+	seen walkutil.Set
+}
+
+var statePool walkutil.Pool[state]
+
+func newState(val *Validator) *state {
+	s := statePool.Get()
+	s.val = val
+	return s
+}
+
+func (s *state) release() {
+	s.val = nil
+	s.path = s.path[:0]
+	s.errs = nil
+	s.seen.Clear()
+	statePool.Put(s)
+}
+
+// push appends a field to the path, and returns the length to restore the path to with pop.
+func (s *state) push(name string) int {
+	n := len(s.path)
+	s.path = append(s.path, '.')
+	s.path = append(s.path, name...)
+	return n
+}
+
+func (s *state) pushIndex(i int) int {
+	n := len(s.path)
+	s.path = fmt.Appendf(s.path, "[%d]", i)
+	return n
+}
+
+func (s *state) pushKey(key reflect.Value) int {
+	n := len(s.path)
+	s.path = fmt.Appendf(s.path, "[%#v]", key.Interface())
+	return n
+}
+
+func (s *state) pop(n int) {
+	s.path = s.path[:n]
+}
+
+func (s *state) fail(c *check, v reflect.Value) {
+	s.errs = append(s.errs, &FieldError{Path: string(s.path), Rule: c.name, Param: c.param, Value: v.Interface()})
+}
+
+// rules are the rules for a value, parsed from a tag.
+type rules struct {
+	omitempty bool
+	required  bool
+	checks    []check
+	// dive holds the rules for the elements of the value, and keys those for the keys of a map, after dive.
+	dive *rules
+	keys *rules
+	// nested is set if the value is a struct or a pointer to one, which is validated recursively.
+	nested bool
+}
+
+type check struct {
+	name, param string
+	fn          func(reflect.Value) bool
+}
+
+// requiredCheck is the check reported when a value fails required.
+var requiredCheck = check{name: "required"}
+
+// parseRules parses the rules in tokens, for values of type t.
+func (val *Validator) parseRules(t reflect.Type, tokens []string) (*rules, error) {
+	rs := &rules{}
+	elem := deref(t)
+	rs.nested = elem.Kind() == reflect.Struct
+	for i := 0; i < len(tokens); i++ {
+		name, param, _ := strings.Cut(tokens[i], "=")
+		switch name {
+		case "omitempty":
+			rs.omitempty = true
+		case "required":
+			rs.required = true
+		case "dive":
+			return rs, val.parseDive(rs, elem, tokens[i+1:])
+		case "keys", "endkeys":
+			return nil, fmt.Errorf("%s must follow dive on a map", name)
+		default:
+			fn, err := val.compileCheck(elem, name, param)
+			if err != nil {
+				return nil, err
+			}
+			rs.checks = append(rs.checks, check{name: name, param: param, fn: fn})
+		}
+	}
+	return rs, nil
+}
+
+func (val *Validator) parseDive(rs *rules, t reflect.Type, tokens []string) error {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+	case reflect.Map:
+		if len(tokens) > 0 && tokens[0] == "keys" {
+			end := -1
+			for i, token := range tokens {
+				if token == "endkeys" {
+					end = i
+					break
+				}
+			}
+			if end < 0 {
+				return errors.New("keys must be followed by endkeys")
+			}
+			keys, err := val.parseRules(t.Key(), tokens[1:end])
+			if err != nil {
+				return err
+			}
+			rs.keys = keys
+			tokens = tokens[end+1:]
+		}
+	default:
+		return fmt.Errorf("can't dive into %v", t)
+	}
+	dive, err := val.parseRules(t.Elem(), tokens)
+	rs.dive = dive
+	return err
+}
+
+// compileCheck compiles the rule name with param for values of type t, which isn't a pointer.
+func (val *Validator) compileCheck(t reflect.Type, name, param string) (func(reflect.Value) bool, error) {
+	if rule, ok := val.cfg.rules[name]; ok {
+		return func(v reflect.Value) bool {
+			return rule(v, param)
+		}, nil
+	}
+	switch name {
+	case "min", "max", "len", "eq", "ne", "gt", "gte", "lt", "lte":
+		return compileComparison(t, name, param)
+	case "oneof":
+		return compileOneOf(t, param)
+	}
+	return nil, fmt.Errorf("unknown rule %q", name)
+}
+
+// comparisons map each comparison rule to whether it's satisfied, given the comparison of the value with the
+// parameter.
+var comparisons = map[string]func(int) bool{
+	"min": func(c int) bool { return c >= 0 },
+	"max": func(c int) bool { return c <= 0 },
+	"len": func(c int) bool { return c == 0 },
+	"eq":  func(c int) bool { return c == 0 },
+	"ne":  func(c int) bool { return c != 0 },
+	"gt":  func(c int) bool { return c > 0 },
+	"gte": func(c int) bool { return c >= 0 },
+	"lt":  func(c int) bool { return c < 0 },
+	"lte": func(c int) bool { return c <= 0 },
+}
+
+func compileComparison(t reflect.Type, name, param string) (func(reflect.Value) bool, error) {
+	ok := comparisons[name]
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(param, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter: %w", name, err)
+		}
+		return func(v reflect.Value) bool { return ok(cmp.Compare(v.Int(), n)) }, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(param, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter: %w", name, err)
+		}
+		return func(v reflect.Value) bool { return ok(cmp.Compare(v.Uint(), n)) }, nil
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter: %w", name, err)
+		}
+		return func(v reflect.Value) bool { return ok(cmp.Compare(v.Float(), n)) }, nil
+	case reflect.String:
+		if name == "eq" || name == "ne" {
+			return func(v reflect.Value) bool { return ok(strings.Compare(v.String(), param)) }, nil
+		}
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter: %w", name, err)
+		}
+		return func(v reflect.Value) bool { return ok(cmp.Compare(utf8.RuneCountInString(v.String()), n)) }, nil
+	case reflect.Slice, reflect.Map, reflect.Array:
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter: %w", name, err)
+		}
+		return func(v reflect.Value) bool { return ok(cmp.Compare(v.Len(), n)) }, nil
+	}
+	return nil, fmt.Errorf("rule %s doesn't apply to %v", name, t)
+}
+
+func compileOneOf(t reflect.Type, param string) (func(reflect.Value) bool, error) {
+	options := strings.Fields(param)
+	switch t.Kind() {
+	case reflect.String:
+		return func(v reflect.Value) bool {
+			for _, option := range options {
+				if v.String() == option {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ns, err := parseAll(options, func(s string) (int64, error) { return strconv.ParseInt(s, 0, 64) })
+		if err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value) bool { return contains(ns, v.Int()) }, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		ns, err := parseAll(options, func(s string) (uint64, error) { return strconv.ParseUint(s, 0, 64) })
+		if err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value) bool { return contains(ns, v.Uint()) }, nil
+	}
+	return nil, fmt.Errorf("rule oneof doesn't apply to %v", t)
+}
+
+func parseAll[T any](options []string, parse func(string) (T, error)) ([]T, error) {
+	ns := make([]T, len(options))
+	for i, option := range options {
+		n, err := parse(option)
+		if err != nil {
+			return nil, fmt.Errorf("invalid oneof parameter: %w", err)
+		}
+		ns[i] = n
+	}
+	return ns, nil
+}
+
+func contains[T comparable](ns []T, n T) bool {
+	for _, m := range ns {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// deref returns the type that pointers of type t, possibly to other pointers, ultimately point to.
+func deref(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// hasValue reports whether v satisfies required.
+func hasValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Pointer, reflect.Interface, reflect.Chan, reflect.Func:
+		return !v.IsNil()
+	}
+	return !v.IsZero()
+}
+
+// run runs rs on v, recording any failure. It reports whether v should be validated recursively, because it passed
+// the rules and wasn't skipped by omitempty.
+func (s *state) run(rs *rules, v reflect.Value) (bool, error) {
+	if rs.omitempty && !hasValue(v) {
+		return false, nil
+	}
+	if rs.required && !hasValue(v) {
+		s.fail(&requiredCheck, v)
+		return false, nil
+	}
+	if len(rs.checks) == 0 && rs.dive == nil {
+		return true, nil
+	}
+	e := v
+	for e.Kind() == reflect.Pointer {
+		if e.IsNil() {
+			c := &check{name: "dive"}
+			if len(rs.checks) > 0 {
+				c = &rs.checks[0]
+			}
+			s.fail(c, v)
+			return false, nil
+		}
+		e = e.Elem()
+	}
+	for i := range rs.checks {
+		if c := &rs.checks[i]; !c.fn(e) {
+			s.fail(c, v)
+			return false, nil
+		}
+	}
+	if rs.dive != nil {
+		return false, s.dive(rs, e)
+	}
+	return true, nil
+}
+
+// dive runs the rules for the elements of v, and validates them recursively.
+func (s *state) dive(rs *rules, v reflect.Value) error {
+	if v.Kind() == reflect.Map {
+		iter := v.MapRange()
+		for iter.Next() {
+			n := s.pushKey(iter.Key())
+			if rs.keys != nil {
+				if _, err := s.run(rs.keys, iter.Key()); err != nil {
+					return err
+				}
+			}
+			err := s.runElem(rs.dive, iter.Value())
+			s.pop(n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < v.Len(); i++ {
+		n := s.pushIndex(i)
+		err := s.runElem(rs.dive, v.Index(i))
+		s.pop(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *state) runElem(rs *rules, v reflect.Value) error {
+	nested, err := s.run(rs, v)
+	if err != nil || !nested || !rs.nested {
+		return err
+	}
+	return s.val.walker.WalkValue(s, v)
+}
+
+// field is a field validated by a struct function.
+type field struct {
+	name  string
+	index int
+	rules *rules
+	// num is the index of the field in the StructFieldRegister, if it's validated recursively.
+	num int
+}
+
+func (val *Validator) compileStruct(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*state] {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get(val.cfg.tag)
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		var tokens []string
+		if tag != "" {
+			tokens = strings.Split(tag, ",")
+		}
+		rs, err := val.parseRules(f.Type, tokens)
+		if err != nil {
+			err = fmt.Errorf("twvalidate: invalid %s tag on %v.%s: %w", val.cfg.tag, t, f.Name, err)
+			return func(*state, tw.Struct[*state]) error {
+				return err
+			}
+		}
+		if tag == "" && !rs.nested {
+			continue
+		}
+		fd := field{name: f.Name, index: i, rules: rs, num: -1}
+		if rs.nested {
+			fd.num = sfr.RegisterField(i)
+		}
+		fields = append(fields, fd)
+	}
+
+	return func(s *state, st tw.Struct[*state]) error {
+		if len(fields) == 0 {
+			return nil
+		}
+		v := st.Value()
+		for i := range fields {
+			f := &fields[i]
+			n := s.push(f.name)
+			nested, err := s.run(f.rules, v.Field(f.index))
+			if err == nil && nested && f.num >= 0 {
+				err = st.Field(f.num).Walk(s)
+			}
+			s.pop(n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func compilePtr(t reflect.Type) tw.WalkPtrFn[*state] {
+	return func(s *state, p tw.Ptr[*state]) error {
+		if p.IsNil() {
+			return nil
+		}
+		key := walkutil.Key{P: walkutil.PointerOf(p.Interface()), Type: t}
+		if !s.seen.Add(key) {
+			// The value is already being validated, further up the path.
+			return nil
+		}
+		err := p.Walk(s)
+		s.seen.Remove(key)
+		return err
+	}
+}
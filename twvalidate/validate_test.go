@@ -0,0 +1,202 @@
+package twvalidate_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zolstein/type-walk/twvalidate"
+)
+
+type Address struct {
+	Street string `validate:"required"`
+	Zip    string `validate:"len=5"`
+}
+
+type Item struct {
+	Name     string  `validate:"required,max=10"`
+	Quantity uint    `validate:"min=1"`
+	Price    float64 `validate:"gt=0"`
+}
+
+type Order struct {
+	ID       int               `validate:"required"`
+	Customer string            `validate:"required,min=2,max=20"`
+	Status   string            `validate:"oneof=new paid shipped"`
+	Priority int               `validate:"oneof=1 2 3"`
+	Items    []Item            `validate:"required,min=1,dive"`
+	Tags     []string          `validate:"omitempty,dive,min=1"`
+	Labels   map[string]string `validate:"dive,keys,min=2,endkeys,required"`
+	Ship     *Address
+	Bill     Address
+	Notes    *string `validate:"omitempty,max=5"`
+	Coupon   *string `validate:"max=5"`
+	Untagged []Item
+	Ignored  string `validate:"-"`
+	internal string `validate:"required"`
+}
+
+func validOrder() Order {
+	return Order{
+		ID:       1,
+		Customer: "ann",
+		Status:   "paid",
+		Priority: 2,
+		Items:    []Item{{Name: "pen", Quantity: 2, Price: 1.5}},
+		Labels:   map[string]string{"gift": "yes"},
+		Bill:     Address{Street: "Main", Zip: "12345"},
+		Coupon:   new(string),
+		Untagged: []Item{{}},
+	}
+}
+
+func paths(t *testing.T, err error) map[string]string {
+	t.Helper()
+	var errs twvalidate.Errors
+	require.ErrorAs(t, err, &errs)
+	failed := map[string]string{}
+	for _, e := range errs {
+		rule := e.Rule
+		if e.Param != "" {
+			rule += "=" + e.Param
+		}
+		failed[e.Path] = rule
+	}
+	return failed
+}
+
+func TestValidate(t *testing.T) {
+	valid := validOrder()
+	assert.NoError(t, twvalidate.Validate(valid))
+	assert.NoError(t, twvalidate.Validate(&valid))
+
+	notes := "too long"
+	invalid := Order{
+		Customer: "ä",
+		Status:   "lost",
+		Priority: 4,
+		Items:    []Item{{Name: "pen", Quantity: 1, Price: 1}, {Name: "a very long name", Price: -1}, {Quantity: 1}},
+		Tags:     []string{"ok", ""},
+		Labels:   map[string]string{"a": "x", "ok": ""},
+		Ship:     &Address{Zip: "1234"},
+		Bill:     Address{Street: "Main", Zip: "123456"},
+		Notes:    &notes,
+	}
+	assert.Equal(t, map[string]string{
+		".ID":                "required",
+		".Customer":          "min=2",
+		".Status":            "oneof=new paid shipped",
+		".Priority":          "oneof=1 2 3",
+		".Items[1].Name":     "max=10",
+		".Items[1].Quantity": "min=1",
+		".Items[1].Price":    "gt=0",
+		".Items[2].Name":     "required",
+		".Items[2].Price":    "gt=0",
+		".Tags[1]":           "min=1",
+		`.Labels["a"]`:       "min=2",
+		`.Labels["ok"]`:      "required",
+		".Ship.Street":       "required",
+		".Ship.Zip":          "len=5",
+		".Bill.Zip":          "len=5",
+		".Notes":             "max=5",
+		".Coupon":            "max=5",
+	}, paths(t, twvalidate.Validate(invalid)))
+
+	err := twvalidate.Validate(Order{Items: []Item{}})
+	failed := paths(t, err)
+	assert.Equal(t, "min=1", failed[".Items"])
+	assert.NotContains(t, failed, ".Tags")
+	assert.Contains(t, err.Error(), `.Customer: failed "required", with value ""`)
+}
+
+func TestValidateErrors(t *testing.T) {
+	assert.ErrorContains(t, twvalidate.Validate(1), "isn't a struct")
+	assert.ErrorContains(t, twvalidate.Validate(nil), "isn't a struct")
+	type T struct {
+		A int `validate:"required"`
+	}
+	assert.EqualError(t, twvalidate.Validate((*T)(nil)), "twvalidate: can't validate nil *twvalidate_test.T")
+
+	tests := []struct {
+		v        any
+		expected string
+	}{
+		{struct {
+			A int `validate:"unknown"`
+		}{}, `unknown rule "unknown"`},
+		{struct {
+			A int `validate:"min=x"`
+		}{}, "invalid min parameter"},
+		{struct {
+			A bool `validate:"max=1"`
+		}{}, "rule max doesn't apply to bool"},
+		{struct {
+			A int `validate:"dive"`
+		}{}, "can't dive into int"},
+		{struct {
+			A map[string]int `validate:"dive,keys,min=1"`
+		}{}, "keys must be followed by endkeys"},
+		{struct {
+			A []int `validate:"keys"`
+		}{}, "keys must follow dive on a map"},
+		{struct {
+			A struct {
+				B float64 `validate:"oneof=1.5"`
+			}
+		}{}, "rule oneof doesn't apply to float64"},
+	}
+	for _, test := range tests {
+		err := twvalidate.Validate(test.v)
+		var errs twvalidate.Errors
+		assert.False(t, errorsAs(err, &errs))
+		assert.ErrorContains(t, err, test.expected)
+	}
+}
+
+func errorsAs(err error, errs *twvalidate.Errors) bool {
+	e, ok := err.(twvalidate.Errors)
+	*errs = e
+	return ok
+}
+
+func TestWithRule(t *testing.T) {
+	val := twvalidate.NewValidator(
+		twvalidate.WithTag("check"),
+		twvalidate.WithRule("prefix", func(v reflect.Value, param string) bool {
+			return strings.HasPrefix(v.String(), param)
+		}),
+		twvalidate.WithRule("min", func(v reflect.Value, _ string) bool {
+			return true
+		}),
+	)
+	type S struct {
+		ID    string   `check:"prefix=id-"`
+		IDs   []string `check:"dive,prefix=id-"`
+		Count int      `check:"min=10" validate:"required"`
+	}
+	assert.NoError(t, val.Validate(S{ID: "id-1", IDs: []string{"id-2"}}))
+	assert.Equal(t, map[string]string{".ID": "prefix=id-", ".IDs[0]": "prefix=id-"},
+		paths(t, val.Validate(&S{ID: "1", IDs: []string{"2"}})))
+}
+
+type Node struct {
+	Name string `validate:"required"`
+	Next *Node
+}
+
+func TestValidateCycles(t *testing.T) {
+	n := &Node{Name: "a"}
+	n.Next = &Node{Next: n}
+	assert.Equal(t, map[string]string{".Next.Name": "required"}, paths(t, twvalidate.Validate(n)))
+}
+
+func BenchmarkValidate(b *testing.B) {
+	order := validOrder()
+	order.Items = append(order.Items, order.Items[0], order.Items[0])
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = twvalidate.Validate(&order)
+	}
+}
@@ -0,0 +1,441 @@
+// Package twzero checks whether values are deeply zero, and resets values to zero for reuse, using type-walk Walkers.
+//
+// IsZero reports whether a value is zero, treating empty slices and maps as zero even if they're not nil, and
+// treating non-nil pointers and interfaces as zero if the values they refer to are zero. Floats are compared with ==,
+// so -0 is zero and NaN isn't. A time.Time is zero if its IsZero method says so.
+//
+// Reset sets a value to zero while keeping the memory allocated by its slices and maps, so objects reused through a
+// sync.Pool don't need to allocate them again. Slices keep their backing arrays, with their elements reset and their
+// length set to 0, and maps are cleared. Everything else is set to zero, including pointers and interfaces, since the
+// values they refer to may be shared. Reset assumes the value owns the backing arrays of its slices and its maps, so
+// values sharing them with others must not be reset.
+//
+// Types can opt out with RegisterKeep, or be checked or reset by registered functions instead.
+package twzero
+
+import (
+	"errors"
+	"reflect"
+	"time"
+	"unsafe"
+
+	tw "github.com/zolstein/type-walk"
+	"github.com/zolstein/type-walk/internal/walkutil"
+)
+
+// errNotZero stops a walk as soon as a value is known not to be zero.
+var errNotZero = errors.New("not zero")
+
+// Register stores functions that check and reset values of particular types, which take the place of the defaults.
+// Functions must be registered before the Register is used to create a Zeroer.
+type Register struct {
+	zero  *tw.Register[*zeroState]
+	reset *tw.Register[struct{}]
+}
+
+// NewRegister returns a new Register. Values of type time.Time are checked with their IsZero method.
+func NewRegister() *Register {
+	r := &Register{zero: tw.NewRegister[*zeroState](), reset: tw.NewRegister[struct{}]()}
+	RegisterIsZeroFn(r, time.Time.IsZero)
+	return r
+}
+
+// RegisterIsZeroFn registers fn to check whether values of type T are zero, including T in struct fields, elements
+// and interfaces.
+func RegisterIsZeroFn[T any](r *Register, fn func(T) bool) {
+	tw.RegisterTypeFn(r.zero, func(_ *zeroState, a tw.Arg[T]) error {
+		if !fn(a.Get()) {
+			return errNotZero
+		}
+		return nil
+	})
+}
+
+// RegisterResetFn registers fn to reset values of type T, including T in struct fields and elements. fn is called
+// with a pointer to the value.
+func RegisterResetFn[T any](r *Register, fn func(*T)) {
+	tw.RegisterTypeFn(r.reset, func(_ struct{}, a tw.Arg[T]) error {
+		fn(a.Value().Addr().Interface().(*T))
+		return nil
+	})
+}
+
+// RegisterKeep opts values of type T out of checking and resetting: IsZero treats them as zero, and Reset leaves them
+// unchanged.
+func RegisterKeep[T any](r *Register) {
+	RegisterIsZeroFn(r, func(T) bool { return true })
+	RegisterResetFn(r, func(*T) {})
+}
+
+// A Zeroer checks and resets values. It is safe for concurrent use.
+type Zeroer struct {
+	zero        *tw.Register[*zeroState]
+	zeroWalker  *tw.Walker[*zeroState]
+	reset       *tw.Register[struct{}]
+	resetWalker *tw.Walker[struct{}]
+	// zeroRegistered and resetRegistered report whether types have functions registered in zero and reset.
+	zeroRegistered  func(reflect.Type) bool
+	resetRegistered func(reflect.Type) bool
+	// unsupportedZero caches whether the concrete types of interfaces are unsupported, since they're checked for every
+	// value.
+	unsupportedZero *walkutil.UnsupportedCache
+}
+
+// NewZeroer returns a Zeroer which uses the functions in r, or those in NewRegister if r is nil.
+func NewZeroer(r *Register) *Zeroer {
+	if r == nil {
+		r = NewRegister()
+	}
+	z := &Zeroer{zero: r.zero.Clone(), reset: r.reset.Clone()}
+	z.zeroRegistered = walkutil.Registered(z.zero)
+	z.resetRegistered = walkutil.Registered(z.reset)
+	z.unsupportedZero = walkutil.NewUnsupportedCache(z.zeroRegistered)
+	z.registerZero()
+	z.registerReset()
+	z.zeroWalker = tw.NewWalker(z.zero, tw.WithThreadSafe)
+	z.resetWalker = tw.NewWalker(z.reset, tw.WithThreadSafe)
+	return z
+}
+
+var defaultZeroer = NewZeroer(nil)
+
+// IsZero reports whether v is deeply zero.
+func IsZero(v any) bool {
+	return defaultZeroer.IsZero(v)
+}
+
+// Reset resets the value p points to.
+func Reset[T any](p *T) {
+	ResetWith(defaultZeroer, p)
+}
+
+// IsZero reports whether v is deeply zero.
+func (z *Zeroer) IsZero(v any) bool {
+	if v == nil {
+		return true
+	}
+	s := newZeroState()
+	defer s.release()
+	err := z.zeroWalker.Walk(s, v)
+	if err != nil && err != errNotZero {
+		// The Walker can't compile a function for v's type.
+		return isZeroValue(reflect.ValueOf(v))
+	}
+	return err == nil
+}
+
+// ResetWith resets the value p points to with z.
+func ResetWith[T any](z *Zeroer, p *T) {
+	t := reflect.TypeOf(p).Elem()
+	if err := z.resetWalker.WalkUnsafe(struct{}{}, t, unsafe.Pointer(p), true); err != nil {
+		// The Walker can't compile a function for T.
+		resetValue(reflect.ValueOf(p).Elem())
+	}
+}
+
+type zeroState struct {
+	cycles walkutil.Cycles
+}
+
+var zeroStatePool walkutil.Pool[zeroState]
+
+func newZeroState() *zeroState {
+	return zeroStatePool.Get()
+}
+
+func (s *zeroState) release() {
+	s.cycles.Reset()
+	zeroStatePool.Put(s)
+}
+
+func (z *Zeroer) registerZero() {
+	tw.RegisterCompileBoolFn(z.zero, compileIsZero[bool])
+	tw.RegisterCompileIntFn(z.zero, compileIsZero[int])
+	tw.RegisterCompileInt8Fn(z.zero, compileIsZero[int8])
+	tw.RegisterCompileInt16Fn(z.zero, compileIsZero[int16])
+	tw.RegisterCompileInt32Fn(z.zero, compileIsZero[int32])
+	tw.RegisterCompileInt64Fn(z.zero, compileIsZero[int64])
+	tw.RegisterCompileUintFn(z.zero, compileIsZero[uint])
+	tw.RegisterCompileUint8Fn(z.zero, compileIsZero[uint8])
+	tw.RegisterCompileUint16Fn(z.zero, compileIsZero[uint16])
+	tw.RegisterCompileUint32Fn(z.zero, compileIsZero[uint32])
+	tw.RegisterCompileUint64Fn(z.zero, compileIsZero[uint64])
+	tw.RegisterCompileUintptrFn(z.zero, compileIsZero[uintptr])
+	tw.RegisterCompileFloat32Fn(z.zero, compileIsZero[float32])
+	tw.RegisterCompileFloat64Fn(z.zero, compileIsZero[float64])
+	tw.RegisterCompileComplex64Fn(z.zero, compileIsZero[complex64])
+	tw.RegisterCompileComplex128Fn(z.zero, compileIsZero[complex128])
+	tw.RegisterCompileStringFn(z.zero, compileIsZero[string])
+	tw.RegisterCompileUnsafePointerFn(z.zero, compileIsZero[unsafe.Pointer])
+	tw.RegisterCompileStructFn(z.zero, z.compileStructIsZero)
+	tw.RegisterCompileArrayFn(z.zero, compileArrayIsZero)
+	tw.RegisterCompileSliceFn(z.zero, compileSliceIsZero)
+	tw.RegisterCompilePtrFn(z.zero, compilePtrIsZero)
+	tw.RegisterCompileMapFn(z.zero, compileMapIsZero)
+	tw.RegisterCompileInterfaceFn(z.zero, z.compileInterfaceIsZero)
+}
+
+func compileIsZero[T comparable](reflect.Type) tw.WalkFn[*zeroState, T] {
+	return func(_ *zeroState, a tw.Arg[T]) error {
+		var zero T
+		if a.Get() != zero {
+			return errNotZero
+		}
+		return nil
+	}
+}
+
+// structField is a field checked or reset by a struct function.
+type structField struct {
+	// num is the index of the field in the StructFieldRegister.
+	num int
+	// unsupported is set if the field's type contains a channel or function, which the Walker can't compile, so the
+	// field is handled with reflect.
+	unsupported bool
+	index       int
+	typ         reflect.Type
+	offset      uintptr
+}
+
+func compileStructFields(t reflect.Type, sfr tw.StructFieldRegister, registered func(reflect.Type) bool) []structField {
+	fields := make([]structField, t.NumField())
+	for i := range fields {
+		f := t.Field(i)
+		sf := structField{index: i, typ: f.Type, offset: f.Offset}
+		if sf.unsupported = walkutil.Unsupported(f.Type, registered) != nil; !sf.unsupported {
+			sf.num = sfr.RegisterField(i)
+		}
+		fields[i] = sf
+	}
+	return fields
+}
+
+func (z *Zeroer) compileStructIsZero(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*zeroState] {
+	fields := compileStructFields(t, sfr, z.zeroRegistered)
+	return func(s *zeroState, st tw.Struct[*zeroState]) error {
+		for i := range fields {
+			f := &fields[i]
+			if f.unsupported {
+				if !isZeroValue(st.Value().Field(f.index)) {
+					return errNotZero
+				}
+				continue
+			}
+			if err := st.Field(f.num).Walk(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func compileArrayIsZero(reflect.Type) tw.WalkArrayFn[*zeroState] {
+	return func(s *zeroState, a tw.Array[*zeroState]) error {
+		return a.WalkAll(s)
+	}
+}
+
+func compileSliceIsZero(reflect.Type) tw.WalkSliceFn[*zeroState] {
+	return func(_ *zeroState, sl tw.Slice[*zeroState]) error {
+		if sl.Len() != 0 {
+			return errNotZero
+		}
+		return nil
+	}
+}
+
+func compilePtrIsZero(t reflect.Type) tw.WalkPtrFn[*zeroState] {
+	return func(s *zeroState, p tw.Ptr[*zeroState]) error {
+		if p.IsNil() {
+			return nil
+		}
+		key, ok := s.cycles.Enter(func() walkutil.Key {
+			return walkutil.Key{P: walkutil.PointerOf(p.Interface()), Type: t}
+		})
+		if !ok {
+			// The pointer is already being checked, so it's zero unless something else is non-zero.
+			return nil
+		}
+		err := p.Walk(s)
+		s.cycles.Leave(key)
+		return err
+	}
+}
+
+func compileMapIsZero(reflect.Type) tw.WalkMapFn[*zeroState] {
+	return func(_ *zeroState, m tw.Map[*zeroState]) error {
+		if m.Len() != 0 {
+			return errNotZero
+		}
+		return nil
+	}
+}
+
+func (z *Zeroer) compileInterfaceIsZero(reflect.Type) tw.WalkInterfaceFn[*zeroState] {
+	return func(s *zeroState, i tw.Interface[*zeroState]) error {
+		if i.IsNil() {
+			return nil
+		}
+		v := i.Interface()
+		if z.unsupportedZero.Unsupported(reflect.TypeOf(v)) {
+			if !isZeroValue(reflect.ValueOf(v)) {
+				return errNotZero
+			}
+			return nil
+		}
+		return i.Walk(s)
+	}
+}
+
+// isZeroValue reports whether v is deeply zero, for values which the Walker can't walk.
+func isZeroValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Pointer:
+		return v.IsNil() || isZeroValue(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isZeroValue(v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return v.IsZero()
+}
+
+func (z *Zeroer) registerReset() {
+	tw.RegisterCompileBoolFn(z.reset, compileReset[bool])
+	tw.RegisterCompileIntFn(z.reset, compileReset[int])
+	tw.RegisterCompileInt8Fn(z.reset, compileReset[int8])
+	tw.RegisterCompileInt16Fn(z.reset, compileReset[int16])
+	tw.RegisterCompileInt32Fn(z.reset, compileReset[int32])
+	tw.RegisterCompileInt64Fn(z.reset, compileReset[int64])
+	tw.RegisterCompileUintFn(z.reset, compileReset[uint])
+	tw.RegisterCompileUint8Fn(z.reset, compileReset[uint8])
+	tw.RegisterCompileUint16Fn(z.reset, compileReset[uint16])
+	tw.RegisterCompileUint32Fn(z.reset, compileReset[uint32])
+	tw.RegisterCompileUint64Fn(z.reset, compileReset[uint64])
+	tw.RegisterCompileUintptrFn(z.reset, compileReset[uintptr])
+	tw.RegisterCompileFloat32Fn(z.reset, compileReset[float32])
+	tw.RegisterCompileFloat64Fn(z.reset, compileReset[float64])
+	tw.RegisterCompileComplex64Fn(z.reset, compileReset[complex64])
+	tw.RegisterCompileComplex128Fn(z.reset, compileReset[complex128])
+	tw.RegisterCompileStringFn(z.reset, compileReset[string])
+	tw.RegisterCompileUnsafePointerFn(z.reset, compileReset[unsafe.Pointer])
+	tw.RegisterCompileStructFn(z.reset, z.compileStructReset)
+	tw.RegisterCompileArrayFn(z.reset, compileArrayReset)
+	tw.RegisterCompileSliceFn(z.reset, z.compileSliceReset)
+	tw.RegisterCompilePtrFn(z.reset, compilePtrReset)
+	tw.RegisterCompileMapFn(z.reset, compileMapReset)
+	tw.RegisterCompileInterfaceFn(z.reset, compileInterfaceReset)
+}
+
+// Reset walks values through a pointer, so every value it walks is settable.
+
+func compileReset[T any](reflect.Type) tw.WalkFn[struct{}, T] {
+	return func(_ struct{}, a tw.Arg[T]) error {
+		var zero T
+		a.Set(zero)
+		return nil
+	}
+}
+
+func (z *Zeroer) compileStructReset(t reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[struct{}] {
+	fields := compileStructFields(t, sfr, z.resetRegistered)
+	return func(ctx struct{}, st tw.Struct[struct{}]) error {
+		for i := range fields {
+			f := &fields[i]
+			if f.unsupported {
+				// The field is set through a pointer, since reflect can't set unexported fields.
+				p := unsafe.Add(st.Value().Addr().UnsafePointer(), f.offset)
+				resetValue(reflect.NewAt(f.typ, p).Elem())
+				continue
+			}
+			if err := st.Field(f.num).Walk(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func compileArrayReset(reflect.Type) tw.WalkArrayFn[struct{}] {
+	return func(ctx struct{}, a tw.Array[struct{}]) error {
+		return a.WalkAll(ctx)
+	}
+}
+
+func (z *Zeroer) compileSliceReset(t reflect.Type) tw.WalkSliceFn[struct{}] {
+	// Elements which don't hold any memory to keep are cleared in bulk.
+	clearElems := !z.keepsMemory(t.Elem())
+	return func(ctx struct{}, sl tw.Slice[struct{}]) error {
+		if clearElems {
+			sl.Value().Clear()
+		} else if err := sl.WalkAll(ctx); err != nil {
+			return err
+		}
+		sl.SetLen(0)
+		return nil
+	}
+}
+
+func compilePtrReset(reflect.Type) tw.WalkPtrFn[struct{}] {
+	return func(_ struct{}, p tw.Ptr[struct{}]) error {
+		p.SetNil()
+		return nil
+	}
+}
+
+func compileMapReset(reflect.Type) tw.WalkMapFn[struct{}] {
+	return func(_ struct{}, m tw.Map[struct{}]) error {
+		m.Value().Clear()
+		return nil
+	}
+}
+
+func compileInterfaceReset(reflect.Type) tw.WalkInterfaceFn[struct{}] {
+	return func(_ struct{}, i tw.Interface[struct{}]) error {
+		i.Value().SetZero()
+		return nil
+	}
+}
+
+// resetValue resets v, for values which the Walker can't walk. v must be settable.
+func resetValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Slice:
+		v.Clear()
+		v.SetLen(0)
+	case reflect.Map:
+		v.Clear()
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			resetValue(v.Index(i))
+		}
+	default:
+		v.SetZero()
+	}
+}
+
+// keepsMemory reports whether resetting values of type t does anything other than setting them to zero, because they
+// contain slices or maps whose memory is kept, or types with registered functions.
+func (z *Zeroer) keepsMemory(t reflect.Type) bool {
+	if z.resetRegistered(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map:
+		return true
+	case reflect.Array:
+		return z.keepsMemory(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if z.keepsMemory(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package twzero_test
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zolstein/type-walk/twzero"
+)
+
+type Route struct {
+	Port     int
+	Segments []string
+}
+
+// Request is reset to be reused, e.g. from a sync.Pool.
+type Request struct {
+	Route
+	Method  string
+	Headers map[string][]int
+	Parent  *Route
+	Items   []Route
+	Body    []byte
+	Sent    time.Time
+	attempt int
+	cancel  chan int
+	hooks   []func()
+}
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+func TestIsZero(t *testing.T) {
+	zeroRing := &Node{}
+	zeroRing.Next = &Node{Next: zeroRing}
+	ring := &Node{Value: 1}
+	ring.Next = &Node{Next: ring}
+
+	tests := []struct {
+		name     string
+		v        any
+		expected bool
+	}{
+		{"nil", nil, true},
+		{"negativeZero", math.Copysign(0, -1), true},
+		{"nan", math.NaN(), false},
+		// Unlike reflect.Value.IsZero, empty slices and maps are zero, and so are pointers to zero values.
+		{"emptySlice", []int{}, true},
+		{"slice", []int{0}, false},
+		{"emptyMap", map[string]int{}, true},
+		{"map", map[string]int{"": 0}, false},
+		{"ptr", &Route{Segments: []string{}}, true},
+		{"ptrToPtr", new(*int), true},
+		{"ptrNested", &Route{Segments: []string{""}}, false},
+		{"interfaces", [2]any{0, &Route{}}, true},
+		{"interfaceNonZero", [2]any{0, 1}, false},
+		{"zeroRing", zeroRing, true},
+		{"ring", ring, false},
+		{"unexported", Request{attempt: 1}, false},
+		// Times are zero by their IsZero method, whatever their location.
+		{"timeLocal", time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC).In(time.Local), true},
+		{"timeUnix", time.Unix(0, 0), false},
+		// Channels and functions are zero if they're nil.
+		{"emptyFuncs", []func(){}, true},
+		{"funcs", []func(){nil}, false},
+		{"chanField", Request{cancel: make(chan int)}, false},
+		{"nilChans", [1]chan int{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, twzero.IsZero(test.v))
+		})
+	}
+}
+
+func TestReset(t *testing.T) {
+	parent := &Route{Port: 4, Segments: []string{"p"}}
+	req := Request{
+		Route:   Route{Port: 1, Segments: []string{"x"}},
+		Method:  "GET",
+		Headers: map[string][]int{"a": {1, 2}},
+		Parent:  parent,
+		Items:   []Route{{Port: 1, Segments: make([]string, 2, 4)}},
+		Body:    []byte("body"),
+		Sent:    time.Now(),
+		attempt: 7,
+		cancel:  make(chan int),
+		hooks:   []func(){func() {}},
+	}
+	items, body, headers := req.Items, req.Body, req.Headers
+	twzero.Reset(&req)
+
+	assert.True(t, twzero.IsZero(req))
+	assert.Equal(t, Request{
+		Route:   Route{Segments: []string{}},
+		Headers: map[string][]int{},
+		Items:   []Route{},
+		Body:    []byte{},
+		hooks:   []func(){},
+	}, req)
+	// The memory of slices and maps is kept for reuse.
+	assert.Equal(t, 1, cap(req.Route.Segments))
+	assert.Same(t, &items[0], &req.Items[:1][0])
+	assert.Same(t, &body[0], &req.Body[:1][0])
+	req.Headers["b"] = nil
+	assert.Contains(t, headers, "b")
+	// Elements are reset, keeping their memory too.
+	assert.Equal(t, Route{Segments: make([]string, 0, 4)}, items[0])
+	assert.Equal(t, make([]byte, 4), body)
+	assert.Nil(t, req.hooks[:1][0])
+	// Values referred to by pointers may be shared, so they're left alone.
+	assert.Equal(t, &Route{Port: 4, Segments: []string{"p"}}, parent)
+
+	i := 5
+	twzero.Reset(&i)
+	assert.Equal(t, 0, i)
+
+	// A reset value is zero to reflect too, other than its slices and maps.
+	r := Route{Port: 1}
+	twzero.Reset(&r)
+	assert.True(t, reflect.ValueOf(r).IsZero())
+}
+
+func TestRegister(t *testing.T) {
+	r := twzero.NewRegister()
+	twzero.RegisterKeep[sync.Mutex](r)
+	twzero.RegisterIsZeroFn(r, func(s string) bool {
+		return s == "" || s == "none"
+	})
+	twzero.RegisterResetFn(r, func(s *string) {
+		*s = "none"
+	})
+	z := twzero.NewZeroer(r)
+
+	type Guarded struct {
+		mu   sync.Mutex
+		Name string
+		Tags []string
+	}
+	g := &Guarded{Name: "a", Tags: []string{"b"}}
+	g.mu.Lock()
+	twzero.ResetWith(z, g)
+	assert.False(t, g.mu.TryLock(), "the mutex was reset")
+	assert.Equal(t, "none", g.Name)
+	assert.Equal(t, []string{}, g.Tags)
+	assert.Equal(t, "none", g.Tags[:1][0])
+
+	assert.True(t, z.IsZero(g))
+	assert.False(t, twzero.IsZero(g))
+	assert.True(t, z.IsZero([]any{"none"}[0]))
+}
+
+func BenchmarkReset(b *testing.B) {
+	req := Request{
+		Route:   Route{Port: 1, Segments: []string{"x"}},
+		Headers: map[string][]int{"a": {1, 2}},
+		Items:   []Route{{Port: 1, Segments: make([]string, 2, 4)}},
+		Body:    []byte("body"),
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req.Method, req.Items, req.Headers["a"] = "GET", req.Items[:1], nil
+		twzero.Reset(&req)
+	}
+}
+
+func BenchmarkIsZero(b *testing.B) {
+	var requests [100]Request
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = twzero.IsZero(&requests)
+	}
+}
@@ -3,6 +3,8 @@ package type_walk
 import (
 	"reflect"
 	"slices"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	g_reflect "github.com/goccy/go-reflect"
@@ -47,13 +49,17 @@ func argFor[T any](ptr *T) Arg[T] {
 		arg: arg{
 			p: unsafe.Pointer(ptr),
 			// canAddr is true because we're coming directly through a pointer.
-			canAddr: true,
+			flags: flagCanAddr,
 		},
 	}
 }
 
 type walkerConfig struct {
-	threadSafe bool
+	threadSafe      bool
+	maxCacheEntries int
+	flattenStructs  bool
+	// middleware holds Middleware[Ctx] values. It can't be typed, because WalkerOpt is not generic.
+	middleware []any
 }
 
 // WalkerOpt is an option to configure a new walker.
@@ -64,31 +70,100 @@ var (
 	WithThreadSafe WalkerOpt = func(w *walkerConfig) {
 		w.threadSafe = true
 	}
+
+	// WithFlattenedStructs makes a Walker flatten nested structs of primitive values into their parents, so they are
+	// walked with a single field table and without calling the walk function of the nested struct.
+	//
+	// A struct type is flattened if no function is registered for it with RegisterTypeFn, and the CompileStructFn
+	// registers at least one of its fields, each of which is of a primitive kind (a bool, number or string), of a
+	// struct type with a registered function, or of a struct type that is flattened in turn. When a field of a
+	// flattened type is registered with a StructFieldRegister, the fields the CompileStructFn registered for the
+	// flattened type are registered in its place, and the WalkStructFn it returned is never called for the field.
+	// Fields reached through pointers are never flattened.
+	WithFlattenedStructs WalkerOpt = func(w *walkerConfig) {
+		w.flattenStructs = true
+	}
 )
 
+// WithMaxCacheEntries bounds the number of compiled functions a Walker keeps cached. When a compile pushes the cache
+// over the bound, entries that haven't been looked up recently are evicted, approximating least-recently-used order
+// with a clock sweep, so that each eviction takes constant time on average. Functions registered with RegisterTypeFn
+// are never evicted, and do not count towards the bound.
+//
+// Evicting an entry never invalidates functions compiled before the eviction. If an evicted type is encountered
+// again, it is recompiled. A bound of zero or less means the cache is unbounded, which is the default.
+func WithMaxCacheEntries(n int) WalkerOpt {
+	return func(w *walkerConfig) {
+		w.maxCacheEntries = n
+	}
+}
+
+// CacheStats reports the state of a Walker's cache of compiled functions.
+type CacheStats struct {
+	// Entries is the number of compiled functions currently cached, not including registered functions.
+	Entries int
+	// Hits is the number of lookups that found a cached function. Lookups for the values of interfaces served by their
+	// inline caches are not counted.
+	Hits uint64
+	// Misses is the number of lookups that required compiling a function.
+	Misses uint64
+	// Evictions is the number of functions removed from the cache to stay within its bound.
+	Evictions uint64
+	// CompileTime is the total time spent compiling functions.
+	CompileTime time.Duration
+}
+
+type compiler[Ctx any] interface {
+	getFn(t g_reflect.Type) (*walkFn[Ctx], error)
+	purge()
+	stats() CacheStats
+}
+
 // Walker represents a collection of functions that can be used to walk a value using the Walk method.
 type Walker[Ctx any] struct {
-	getFn fnSrc[Ctx]
+	getFn    fnSrc[Ctx]
+	compiler compiler[Ctx]
 }
 
 // NewWalker creates a new Walker from the registered functions in register.
 // Any new functions that are added to the register after calling NewWalker will not be used by the returned Walker.
+//
+// NewWalker panics with register.Err() if register is strict and has conflicting registrations.
 func NewWalker[Ctx any](register *Register[Ctx], opts ...WalkerOpt) *Walker[Ctx] {
+	if err := register.Err(); err != nil {
+		// Like a nil type, conflicting registrations are a programming error, which the caller can easily prevent.
+		panic(err)
+	}
 	cfg := &walkerConfig{threadSafe: false}
 	for _, opt := range opts {
 		opt(cfg)
 	}
-	var getFn fnSrc[Ctx]
-	if cfg.threadSafe {
-		getFn = newThreadSafeCompiler(register).getFn
+	var c compiler[Ctx]
+	if cfg.threadSafe && cfg.profiled() {
+		c = newProfiledCompiler(register, cfg)
+	} else if cfg.threadSafe {
+		c = newThreadSafeCompiler(register, cfg)
 	} else {
-		getFn = newSimpleCompiler(register).getFn
+		c = newSimpleCompiler(register, cfg)
 	}
 	return &Walker[Ctx]{
-		getFn: getFn,
+		getFn:    c.getFn,
+		compiler: c,
 	}
 }
 
+// Purge removes all compiled functions from the Walker's cache. Functions registered with RegisterTypeFn are kept.
+//
+// Functions previously returned by TypeFnFor remain valid. Purge must not be called from within a compile function.
+func (w *Walker[Ctx]) Purge() {
+	w.compiler.purge()
+}
+
+// CacheStats returns statistics about the Walker's cache of compiled functions.
+func (w *Walker[Ctx]) CacheStats() CacheStats {
+	return w.compiler.stats()
+}
+
 // Walk walks in, calling the registered for each value it encounters.
 func (w *Walker[Ctx]) Walk(ctx Ctx, in any) error {
 	return walk(w.getFn, ctx, in)
@@ -101,21 +176,84 @@ func walk[Ctx any](fnSrc fnSrc[Ctx], ctx Ctx, in any) error {
 		return err
 	}
 	arg := arg{
-		p:         p,
-		canAddr:   reflect.ValueOf(in).CanAddr(),
-		directPtr: isDirectIface(t),
+		p:     p,
+		flags: flagIf(reflect.ValueOf(in).CanAddr(), flagCanAddr) | flagIf(isDirectIface(t), flagDirectPtr),
 	}
 	return (*fn)(ctx, arg)
 }
 
+// WalkValue walks v, calling the registered function for each value it encounters. v must be valid.
+//
+// Unlike Walk, WalkValue walks v as its static type even if it is an interface, and if v is addressable, the walked
+// values are settable and refer to the same memory as v. If v is not addressable it is copied, so like
+// reflect.Value.Interface, WalkValue panics if v was obtained by accessing unexported struct fields.
+func (w *Walker[Ctx]) WalkValue(ctx Ctx, v reflect.Value) error {
+	if v.CanAddr() {
+		return w.WalkUnsafe(ctx, v.Type(), v.Addr().UnsafePointer(), true)
+	}
+	cp := reflect.New(v.Type())
+	cp.Elem().Set(v)
+	return w.WalkUnsafe(ctx, v.Type(), cp.UnsafePointer(), false)
+}
+
+// WalkUnsafe walks the value of type t that p points to, calling the registered function for each value it
+// encounters. It avoids the cost of boxing the value into an interface, and like WalkValue walks it as its static
+// type even if t is an interface.
+//
+// p must point to a valid value of type t, which must not be modified concurrently. If canAddr is true, the walked
+// values are settable, so the memory p points to may be modified; canAddr must be false if this is not permitted,
+// e.g. if p points to a value shared through an interface.
+func (w *Walker[Ctx]) WalkUnsafe(ctx Ctx, t reflect.Type, p unsafe.Pointer, canAddr bool) error {
+	fn, err := w.getFn(g_reflect.ToType(t))
+	if err != nil {
+		return err
+	}
+	return (*fn)(ctx, arg{p: p, flags: flagIf(canAddr, flagCanAddr)})
+}
+
+// valueOf returns a reflect.Value of type t for a. It is addressable iff a is settable.
+func valueOf(t g_reflect.Type, a arg) reflect.Value {
+	rt := g_reflect.ToReflectType(t)
+	if a.wrongAny() {
+		// We have a pointer to an any, rather than to the interface type. Convert it to the correct type.
+		v := reflect.NewAt(reflectAnyType, a.p).Elem().Elem()
+		if !v.IsValid() {
+			return reflect.Zero(rt)
+		}
+		return v.Convert(rt)
+	}
+	v := reflect.NewAt(rt, a.valuePtr()).Elem()
+	if !a.canSet() {
+		// Converting a value to its own type copies it, and makes the copy unaddressable.
+		v = v.Convert(rt)
+	}
+	return v
+}
+
+// valuePtr returns a pointer to the value a refers to. If a is a direct pointer, the pointer is boxed in a new
+// allocation. Only box the pointer when we need to, since taking the address of a would move it to the heap on every
+// call.
+func (a arg) valuePtr() unsafe.Pointer {
+	if !a.directPtr() {
+		return a.p
+	}
+	boxed := new(unsafe.Pointer)
+	*boxed = a.p
+	return unsafe.Pointer(boxed)
+}
+
+var reflectAnyType = reflect.TypeOf((*any)(nil)).Elem()
+
 type fnSrc[Ctx any] func(t g_reflect.Type) (*walkFn[Ctx], error)
 
 var kindOffset uintptr
+var tflagOffset uintptr
 
 const indirFlag = 1 << 5
 
 func init() {
-	kindField := reflect.TypeOf(reflect.TypeOf(struct{}{})).Elem().Field(0).Type.Field(6)
+	typeFields := reflect.TypeOf(reflect.TypeOf(struct{}{})).Elem().Field(0).Type
+	kindField := typeFields.Field(6)
 	if kindField.Name != "Kind_" {
 		panic("Field 'Kind_' for reflect.Type not found.")
 	}
@@ -123,14 +261,23 @@ func init() {
 		panic("Field 'Kind_' for reflect.Type is not uint.")
 	}
 	kindOffset = kindField.Offset
+	tflagField := typeFields.Field(3)
+	if tflagField.Name != "TFlag" {
+		panic("Field 'TFlag' for reflect.Type not found.")
+	}
+	if kt := tflagField.Type.Kind(); kt != reflect.Uint8 {
+		panic("Field 'TFlag' for reflect.Type is not uint.")
+	}
+	tflagOffset = tflagField.Offset
 }
 
 func isDirectIface(t g_reflect.Type) bool {
 	tp := unsafe.Pointer(t)
-	kp := unsafe.Add(tp, kindOffset)
-	rawKind := *(*uint8)(kp)
-	res := rawKind&indirFlag != 0
-	return res
+	// Older runtimes store the direct-interface flag in Kind_, newer ones in TFlag. The bit is unused in the other
+	// field on either version, so checking both works everywhere.
+	rawKind := *(*uint8)(unsafe.Add(tp, kindOffset))
+	rawTFlag := *(*uint8)(unsafe.Add(tp, tflagOffset))
+	return (rawKind|rawTFlag)&indirFlag != 0
 }
 
 // StructFieldRegister stores information about which fields to walk within a struct.
@@ -142,12 +289,24 @@ type structFieldRegister struct {
 	typ     g_reflect.Type
 	indexes [][]int
 	buffer  []int
+	// flatten returns the indexes of the fields that replace a field of the given type, relative to it, if the field
+	// is flattened, or nil otherwise. It is nil unless the Walker was created with WithFlattenedStructs.
+	flatten func(g_reflect.Type) [][]int
 }
 
 // RegisterField registers a field to be available while walking the struct, by its field number.
 // When walking the struct, Struct.Field(n) will return nth field registered.
+//
+// If the Walker was created with WithFlattenedStructs and the field is flattened, the fields of its type which its
+// CompileStructFn registers are registered instead, and the index of the first is returned.
 func (r *structFieldRegister) RegisterField(fieldNum int) int {
 	idx := len(r.indexes)
+	if r.flatten != nil {
+		if flattened := r.flatten(r.typ.Field(fieldNum).Type); flattened != nil {
+			r.registerFlattened([]int{fieldNum}, flattened)
+			return idx
+		}
+	}
 	if r.buffer == nil {
 		r.buffer = slices.Grow(r.buffer, max(r.typ.NumField(), 1))
 	} else {
@@ -166,21 +325,91 @@ func (r *structFieldRegister) RegisterFieldByIndex(index []int) int {
 		panic("index must be non-empty")
 	}
 	idx := len(r.indexes)
+	if r.flatten != nil {
+		if flattened := r.flatten(r.fieldType(index)); flattened != nil {
+			r.registerFlattened(index, flattened)
+			return idx
+		}
+	}
 	r.indexes = append(r.indexes, index)
 	return idx
 }
 
+// NumFields returns the number of fields registered so far. This may be more than the number of calls to
+// RegisterField and RegisterFieldByIndex if fields were flattened.
+func (r *structFieldRegister) NumFields() int {
+	return len(r.indexes)
+}
+
+// FieldIndex returns the index of the nth registered field within the struct, like the Index field of
+// reflect.StructField. This identifies fields registered in place of a flattened field.
+func (r *structFieldRegister) FieldIndex(n int) []int {
+	return slices.Clone(r.indexes[n])
+}
+
+// fieldType returns the type of the field at index, which may go through embedded pointers.
+func (r *structFieldRegister) fieldType(index []int) g_reflect.Type {
+	ft := r.typ
+	for i, x := range index {
+		if i > 0 && ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		ft = ft.Field(x).Type
+	}
+	return ft
+}
+
+// registerFlattened registers the fields at the indexes flattened, relative to the flattened field at index.
+func (r *structFieldRegister) registerFlattened(index []int, flattened [][]int) {
+	for _, fieldIndex := range flattened {
+		r.indexes = append(r.indexes, append(slices.Clip(index), fieldIndex...))
+	}
+}
+
 type structMetadata[Ctx any] struct {
 	typ       g_reflect.Type
 	fieldInfo []structFieldMetadata[Ctx]
 }
 
 type structFieldMetadata[Ctx any] struct {
-	typ    g_reflect.Type
+	typ     g_reflect.Type
+	index   []int
+	offsets []uintptr
+	// offset is the offset of the field within the struct, if it is not reached through an embedded pointer.
+	offset uintptr
+	// lookup finds fields reached through embedded pointers. It is nil for all other fields, which are found by
+	// offset, to avoid an indirect call.
 	lookup lookupFn
 	fn     *walkFn[Ctx]
 }
 
+// fieldArg returns the arg for the field within the struct a.
+func (m *structFieldMetadata[Ctx]) fieldArg(a arg) arg {
+	if a.paired() {
+		return m.pairedFieldArg(a)
+	}
+	return m.unpairedFieldArg(a)
+}
+
+// unpairedFieldArg is fieldArg for a struct that isn't paired. WalkAll checks whether the struct is paired once, and
+// calls it for each field, which keeps the cost of pairs out of unpaired walks.
+func (m *structFieldMetadata[Ctx]) unpairedFieldArg(a arg) arg {
+	if m.lookup != nil {
+		return m.lookup(a)
+	}
+	a.p = unsafe.Add(a.p, m.offset)
+	return a
+}
+
+func (m *structFieldMetadata[Ctx]) pairedFieldArg(a arg) arg {
+	if m.lookup == nil {
+		a.p = unsafe.Add(a.p, m.offset)
+		a.q = unsafe.Add(a.q, m.offset)
+		return a
+	}
+	return m.lookup(a).pairedWith(m.lookup(a.pairArg()))
+}
+
 // Struct represents a struct value.
 type Struct[Ctx any] struct {
 	meta *structMetadata[Ctx]
@@ -198,21 +427,72 @@ func (s Struct[Ctx]) Field(idx int) StructField[Ctx] {
 	meta := &s.meta.fieldInfo[idx]
 	return StructField[Ctx]{
 		meta: meta,
-		arg:  meta.lookup(s.arg),
+		arg:  meta.fieldArg(s.arg),
+	}
+}
+
+// FieldAs returns a registered field as an Arg[T], by index in the order the fields were registered, if the field's
+// type is exactly T. Otherwise, or if the field is not valid, it returns false.
+//
+// This allows reading and setting fields directly, without walking them. The returned Arg is settable if the struct
+// is addressable, or if the field is reached through an embedded pointer.
+// idx must be in the range [0..NumFields())
+func FieldAs[T any, Ctx any](s Struct[Ctx], idx int) (Arg[T], bool) {
+	meta := &s.meta.fieldInfo[idx]
+	if meta.typ != reflectType[T]() {
+		return Arg[T]{}, false
 	}
+	a := meta.fieldArg(s.arg)
+	if !a.directPtr() && a.p == nil {
+		return Arg[T]{}, false
+	}
+	return Arg[T]{arg: a}, true
+}
+
+// WalkAll walks each registered field in order, skipping fields that are not valid. It stops and returns the first
+// error encountered.
+func (s Struct[Ctx]) WalkAll(ctx Ctx) error {
+	if s.arg.paired() {
+		return s.walkAllPaired(ctx)
+	}
+	for i := range s.meta.fieldInfo {
+		meta := &s.meta.fieldInfo[i]
+		f := StructField[Ctx]{meta: meta, arg: meta.unpairedFieldArg(s.arg)}
+		if !f.IsValid() {
+			continue
+		}
+		if err := f.Walk(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Struct[Ctx]) walkAllPaired(ctx Ctx) error {
+	for i := range s.meta.fieldInfo {
+		f := s.Field(i)
+		if !f.IsValid() {
+			continue
+		}
+		if err := f.Walk(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Interface returns the underlying value as an interface.
 func (s Struct[Ctx]) Interface() any {
-	var ptr unsafe.Pointer
-	if s.arg.directPtr {
-		ptr = unsafe.Pointer(&s.arg.p)
-	} else {
-		ptr = s.arg.p
-	}
+	ptr := s.arg.valuePtr()
 	return g_reflect.NewAt(s.meta.typ, ptr).Elem().Interface()
 }
 
+// Value returns the underlying value as a reflect.Value. If the struct is addressable, the reflect.Value is
+// addressable and refers to the same memory, otherwise it is a copy.
+func (s Struct[Ctx]) Value() reflect.Value {
+	return valueOf(s.meta.typ, s.arg)
+}
+
 // StructField represents the field of a struct.
 type StructField[Ctx any] struct {
 	meta *structFieldMetadata[Ctx]
@@ -236,7 +516,7 @@ type StructField[Ctx any] struct {
 //	    }
 //	}
 func (f StructField[Ctx]) IsValid() bool {
-	return f.arg.directPtr || f.arg.p != nil
+	return f.arg.directPtr() || f.arg.p != nil
 }
 
 // Walk walks the StructField. The StructField must be valid.
@@ -273,30 +553,79 @@ func (a Array[Ctx]) Elem(idx int) ArrayElem[Ctx] {
 	if idx < 0 || idx >= a.meta.length {
 		panic("Index out of bounds")
 	}
-
-	elemArg := arg{
-		p: unsafe.Add(a.arg.p, a.meta.elemSize*uintptr(idx)),
-		// An element of an array is addressable iff the array is addressable.
-		canAddr:   a.arg.canAddr,
-		directPtr: a.arg.directPtr,
+	e := a.unpairedElem(idx)
+	if a.arg.paired() {
+		e.arg.q = unsafe.Add(a.arg.q, a.meta.elemSize*uintptr(idx))
+		e.arg.flags |= flagPaired
 	}
+	return e
+}
+
+// unpairedElem is Elem without the bounds check, for an array that isn't paired, or that will be paired by Elem.
+func (a Array[Ctx]) unpairedElem(idx int) ArrayElem[Ctx] {
 	return ArrayElem[Ctx]{
 		meta: a.meta,
-		arg:  elemArg,
+		arg: arg{
+			p: unsafe.Add(a.arg.p, a.meta.elemSize*uintptr(idx)),
+			// An element of an array is addressable iff the array is addressable.
+			flags: a.arg.flags & (flagCanAddr | flagDirectPtr),
+		},
+	}
+}
+
+// WalkAll walks each element of the array in order. It stops and returns the first error encountered.
+func (a Array[Ctx]) WalkAll(ctx Ctx) error {
+	if a.arg.paired() {
+		for i := 0; i < a.meta.length; i++ {
+			if err := a.Elem(i).Walk(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < a.meta.length; i++ {
+		if err := a.unpairedElem(i).Walk(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArrayAs returns the elements of the array as a []T, if the array's element type is exactly T. Otherwise, it returns
+// nil and false.
+//
+// If the array is addressable, the returned slice shares its storage, so setting elements of the slice sets elements
+// of the array. Otherwise, the returned slice is a copy.
+func ArrayAs[T any, Ctx any](a Array[Ctx]) ([]T, bool) {
+	if a.meta.typ.Elem() != reflectType[T]() {
+		return nil, false
+	}
+	if a.arg.directPtr() {
+		// The array is stored directly in an interface, so it must consist of a single pointer-shaped element.
+		return []T{*(*T)(unsafe.Pointer(&a.arg.p))}, true
+	}
+	if a.meta.length == 0 {
+		return []T{}, true
 	}
+	s := unsafe.Slice((*T)(a.arg.p), a.meta.length)
+	if !a.arg.canAddr() {
+		s = slices.Clone(s)
+	}
+	return s, true
 }
 
 // Interface returns the underlying value as an interface.
 func (a Array[Ctx]) Interface() any {
-	var ptr unsafe.Pointer
-	if a.arg.directPtr {
-		ptr = unsafe.Pointer(&a.arg.p)
-	} else {
-		ptr = a.arg.p
-	}
+	ptr := a.arg.valuePtr()
 	return g_reflect.NewAt(a.meta.typ, ptr).Elem().Interface()
 }
 
+// Value returns the underlying value as a reflect.Value. If the array is addressable, the reflect.Value is
+// addressable and refers to the same memory, otherwise it is a copy.
+func (a Array[Ctx]) Value() reflect.Value {
+	return valueOf(a.meta.typ, a.arg)
+}
+
 // ArrayElem represents an element of an array.
 type ArrayElem[Ctx any] struct {
 	meta *arrayMetadata[Ctx]
@@ -347,23 +676,105 @@ func (s Slice[Ctx]) Elem(idx int) SliceElem[Ctx] {
 	if idx < 0 || idx >= len(slice) {
 		panic("Index out of bounds")
 	}
-	p := unsafe.Pointer(unsafe.SliceData(slice))
-	elemArg := arg{
-		p: unsafe.Add(p, s.meta.elemSize*uintptr(idx)),
-		// An element of a slice is always addressable because the slice implicitly includes a pointer.
-		canAddr: true,
+	e := s.unpairedElem(slice, idx)
+	if s.arg.paired() {
+		if pair := *(*[]struct{})(s.arg.q); idx < len(pair) {
+			e.arg.q = unsafe.Add(unsafe.Pointer(unsafe.SliceData(pair)), s.meta.elemSize*uintptr(idx))
+			e.arg.flags |= flagPaired
+		}
 	}
+	return e
+}
+
+// unpairedElem is Elem without the bounds check, for a slice that isn't paired, or that will be paired by Elem.
+func (s Slice[Ctx]) unpairedElem(slice []struct{}, idx int) SliceElem[Ctx] {
 	return SliceElem[Ctx]{
 		meta: s.meta,
-		arg:  elemArg,
+		arg: arg{
+			p: unsafe.Add(unsafe.Pointer(unsafe.SliceData(slice)), s.meta.elemSize*uintptr(idx)),
+			// An element of a slice is always addressable because the slice implicitly includes a pointer.
+			flags: flagCanAddr,
+		},
 	}
 }
 
+// WalkAll walks each element of the slice in order. It stops and returns the first error encountered.
+func (s Slice[Ctx]) WalkAll(ctx Ctx) error {
+	if s.arg.paired() {
+		for i, n := 0, s.Len(); i < n; i++ {
+			if err := s.Elem(i).Walk(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	slice := s.argSlice()
+	for i := range slice {
+		if err := s.unpairedElem(slice, i).Walk(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SliceAs returns the slice as a []T, if the slice's element type is exactly T. Otherwise, it returns nil and false.
+//
+// The returned slice shares its storage with the walked slice, so setting elements of one sets elements of the other.
+// This allows bulk processing of slices of numbers or bytes, without walking each element.
+func SliceAs[T any, Ctx any](s Slice[Ctx]) ([]T, bool) {
+	if s.meta.typ.Elem() != reflectType[T]() {
+		return nil, false
+	}
+	return *(*[]T)(s.arg.p), true
+}
+
 // Interface returns the underlying value as an interface.
 func (a Slice[Ctx]) Interface() any {
 	return g_reflect.NewAt(a.meta.typ, a.arg.p).Elem().Interface()
 }
 
+// Value returns the underlying value as a reflect.Value. If the slice is addressable, the reflect.Value is
+// addressable and refers to the same memory, otherwise it is a copy. Either way, it shares its elements.
+func (a Slice[Ctx]) Value() reflect.Value {
+	return valueOf(a.meta.typ, a.arg)
+}
+
+// CanSet returns whether the slice value is settable. Calling SetNil or SetLen on a slice that is not settable panics.
+func (s Slice[Ctx]) CanSet() bool {
+	return s.arg.canSet()
+}
+
+// SetNil sets the slice value to nil. The slice must be settable.
+func (s Slice[Ctx]) SetNil() {
+	if !s.CanSet() {
+		panic("SetNil called on a slice that's not settable.")
+	}
+	*(*[]struct{})(s.arg.p) = nil
+}
+
+// SetLen sets the length of the slice value to n. The slice must be settable.
+//
+// If n is greater than the capacity of the slice, a new backing array is allocated, growing the capacity the same way
+// append does, and the existing elements are copied into it. Elements exposed by growing the length are set to zero.
+func (s Slice[Ctx]) SetLen(n int) {
+	if !s.CanSet() {
+		panic("SetLen called on a slice that's not settable.")
+	}
+	slice := s.argSlice()
+	if n > cap(slice) {
+		s.Value().Grow(n - len(slice))
+		slice = s.argSlice()
+	}
+	oldLen := len(slice)
+	*(*[]struct{})(s.arg.p) = slice[:n]
+	if n > oldLen {
+		v := s.Value()
+		for i := oldLen; i < n; i++ {
+			v.Index(i).SetZero()
+		}
+	}
+}
+
 func (s Slice[Ctx]) argSlice() []struct{} {
 	return *(*[]struct{})(s.arg.p)
 }
@@ -398,7 +809,7 @@ type Ptr[Ctx any] struct {
 
 // IsNil returns if the pointer value is nil.
 func (p Ptr[Ctx]) IsNil() bool {
-	if p.arg.directPtr {
+	if p.arg.directPtr() {
 		return p.arg.p == nil
 	} else {
 		return *castTo[*unsafe.Pointer](p.arg.p) == nil
@@ -409,7 +820,7 @@ func (p Ptr[Ctx]) IsNil() bool {
 // The pointer value must not be nil.
 func (p Ptr[Ctx]) Walk(ctx Ctx) error {
 	var elemPtr unsafe.Pointer
-	if p.arg.directPtr {
+	if p.arg.directPtr() {
 		elemPtr = p.arg.p
 	} else {
 		elemPtr = *castTo[*unsafe.Pointer](p.arg.p)
@@ -417,22 +828,56 @@ func (p Ptr[Ctx]) Walk(ctx Ctx) error {
 	elemArg := arg{
 		p: elemPtr,
 		// The value behind a pointer is always addressable - we have the pointer!
-		canAddr: true,
+		flags: flagCanAddr,
+	}
+	if p.arg.paired() {
+		pairPtr := p.arg.q
+		if !p.arg.directPtr() {
+			pairPtr = *castTo[*unsafe.Pointer](pairPtr)
+		}
+		if pairPtr != nil {
+			elemArg.q = pairPtr
+			elemArg.flags |= flagPaired
+		}
 	}
 	return (*p.meta.elemFn)(ctx, elemArg)
 }
 
 // Interface returns the underlying value as an interface.
 func (p Ptr[Ctx]) Interface() any {
-	var ptr unsafe.Pointer
-	if p.arg.directPtr {
-		ptr = unsafe.Pointer(&p.arg.p)
-	} else {
-		ptr = p.arg.p
-	}
+	ptr := p.arg.valuePtr()
 	return g_reflect.NewAt(p.meta.typ, ptr).Elem().Interface()
 }
 
+// Value returns the underlying value as a reflect.Value. If the pointer is addressable, the reflect.Value is
+// addressable and refers to the same memory, otherwise it is a copy.
+func (p Ptr[Ctx]) Value() reflect.Value {
+	return valueOf(p.meta.typ, p.arg)
+}
+
+// CanSet returns whether the pointer value is settable. Calling SetNil or Alloc on a pointer that is not settable
+// panics.
+func (p Ptr[Ctx]) CanSet() bool {
+	return p.arg.canSet()
+}
+
+// SetNil sets the pointer value to nil. The pointer must be settable.
+func (p Ptr[Ctx]) SetNil() {
+	if !p.CanSet() {
+		panic("SetNil called on a pointer that's not settable.")
+	}
+	*castTo[*unsafe.Pointer](p.arg.p) = nil
+}
+
+// Alloc sets the pointer value to point at a newly allocated zero value of its element type. The pointer must be
+// settable.
+func (p Ptr[Ctx]) Alloc() {
+	if !p.CanSet() {
+		panic("Alloc called on a pointer that's not settable.")
+	}
+	*castTo[*unsafe.Pointer](p.arg.p) = reflect.New(g_reflect.ToReflectType(p.meta.typ.Elem())).UnsafePointer()
+}
+
 type mapMetadata[Ctx any] struct {
 	typ       g_reflect.Type
 	keyFn     *walkFn[Ctx]
@@ -449,7 +894,7 @@ type Map[Ctx any] struct {
 
 // IsNil returns whether the map value is nil.
 func (m Map[Ctx]) IsNil() bool {
-	if m.arg.directPtr {
+	if m.arg.directPtr() {
 		// We have the map pointer directly
 		return m.arg.p == nil
 	} else {
@@ -460,38 +905,87 @@ func (m Map[Ctx]) IsNil() bool {
 
 // Iter returns an iterator over the elements of the map.
 func (m Map[Ctx]) Iter() MapIter[Ctx] {
-	var ptr unsafe.Pointer
-	if m.arg.directPtr {
-		// We have the map pointer directly
-		ptr = unsafe.Pointer(&m.arg.p)
-	} else {
-		// Normal case: we have a pointer to the map pointer
-		ptr = m.arg.p
-	}
+	ptr := m.arg.valuePtr()
 	rMap := g_reflect.NewAt(m.meta.typ, ptr).Elem()
-	return MapIter[Ctx]{
+	iter := MapIter[Ctx]{
 		meta: m.meta,
 		iter: rMap.MapRange(),
 	}
+	if pair, ok := m.Pair(); ok {
+		iter.pair = reflect.ValueOf(pair.Interface())
+		iter.paired = true
+	}
+	return iter
+}
+
+// WalkAll walks the key and then the value of each entry in the map, in the map's iteration order. It stops and
+// returns the first error encountered.
+func (m Map[Ctx]) WalkAll(ctx Ctx) error {
+	iter := m.Iter()
+	for iter.Next() {
+		entry := iter.Entry()
+		if err := entry.Key().Walk(ctx); err != nil {
+			return err
+		}
+		if err := entry.Value().Walk(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Interface returns the underlying value as an interface.
 func (m Map[Ctx]) Interface() any {
-	var ptr unsafe.Pointer
-	if m.arg.directPtr {
-		// We have the map pointer directly
-		ptr = unsafe.Pointer(&m.arg.p)
-	} else {
-		// Normal case: we have a pointer to the map pointer
-		ptr = m.arg.p
-	}
+	ptr := m.arg.valuePtr()
 	return g_reflect.NewAt(m.meta.typ, ptr).Elem().Interface()
 }
 
+// Value returns the underlying value as a reflect.Value. If the map is addressable, the reflect.Value is addressable
+// and refers to the same memory, otherwise it is a copy. Either way, it shares its entries.
+func (m Map[Ctx]) Value() reflect.Value {
+	return valueOf(m.meta.typ, m.arg)
+}
+
+// Len returns the number of entries in the map value.
+func (m Map[Ctx]) Len() int {
+	return g_reflect.NewAt(m.meta.typ, m.arg.valuePtr()).Elem().Len()
+}
+
+// CanSet returns whether the map value is settable. Calling SetNil or Alloc on a map that is not settable panics.
+// Entries can be set with SetIndex even if the map is not settable, since the map refers to them.
+func (m Map[Ctx]) CanSet() bool {
+	return m.arg.canSet()
+}
+
+// SetNil sets the map value to nil. The map must be settable.
+func (m Map[Ctx]) SetNil() {
+	if !m.CanSet() {
+		panic("SetNil called on a map that's not settable.")
+	}
+	*castTo[*unsafe.Pointer](m.arg.p) = nil
+}
+
+// Alloc sets the map value to a new empty map. The map must be settable.
+func (m Map[Ctx]) Alloc() {
+	if !m.CanSet() {
+		panic("Alloc called on a map that's not settable.")
+	}
+	m.Value().Set(reflect.MakeMap(g_reflect.ToReflectType(m.meta.typ)))
+}
+
+// SetIndex sets the entry for key in the map value to elem, like reflect.Value.SetMapIndex. If elem is the zero
+// reflect.Value, it deletes the entry for key instead. The map must not be nil.
+func (m Map[Ctx]) SetIndex(key, elem reflect.Value) {
+	m.Value().SetMapIndex(key, elem)
+}
+
 // MapIter represents an iterator over the entries of the map.
 type MapIter[Ctx any] struct {
 	meta *mapMetadata[Ctx]
 	iter *g_reflect.MapIter
+	// pair is the paired map in a lock-step walk, if paired is true.
+	pair   reflect.Value
+	paired bool
 }
 
 // Next advances the MapIter to the next entry in the map.
@@ -501,11 +995,19 @@ func (m MapIter[Ctx]) Next() bool {
 
 // Entry returns a MapEntry representing a key and value in the map.
 func (m MapIter[Ctx]) Entry() MapEntry[Ctx] {
-	return MapEntry[Ctx]{
+	key := m.iter.Key()
+	entry := MapEntry[Ctx]{
 		meta: m.meta,
-		key:  m.iter.Key().Interface(),
+		key:  key.Interface(),
 		val:  m.iter.Value().Interface(),
 	}
+	if m.paired {
+		if pairVal := m.pair.MapIndex(key); pairVal.IsValid() {
+			entry.pairVal = pairVal.Interface()
+			entry.paired = true
+		}
+	}
+	return entry
 }
 
 // MapEntry represents a key and value in the map.
@@ -513,56 +1015,62 @@ type MapEntry[Ctx any] struct {
 	meta *mapMetadata[Ctx]
 	key  any
 	val  any
+	// pairVal is the value for the same key in the paired map in a lock-step walk, if paired is true.
+	pairVal any
+	paired  bool
 }
 
 // Key returns a MapKey representing a key in the map.
 func (m MapEntry[Ctx]) Key() MapKey[Ctx] {
 	return MapKey[Ctx]{
-		meta: m.meta,
-		key:  m.key,
+		meta:   m.meta,
+		key:    m.key,
+		paired: m.paired,
 	}
 }
 
 // Value returns a MapValue representing a value in the map.
 func (m MapEntry[Ctx]) Value() MapValue[Ctx] {
 	return MapValue[Ctx]{
-		meta: m.meta,
-		val:  m.val,
+		meta:    m.meta,
+		val:     m.val,
+		pairVal: m.pairVal,
+		paired:  m.paired,
 	}
 }
 
+// HasPair returns whether the paired map has an entry with the same key, in a lock-step walk. If it does, the key and
+// value are walked paired with the key and value of that entry.
+func (m MapEntry[Ctx]) HasPair() bool {
+	return m.paired
+}
+
+// entryArg returns the arg for v, a key or value of type t in a map. conv converts v to t if t is an interface type.
+func entryArg(t g_reflect.Type, conv ifaceConvertFn, v *any) arg {
+	if t.Kind() == reflect.Interface {
+		if conv != nil {
+			return arg{p: conv(*v)}
+		}
+		return arg{p: unsafe.Pointer(v), flags: flagWrongAny}
+	}
+	_, ptr := g_reflect.TypeAndPtrOf(*v)
+	return arg{p: ptr, flags: flagIf(isDirectIface(t), flagDirectPtr)}
+}
+
 // MapKey represents a key in the map.
 type MapKey[Ctx any] struct {
 	meta *mapMetadata[Ctx]
 	key  any
+	// paired is true if the key is paired with itself, because the paired map has the same key.
+	paired bool
 }
 
 // Walk walks the MapKey.
 func (m MapKey[Ctx]) Walk(ctx Ctx) error {
-	kind := m.meta.typ.Key().Kind()
-	var a arg
-	if kind == reflect.Interface {
-		if m.meta.keyConvFn != nil {
-			a = arg{
-				p:       m.meta.keyConvFn(m.key),
-				canAddr: false,
-			}
-		} else {
-			a = arg{
-				p:        unsafe.Pointer(&m.key),
-				canAddr:  false,
-				wrongAny: true,
-			}
-		}
-	} else {
-		_, ptr := g_reflect.TypeAndPtrOf(m.key)
-		a = arg{
-			p:         ptr,
-			canAddr:   false,
-			directPtr: isDirectIface(m.meta.typ.Key()),
-		}
+	a := entryArg(m.meta.typ.Key(), m.meta.keyConvFn, &m.key)
+	if m.paired {
+		a = a.pairedWith(a)
 	}
-
 	return (*m.meta.keyFn)(ctx, a)
 }
 
@@ -573,36 +1081,18 @@ func (m MapKey[Ctx]) Interface() any {
 
 // MapValue represents a value in the map.
 type MapValue[Ctx any] struct {
-	meta *mapMetadata[Ctx]
-	val  any
+	meta    *mapMetadata[Ctx]
+	val     any
+	pairVal any
+	paired  bool
 }
 
 // Walk walks the MapValue.
 func (m MapValue[Ctx]) Walk(ctx Ctx) error {
-	kind := m.meta.typ.Elem().Kind()
-	var a arg
-	if kind == reflect.Interface {
-		if m.meta.valConvFn != nil {
-			a = arg{
-				p:       m.meta.valConvFn(m.val),
-				canAddr: false,
-			}
-		} else {
-			a = arg{
-				p:        unsafe.Pointer(&m.val),
-				canAddr:  false,
-				wrongAny: true,
-			}
-		}
-	} else {
-		_, ptr := g_reflect.TypeAndPtrOf(m.val)
-		a = arg{
-			p:         ptr,
-			canAddr:   false,
-			directPtr: isDirectIface(m.meta.typ.Elem()),
-		}
+	a := entryArg(m.meta.typ.Elem(), m.meta.valConvFn, &m.val)
+	if m.paired {
+		a = a.pairedWith(entryArg(m.meta.typ.Elem(), m.meta.valConvFn, &m.pairVal))
 	}
-
 	return (*m.meta.valFn)(ctx, a)
 }
 
@@ -615,6 +1105,72 @@ func (m MapValue[Ctx]) Interface() any {
 type ifaceMetadata[Ctx any] struct {
 	typ   g_reflect.Type
 	fnSrc fnSrc[Ctx]
+	// cache remembers the functions for the concrete types most recently found in the interface. It is nil if the
+	// Walker's cache is bounded, so every lookup counts towards keeping its function cached.
+	cache *ifaceCache[Ctx]
+}
+
+// ifaceCacheSize is the number of concrete types remembered by an ifaceCache. Most interfaces only ever hold one or a
+// few concrete types, so a small cache avoids looking up the function for almost every value.
+const ifaceCacheSize = 4
+
+// ifaceCacheMaxMisses is the number of misses after which an ifaceCache is abandoned, because the interface holds too
+// many different concrete types for it to help.
+const ifaceCacheMaxMisses = 16 * ifaceCacheSize
+
+// ifaceCache is an inline cache of the functions for the concrete types found in an interface. It is safe for
+// concurrent use.
+type ifaceCache[Ctx any] struct {
+	entries [ifaceCacheSize]atomic.Pointer[ifaceCacheEntry[Ctx]]
+	// misses counts the misses, and determines the slot replaced by the next one.
+	misses atomic.Uint32
+	// megamorphic is set once there have been ifaceCacheMaxMisses misses, after which the cache is not used.
+	megamorphic atomic.Bool
+	// epoch is the compiler's epoch, which changes whenever it purges or evicts functions. Entries from an earlier
+	// epoch are ignored, so removed functions are looked up or recompiled as usual.
+	epoch *atomic.Uint64
+	// touch is set if the compiler's cache is bounded, so hits must mark the entries they use as referenced, to keep
+	// them from being evicted.
+	touch bool
+}
+
+type ifaceCacheEntry[Ctx any] struct {
+	typ       g_reflect.Type
+	fn        *walkFn[Ctx]
+	directPtr bool
+	epoch     uint64
+}
+
+// lookup returns the function to walk a value of concrete type t, and whether values of type t are stored directly in
+// interfaces.
+func (m *ifaceMetadata[Ctx]) lookup(t g_reflect.Type) (*walkFn[Ctx], bool, error) {
+	c := m.cache
+	if c == nil || c.megamorphic.Load() {
+		fn, err := m.fnSrc(t)
+		return fn, isDirectIface(t), err
+	}
+	epoch := c.epoch.Load()
+	for i := range c.entries {
+		if e := c.entries[i].Load(); e != nil && e.typ == t && e.epoch == epoch {
+			if c.touch {
+				if ref := &entryOf(e.fn).referenced; !ref.Load() {
+					ref.Store(true)
+				}
+			}
+			return e.fn, e.directPtr, nil
+		}
+	}
+	fn, err := m.fnSrc(t)
+	if err != nil {
+		return nil, false, err
+	}
+	e := &ifaceCacheEntry[Ctx]{typ: t, fn: fn, directPtr: isDirectIface(t), epoch: epoch}
+	misses := c.misses.Add(1)
+	if misses >= ifaceCacheMaxMisses {
+		c.megamorphic.Store(true)
+	}
+	c.entries[(misses-1)%ifaceCacheSize].Store(e)
+	return fn, e.directPtr, nil
 }
 
 // Interface represents an interface value.
@@ -631,15 +1187,33 @@ func (i Interface[Ctx]) IsNil() bool {
 // Walk walks the concrete value of the interface by type.
 // The interface value must not be nil.
 func (i Interface[Ctx]) Walk(ctx Ctx) error {
-	iface := i.Interface()
-	return walk(i.meta.fnSrc, ctx, iface)
+	t, p := g_reflect.TypeAndPtrOf(i.Interface())
+	fn, directPtr, err := i.meta.lookup(t)
+	if err != nil {
+		return err
+	}
+	// The concrete value is not addressable, since it's stored in an interface.
+	a := arg{p: p, flags: flagIf(directPtr, flagDirectPtr)}
+	if pair, ok := i.Pair(); ok {
+		if pt, pp := g_reflect.TypeAndPtrOf(pair.Interface()); pt == t {
+			a.q = pp
+			a.flags |= flagPaired
+		}
+	}
+	return (*fn)(ctx, a)
 }
 
 // Interface returns the underlying value as an interface.
 func (i Interface[Ctx]) Interface() any {
-	if i.arg.wrongAny {
+	if i.arg.wrongAny() {
 		return g_reflect.NewAt(reflectType[any](), i.arg.p).Elem().Interface()
 	} else {
 		return g_reflect.NewAt(i.meta.typ, i.arg.p).Elem().Interface()
 	}
 }
+
+// Value returns the underlying value as a reflect.Value of the interface type. If the interface is addressable, the
+// reflect.Value is addressable and refers to the same memory, otherwise it is a copy.
+func (i Interface[Ctx]) Value() reflect.Value {
+	return valueOf(i.meta.typ, i.arg)
+}
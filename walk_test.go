@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 	"unsafe"
 )
 
@@ -1922,3 +1923,666 @@ type IntPtrWrapper int
 func (i *IntPtrWrapper) String() string {
 	return strconv.Itoa(int(*i))
 }
+
+func TestWalkAll(t *testing.T) {
+	type Y struct {
+		Z int
+	}
+	type S struct {
+		A []int
+		B [2]int
+		M map[int]int
+		*Y
+	}
+
+	register := tw.NewRegister[*[]int]()
+	errStop := errors.New("stop")
+	tw.RegisterTypeFn(register, func(ctx *[]int, i tw.Int) error {
+		*ctx = append(*ctx, i.Get())
+		if i.Get() < 0 {
+			return errStop
+		}
+		return nil
+	})
+	tw.RegisterCompileSliceFn(register, func(typ reflect.Type) tw.WalkSliceFn[*[]int] {
+		return func(ctx *[]int, s tw.Slice[*[]int]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompileArrayFn(register, func(typ reflect.Type) tw.WalkArrayFn[*[]int] {
+		return func(ctx *[]int, a tw.Array[*[]int]) error {
+			return a.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompileMapFn(register, func(typ reflect.Type) tw.WalkMapFn[*[]int] {
+		return func(ctx *[]int, m tw.Map[*[]int]) error {
+			return m.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*[]int] {
+		for _, f := range reflect.VisibleFields(typ) {
+			if !f.Anonymous {
+				sfr.RegisterFieldByIndex(f.Index)
+			}
+		}
+		return func(ctx *[]int, s tw.Struct[*[]int]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	walker := tw.NewWalker(register)
+
+	{
+		var ctx []int
+		err := walker.Walk(&ctx, S{A: []int{1, 2}, B: [2]int{3, 4}, M: map[int]int{5: 6}, Y: &Y{Z: 7}})
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7}, ctx)
+	}
+	{
+		// Z is invalid, because Y is nil, so it is skipped.
+		var ctx []int
+		err := walker.Walk(&ctx, S{A: []int{1}, B: [2]int{3, 4}})
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 3, 4}, ctx)
+	}
+	{
+		var ctx []int
+		err := walker.Walk(&ctx, S{A: []int{1, -2, 3}, B: [2]int{4, 5}})
+		require.ErrorIs(t, err, errStop)
+		assert.Equal(t, []int{1, -2}, ctx)
+	}
+	{
+		var ctx []int
+		err := walker.Walk(&ctx, S{B: [2]int{-4, 5}})
+		require.ErrorIs(t, err, errStop)
+		assert.Equal(t, []int{-4}, ctx)
+	}
+	{
+		var ctx []int
+		err := walker.Walk(&ctx, S{M: map[int]int{-1: 2}})
+		require.ErrorIs(t, err, errStop)
+		assert.Equal(t, []int{0, 0, -1}, ctx)
+	}
+}
+
+func TestSliceAs(t *testing.T) {
+	type MyFloat float64
+	register := tw.NewRegister[*[]float64]()
+	tw.RegisterTypeFn(register, func(*[]float64, tw.Float64) error { return nil })
+	tw.RegisterCompileSliceFn(register, func(typ reflect.Type) tw.WalkSliceFn[*[]float64] {
+		return func(ctx *[]float64, s tw.Slice[*[]float64]) error {
+			_, ok := tw.SliceAs[MyFloat](s)
+			assert.False(t, ok)
+			fs, ok := tw.SliceAs[float64](s)
+			require.True(t, ok)
+			*ctx = append(*ctx, fs...)
+			for i := range fs {
+				fs[i] *= 2
+			}
+			return nil
+		}
+	})
+	walker := tw.NewWalker(register)
+
+	var ctx []float64
+	v := []float64{1, 2, 3}
+	require.NoError(t, walker.Walk(&ctx, v))
+	assert.Equal(t, []float64{1, 2, 3}, ctx)
+	// The returned slice shares storage.
+	assert.Equal(t, []float64{2, 4, 6}, v)
+
+	ctx = nil
+	require.NoError(t, walker.Walk(&ctx, []float64(nil)))
+	assert.Empty(t, ctx)
+}
+
+func TestArrayAs(t *testing.T) {
+	register := tw.NewRegister[*[]int32]()
+	tw.RegisterTypeFn(register, func(*[]int32, tw.Int32) error { return nil })
+	tw.RegisterCompileArrayFn(register, func(typ reflect.Type) tw.WalkArrayFn[*[]int32] {
+		return func(ctx *[]int32, a tw.Array[*[]int32]) error {
+			_, ok := tw.ArrayAs[int64](a)
+			assert.False(t, ok)
+			is, ok := tw.ArrayAs[int32](a)
+			require.True(t, ok)
+			*ctx = append(*ctx, is...)
+			for i := range is {
+				is[i] *= 2
+			}
+			return nil
+		}
+	})
+	walker := tw.NewWalker(register)
+
+	{
+		var ctx []int32
+		v := [3]int32{1, 2, 3}
+		require.NoError(t, walker.Walk(&ctx, v))
+		assert.Equal(t, []int32{1, 2, 3}, ctx)
+	}
+	{
+		// Through a pointer, the array is addressable, so the returned slice shares storage.
+		var ctx []int32
+		v := [3]int32{1, 2, 3}
+		typeFn, err := tw.TypeFnFor[[3]int32](walker)
+		require.NoError(t, err)
+		require.NoError(t, typeFn(&ctx, &v))
+		assert.Equal(t, []int32{1, 2, 3}, ctx)
+		assert.Equal(t, [3]int32{2, 4, 6}, v)
+	}
+	{
+		var ctx []int32
+		require.NoError(t, walker.Walk(&ctx, [0]int32{}))
+		assert.Empty(t, ctx)
+	}
+
+	ptrRegister := tw.NewRegister[*[]*int]()
+	tw.RegisterTypeFn(ptrRegister, func(*[]*int, tw.Arg[*int]) error { return nil })
+	tw.RegisterCompileArrayFn(ptrRegister, func(typ reflect.Type) tw.WalkArrayFn[*[]*int] {
+		return func(ctx *[]*int, a tw.Array[*[]*int]) error {
+			ps, ok := tw.ArrayAs[*int](a)
+			require.True(t, ok)
+			*ctx = append(*ctx, ps...)
+			return nil
+		}
+	})
+	{
+		// A single-pointer array is stored directly in an interface.
+		var ctx []*int
+		p := ptr(1)
+		require.NoError(t, tw.NewWalker(ptrRegister).Walk(&ctx, [1]*int{p}))
+		assert.Equal(t, []*int{p}, ctx)
+	}
+}
+
+func TestFieldAs(t *testing.T) {
+	type Y struct {
+		Z int
+	}
+	type S struct {
+		A int
+		B string
+		*Y
+	}
+
+	register := tw.NewRegister[struct{}]()
+	tw.RegisterTypeFn(register, func(struct{}, tw.Int) error { return nil })
+	tw.RegisterTypeFn(register, func(struct{}, tw.String) error { return nil })
+	var settable []bool
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[struct{}] {
+		a := sfr.RegisterField(0)
+		b := sfr.RegisterField(1)
+		z := sfr.RegisterFieldByIndex([]int{2, 0})
+		return func(ctx struct{}, s tw.Struct[struct{}]) error {
+			_, ok := tw.FieldAs[string](s, a)
+			assert.False(t, ok)
+
+			fa, ok := tw.FieldAs[int](s, a)
+			require.True(t, ok)
+			fb, ok := tw.FieldAs[string](s, b)
+			require.True(t, ok)
+			settable = append(settable, fa.CanSet(), fb.CanSet())
+			if fa.CanSet() {
+				fa.Set(fa.Get() + 1)
+				fb.Set(fb.Get() + "!")
+			}
+
+			fz, ok := tw.FieldAs[int](s, z)
+			if ok {
+				// Z is reached through a pointer, so it's always settable.
+				assert.True(t, fz.CanSet())
+				fz.Set(fz.Get() * 10)
+			}
+			return nil
+		}
+	})
+	walker := tw.NewWalker(register)
+
+	v := S{A: 1, B: "abc", Y: &Y{Z: 2}}
+	require.NoError(t, walker.Walk(struct{}{}, v))
+	assert.Equal(t, []bool{false, false}, settable)
+	assert.Equal(t, S{A: 1, B: "abc", Y: &Y{Z: 20}}, v)
+
+	settable = nil
+	typeFn, err := tw.TypeFnFor[S](walker)
+	require.NoError(t, err)
+	require.NoError(t, typeFn(struct{}{}, &v))
+	assert.Equal(t, []bool{true, true}, settable)
+	assert.Equal(t, S{A: 2, B: "abc!", Y: &Y{Z: 200}}, v)
+
+	// With Y nil, Z is not valid.
+	v = S{A: 1}
+	require.NoError(t, typeFn(struct{}{}, &v))
+	assert.Equal(t, S{A: 2, B: "!"}, v)
+}
+
+func TestWalkValue(t *testing.T) {
+	type S struct {
+		A int
+		B fmt.Stringer
+	}
+
+	register := tw.NewRegister[*[]string]()
+	tw.RegisterTypeFn(register, func(ctx *[]string, i tw.Int) error {
+		*ctx = append(*ctx, fmt.Sprintf("int %d settable=%t", i.Get(), i.CanSet()))
+		if i.CanSet() {
+			i.Set(i.Get() + 1)
+		}
+		return nil
+	})
+	tw.RegisterCompileInterfaceFn(register, func(typ reflect.Type) tw.WalkInterfaceFn[*[]string] {
+		return func(ctx *[]string, i tw.Interface[*[]string]) error {
+			*ctx = append(*ctx, fmt.Sprintf("iface %v", typ))
+			return nil
+		}
+	})
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*[]string] {
+		for i := 0; i < typ.NumField(); i++ {
+			sfr.RegisterField(i)
+		}
+		return func(ctx *[]string, s tw.Struct[*[]string]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	walker := tw.NewWalker(register)
+
+	{
+		var ctx []string
+		s := S{A: 1, B: StringWrapper("abc")}
+		require.NoError(t, walker.WalkValue(&ctx, reflect.ValueOf(&s).Elem()))
+		assert.Equal(t, []string{"int 1 settable=true", "iface fmt.Stringer"}, ctx)
+		assert.Equal(t, 2, s.A)
+	}
+	{
+		var ctx []string
+		s := S{A: 1, B: StringWrapper("abc")}
+		require.NoError(t, walker.WalkValue(&ctx, reflect.ValueOf(s)))
+		assert.Equal(t, []string{"int 1 settable=false", "iface fmt.Stringer"}, ctx)
+		assert.Equal(t, 1, s.A)
+	}
+	{
+		// The static interface type is walked, not the dynamic type.
+		var ctx []string
+		s := S{A: 1, B: StringWrapper("abc")}
+		require.NoError(t, walker.WalkValue(&ctx, reflect.ValueOf(s).Field(1)))
+		assert.Equal(t, []string{"iface fmt.Stringer"}, ctx)
+	}
+}
+
+func TestValue(t *testing.T) {
+	type S struct {
+		A int
+		P *int
+		L []int
+		R [2]int
+		M map[string]int
+		I fmt.Stringer
+	}
+
+	var values []reflect.Value
+	register := tw.NewRegister[struct{}]()
+	tw.RegisterTypeFn(register, func(ctx struct{}, i tw.Int) error {
+		values = append(values, i.Value())
+		return nil
+	})
+	tw.RegisterTypeFn(register, func(ctx struct{}, s tw.String) error {
+		values = append(values, s.Value())
+		return nil
+	})
+	tw.RegisterCompilePtrFn(register, func(typ reflect.Type) tw.WalkPtrFn[struct{}] {
+		return func(ctx struct{}, p tw.Ptr[struct{}]) error {
+			values = append(values, p.Value())
+			return nil
+		}
+	})
+	tw.RegisterCompileSliceFn(register, func(typ reflect.Type) tw.WalkSliceFn[struct{}] {
+		return func(ctx struct{}, s tw.Slice[struct{}]) error {
+			values = append(values, s.Value())
+			return nil
+		}
+	})
+	tw.RegisterCompileArrayFn(register, func(typ reflect.Type) tw.WalkArrayFn[struct{}] {
+		return func(ctx struct{}, a tw.Array[struct{}]) error {
+			values = append(values, a.Value())
+			return nil
+		}
+	})
+	tw.RegisterCompileMapFn(register, func(typ reflect.Type) tw.WalkMapFn[struct{}] {
+		return func(ctx struct{}, m tw.Map[struct{}]) error {
+			values = append(values, m.Value())
+			return nil
+		}
+	})
+	tw.RegisterCompileInterfaceFn(register, func(typ reflect.Type) tw.WalkInterfaceFn[struct{}] {
+		return func(ctx struct{}, i tw.Interface[struct{}]) error {
+			values = append(values, i.Value())
+			return nil
+		}
+	})
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[struct{}] {
+		for i := 0; i < typ.NumField(); i++ {
+			sfr.RegisterField(i)
+		}
+		return func(ctx struct{}, s tw.Struct[struct{}]) error {
+			values = append(values, s.Value())
+			return s.WalkAll(ctx)
+		}
+	})
+	walker := tw.NewWalker(register)
+
+	newS := func() S {
+		return S{A: 1, P: ptr(2), L: []int{3}, R: [2]int{4, 5}, M: map[string]int{"a": 6}, I: StringWrapper("abc")}
+	}
+	types := []reflect.Type{
+		reflect.TypeOf(S{}),
+		reflect.TypeOf(0),
+		reflect.TypeOf(ptr(0)),
+		reflect.TypeOf([]int{}),
+		reflect.TypeOf([2]int{}),
+		reflect.TypeOf(map[string]int{}),
+		reflect.TypeOf((*fmt.Stringer)(nil)).Elem(),
+	}
+
+	t.Run("fromInterface", func(t *testing.T) {
+		values = nil
+		s := newS()
+		require.NoError(t, walker.Walk(struct{}{}, s))
+		require.Len(t, values, len(types))
+		for i, v := range values {
+			assert.Equal(t, types[i], v.Type())
+			assert.False(t, v.CanAddr())
+		}
+		assert.Equal(t, s, values[0].Interface())
+		assert.Equal(t, StringWrapper("abc"), values[6].Interface())
+	})
+
+	t.Run("fromPointer", func(t *testing.T) {
+		values = nil
+		s := newS()
+		typeFn, err := tw.TypeFnFor[S](walker)
+		require.NoError(t, err)
+		require.NoError(t, typeFn(struct{}{}, &s))
+		require.Len(t, values, len(types))
+		for i, v := range values {
+			assert.Equal(t, types[i], v.Type())
+			assert.True(t, v.CanSet())
+		}
+
+		values[1].SetInt(10)
+		values[2].Set(reflect.ValueOf(ptr(20)))
+		values[3].Index(0).SetInt(30)
+		values[4].Index(1).SetInt(50)
+		values[5].SetMapIndex(reflect.ValueOf("b"), reflect.ValueOf(60))
+		values[6].Set(reflect.ValueOf(StringWrapper("def")))
+		assert.Equal(t, S{A: 10, P: ptr(20), L: []int{30}, R: [2]int{4, 50}, M: map[string]int{"a": 6, "b": 60}, I: StringWrapper("def")}, s)
+		assert.Equal(t, s, values[0].Interface())
+	})
+
+	t.Run("mapValue", func(t *testing.T) {
+		values = nil
+		mapRegister := register.Clone()
+		tw.RegisterCompileMapFn(mapRegister, func(typ reflect.Type) tw.WalkMapFn[struct{}] {
+			return func(ctx struct{}, m tw.Map[struct{}]) error {
+				return m.WalkAll(ctx)
+			}
+		})
+		require.NoError(t, tw.NewWalker(mapRegister).Walk(struct{}{}, map[int]fmt.Stringer{1: StringWrapper("abc")}))
+		require.Len(t, values, 2)
+		assert.Equal(t, 1, values[0].Interface())
+		assert.Equal(t, types[6], values[1].Type())
+		assert.False(t, values[1].CanAddr())
+		assert.Equal(t, StringWrapper("abc"), values[1].Interface())
+	})
+}
+
+func TestWalkUnsafe(t *testing.T) {
+	register := tw.NewRegister[*[]string]()
+	tw.RegisterTypeFn(register, func(ctx *[]string, i tw.Int) error {
+		*ctx = append(*ctx, fmt.Sprintf("int %d settable=%t", i.Get(), i.CanSet()))
+		if i.CanSet() {
+			i.Set(i.Get() + 1)
+		}
+		return nil
+	})
+	tw.RegisterTypeFn(register, func(ctx *[]string, s tw.Arg[fmt.Stringer]) error {
+		*ctx = append(*ctx, "stringer "+s.Get().String())
+		return nil
+	})
+	tw.RegisterCompileSliceFn(register, func(typ reflect.Type) tw.WalkSliceFn[*[]string] {
+		return func(ctx *[]string, s tw.Slice[*[]string]) error {
+			return s.WalkAll(ctx)
+		}
+	})
+	walker := tw.NewWalker(register)
+
+	{
+		var ctx []string
+		s := []int{1, 2}
+		require.NoError(t, walker.WalkUnsafe(&ctx, reflect.TypeOf(s), unsafe.Pointer(&s), true))
+		assert.Equal(t, []string{"int 1 settable=true", "int 2 settable=true"}, ctx)
+		assert.Equal(t, []int{2, 3}, s)
+	}
+	{
+		var ctx []string
+		i := 1
+		require.NoError(t, walker.WalkUnsafe(&ctx, reflect.TypeOf(i), unsafe.Pointer(&i), false))
+		assert.Equal(t, []string{"int 1 settable=false"}, ctx)
+		assert.Equal(t, 1, i)
+	}
+	{
+		var ctx []string
+		var s fmt.Stringer = StringWrapper("abc")
+		require.NoError(t, walker.WalkUnsafe(&ctx, reflect.TypeOf(&s).Elem(), unsafe.Pointer(&s), false))
+		assert.Equal(t, []string{"stringer abc"}, ctx)
+	}
+	{
+		var ctx []string
+		f := 1.5
+		assert.Error(t, walker.WalkUnsafe(&ctx, reflect.TypeOf(f), unsafe.Pointer(&f), false))
+	}
+}
+
+func TestFlattenedStructs(t *testing.T) {
+	type Point struct {
+		X, Y int
+		Z    int `walk:"-"`
+	}
+	type Rect struct {
+		Min, Max Point
+	}
+	type Named struct {
+		Name string
+		Time time.Duration
+	}
+	type Shape struct {
+		ID     int
+		Bounds Rect
+		Label  Named
+		Ptr    *Point
+		Empty  struct{}
+	}
+
+	var walked []string
+	register := tw.NewRegister[*[]string]()
+	tw.RegisterTypeFn(register, func(ctx *[]string, i tw.Int) error {
+		*ctx = append(*ctx, fmt.Sprint(i.Get()))
+		if i.CanSet() {
+			i.Set(i.Get() * 10)
+		}
+		return nil
+	})
+	tw.RegisterTypeFn(register, func(ctx *[]string, s tw.String) error {
+		*ctx = append(*ctx, s.Get())
+		return nil
+	})
+	tw.RegisterTypeFn(register, func(ctx *[]string, d tw.Arg[time.Duration]) error {
+		*ctx = append(*ctx, d.Get().String())
+		return nil
+	})
+	tw.RegisterTypeFn(register, func(ctx *[]string, p tw.Arg[Named]) error {
+		*ctx = append(*ctx, "named "+p.Get().Name)
+		return nil
+	})
+	tw.RegisterCompilePtrFn(register, func(typ reflect.Type) tw.WalkPtrFn[*[]string] {
+		return func(ctx *[]string, p tw.Ptr[*[]string]) error {
+			if p.IsNil() {
+				return nil
+			}
+			return p.Walk(ctx)
+		}
+	})
+	var indexes map[reflect.Type][][]int
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[*[]string] {
+		for i := 0; i < typ.NumField(); i++ {
+			if typ.Field(i).Tag.Get("walk") != "-" {
+				sfr.RegisterField(i)
+			}
+		}
+		indexes[typ] = nil
+		for i := 0; i < sfr.NumFields(); i++ {
+			indexes[typ] = append(indexes[typ], sfr.FieldIndex(i))
+		}
+		return func(ctx *[]string, s tw.Struct[*[]string]) error {
+			walked = append(walked, typ.Name())
+			return s.WalkAll(ctx)
+		}
+	})
+
+	newShape := func() Shape {
+		return Shape{
+			ID:     1,
+			Bounds: Rect{Min: Point{2, 3, -1}, Max: Point{4, 5, -1}},
+			Label:  Named{Name: "abc", Time: time.Second},
+			Ptr:    &Point{6, 7, -1},
+		}
+	}
+	for _, flatten := range []bool{false, true} {
+		walked = nil
+		indexes = map[reflect.Type][][]int{}
+		var opts []tw.WalkerOpt
+		if flatten {
+			opts = append(opts, tw.WithFlattenedStructs)
+		}
+		walker := tw.NewWalker(register, opts...)
+
+		var ctx []string
+		require.NoError(t, walker.Walk(&ctx, newShape()))
+		assert.Equal(t, []string{"1", "2", "3", "4", "5", "named abc", "6", "7"}, ctx)
+
+		if !flatten {
+			assert.Equal(t, []string{"Shape", "Rect", "Point", "Point", "Point", ""}, walked)
+			assert.Equal(t, [][]int{{0}, {1}, {2}, {3}, {4}}, indexes[reflect.TypeOf(Shape{})])
+			continue
+		}
+		// Rect and its Points are flattened, but Named is registered and the empty struct has no fields. Only the
+		// fields of Point its CompileStructFn registers replace it. Point is still walked through Ptr.
+		assert.Equal(t, []string{"Shape", "Point", ""}, walked)
+		assert.Equal(t, [][]int{{0}, {1, 0, 0}, {1, 0, 1}, {1, 1, 0}, {1, 1, 1}, {2}, {3}, {4}}, indexes[reflect.TypeOf(Shape{})])
+
+		// Flattened fields are settable at their offsets.
+		ctx = nil
+		cp := newShape()
+		fn, err := tw.TypeFnFor[Shape](walker)
+		require.NoError(t, err)
+		require.NoError(t, fn(&ctx, &cp))
+		assert.Equal(t, Rect{Min: Point{20, 30, -1}, Max: Point{40, 50, -1}}, cp.Bounds)
+	}
+}
+
+func TestAlloc(t *testing.T) {
+	type S struct {
+		P *int
+		L []int
+		M map[string]int
+	}
+
+	register := tw.NewRegister[struct{}]()
+	tw.RegisterTypeFn(register, func(ctx struct{}, i tw.Int) error {
+		i.Set(i.Get() + 1)
+		return nil
+	})
+	tw.RegisterTypeFn(register, func(ctx struct{}, s tw.String) error {
+		return nil
+	})
+	tw.RegisterCompileStructFn(register, func(typ reflect.Type, sfr tw.StructFieldRegister) tw.WalkStructFn[struct{}] {
+		for i := 0; i < typ.NumField(); i++ {
+			sfr.RegisterField(i)
+		}
+		return func(ctx struct{}, s tw.Struct[struct{}]) error {
+			for i := 0; i < s.NumFields(); i++ {
+				if err := s.Field(i).Walk(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+	tw.RegisterCompilePtrFn(register, func(typ reflect.Type) tw.WalkPtrFn[struct{}] {
+		return func(ctx struct{}, p tw.Ptr[struct{}]) error {
+			if !p.CanSet() {
+				return p.Walk(ctx)
+			}
+			if !p.IsNil() {
+				p.SetNil()
+				return nil
+			}
+			p.Alloc()
+			return p.Walk(ctx)
+		}
+	})
+	tw.RegisterCompileSliceFn(register, func(typ reflect.Type) tw.WalkSliceFn[struct{}] {
+		return func(ctx struct{}, s tw.Slice[struct{}]) error {
+			if s.Len() > 2 {
+				s.SetNil()
+				return nil
+			}
+			s.SetLen(s.Len() + 2)
+			return s.WalkAll(ctx)
+		}
+	})
+	tw.RegisterCompileMapFn(register, func(typ reflect.Type) tw.WalkMapFn[struct{}] {
+		return func(ctx struct{}, m tw.Map[struct{}]) error {
+			if m.Len() > 0 {
+				m.SetNil()
+				return nil
+			}
+			if m.IsNil() {
+				m.Alloc()
+			}
+			m.SetIndex(reflect.ValueOf("len"), reflect.ValueOf(m.Len()))
+			return nil
+		}
+	})
+	walker := tw.NewWalker(register)
+
+	var s S
+	require.NoError(t, walker.Walk(struct{}{}, &s))
+	assert.Equal(t, S{P: ptr(1), L: []int{1, 1}, M: map[string]int{"len": 0}}, s)
+
+	require.NoError(t, walker.Walk(struct{}{}, &s))
+	assert.Equal(t, S{L: []int{2, 2, 1, 1}}, s)
+
+	require.NoError(t, walker.Walk(struct{}{}, &s))
+	assert.Equal(t, S{P: ptr(1), M: map[string]int{"len": 0}}, s)
+
+	// Shrinking and regrowing a slice within its capacity zeroes the exposed elements.
+	l := make([]int, 1, 4)
+	l[0] = 5
+	stale := l[:4]
+	stale[1], stale[2] = 7, 7
+	require.NoError(t, walker.Walk(struct{}{}, &l))
+	assert.Equal(t, []int{6, 1, 1}, l)
+	assert.Equal(t, 4, cap(l))
+
+	// An unaddressable pointer can't be set, but the value it points at can.
+	p := ptr(3)
+	require.NoError(t, walker.Walk(struct{}{}, p))
+	assert.Equal(t, 4, *p)
+
+	// Entries can be set in an unaddressable map, but the map itself can't be replaced.
+	m := map[string]int{}
+	require.NoError(t, walker.Walk(struct{}{}, m))
+	assert.Equal(t, map[string]int{"len": 0}, m)
+	assert.Panics(t, func() {
+		_ = walker.Walk(struct{}{}, map[string]int(nil))
+	})
+}